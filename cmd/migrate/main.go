@@ -0,0 +1,171 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     main
+ * @date        2018-01-25 19:19
+ */
+//MySQL数据库结构的版本管理工具，见mysql.Migrator
+//
+//	migrate -dsn "user:pass@tcp(127.0.0.1:3306)/test" -dir ./migrations up
+//	migrate -conf db.yaml down 1
+//	MYSQL_DSN=... migrate to 20180125
+//	migrate status
+//	migrate -dir ./migrations create add_user_name
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/liuyongshuai/goutils/mysql"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: migrate [flags] command
+
+commands:
+  up             执行所有未执行的版本
+  down [N]       回滚最近的N个版本，默认1个
+  to VERSION     执行或回滚到指定的版本，0为全部回滚
+  status         查看所有版本的状态
+  create NAME    在-dir下生成新的变更文件，版本号为当前时间
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+//参数不对，打印用法后退出
+var errUsage = errors.New("usage")
+
+func main() {
+	if err := run(); err != nil {
+		if err == errUsage {
+			usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+//出错时返回错误，由main统一退出，保证defer的关闭连接等都能执行
+func run() error {
+	dsn := flag.String("dsn", "", "MySQL的DSN，如user:pass@tcp(127.0.0.1:3306)/test")
+	confFile := flag.String("conf", "", "配置文件，支持.json、.yaml、.yml")
+	envPrefix := flag.String("env", "MYSQL_", "没有-dsn、-conf时从这个前缀的环境变量里读配置")
+	dir := flag.String("dir", "./migrations", "变更文件所在的目录")
+	table := flag.String("table", "schema_migrations", "记录版本的表")
+	lockTimeout := flag.Duration("lock-timeout", 10*time.Second, "等锁的时间")
+	debug := flag.Bool("debug", false, "打印执行的SQL")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) <= 0 {
+		return errUsage
+	}
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errUsage
+		}
+		return create(*dir, args[1])
+	}
+
+	conf, err := loadConf(*dsn, *confFile, *envPrefix)
+	if err != nil {
+		return err
+	}
+	db, err := mysql.NewDBase(conf).Conn()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetDebug(*debug)
+
+	m := mysql.NewMigrator(db)
+	m.Table = *table
+	m.LockTimeout = *lockTimeout
+	if err = m.LoadDir(*dir); err != nil {
+		return err
+	}
+
+	//Ctrl+C时取消，正在执行的语句执行完后停止
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var done []int64
+	switch args[0] {
+	case "up":
+		done, err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return err
+			}
+		}
+		done, err = m.Down(ctx, steps)
+	case "to":
+		if len(args) != 2 {
+			return errUsage
+		}
+		version, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return perr
+		}
+		done, err = m.To(ctx, version)
+	case "status":
+		var st []mysql.MigrationStatus
+		st, err = m.Status(ctx)
+		for _, s := range st {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Missing {
+				state += " (missing)"
+			}
+			fmt.Printf("%-16d %-40s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return errUsage
+	}
+	for _, v := range done {
+		fmt.Printf("%s %d\n", args[0], v)
+	}
+	return err
+}
+
+//配置的优先级：-dsn、-conf、环境变量
+func loadConf(dsn, confFile, envPrefix string) (mysql.MySQLConf, error) {
+	if len(dsn) > 0 {
+		return mysql.ParseDSN(dsn)
+	}
+	if len(confFile) > 0 {
+		return mysql.LoadMySQLConfFile(confFile)
+	}
+	return mysql.LoadMySQLConfFromEnv(envPrefix)
+}
+
+//生成一对空的变更文件
+func create(dir, name string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	version := time.Now().Format("20060102150405")
+	for _, kind := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, kind))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		fmt.Println(path)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"math"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 func MakeItemElem(d interface{}) ItemElem {
@@ -179,9 +180,11 @@ func (ie ItemElem) ToUint64() (uint64, error) {
 	}
 }
 
-//转换为string类型
+//转换为string类型，time.Time格式为"2006-01-02 15:04:05.999999"，保留微秒
 func (ie ItemElem) ToString() string {
 	switch ie.RefVal.Kind() {
+	case reflect.Invalid: //nil
+		return ""
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		tmp := strconv.FormatInt(ie.RefVal.Int(), 10)
 		return tmp
@@ -191,9 +194,23 @@ func (ie ItemElem) ToString() string {
 	case reflect.Float32, reflect.Float64:
 		tmp := strconv.FormatFloat(ie.RefVal.Float(), 'f', -1, 64)
 		return tmp
-	default:
+	case reflect.Bool:
+		return strconv.FormatBool(ie.RefVal.Bool())
+	case reflect.Slice:
+		if ie.RefVal.Type().Elem().Kind() == reflect.Uint8 {
+			return string(ie.RefVal.Bytes())
+		}
+	case reflect.String:
 		return ie.RefVal.String()
+	case reflect.Struct:
+		if tm, ok := ie.Data.(time.Time); ok {
+			return tm.Format("2006-01-02 15:04:05.999999")
+		}
 	}
+	if s, ok := ie.Data.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ie.RefVal.String()
 }
 
 //转换为float类型
@@ -403,7 +420,12 @@ func (ie ItemElem) IsBool() bool {
 
 //是否为字符切片
 func (ie ItemElem) IsByteSlice() bool {
-	return reflect.TypeOf(ie.Data).String() == "[]uint8"
+	return ie.Data != nil && reflect.TypeOf(ie.Data).String() == "[]uint8"
+}
+
+//是否为nil，如MySQL里的NULL
+func (ie ItemElem) IsNull() bool {
+	return ie.Data == nil
 }
 
 //是否为简单类型：int/uint/string/bool/float....
@@ -0,0 +1,377 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//请求认证，在请求发出前设置认证信息
+type ToFuAuth interface {
+	Apply(req *http.Request) error
+}
+
+//实现了此接口的认证方式，收到401时会先调用Invalidate再重试一次
+type authInvalidator interface {
+	Invalidate()
+}
+
+//固定的Bearer token
+type bearerAuth string
+
+func (a bearerAuth) Apply(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(a))
+	return nil
+}
+
+//固定的Bearer token认证
+func BearerAuth(token string) ToFuAuth {
+	return bearerAuth(token)
+}
+
+//basic认证
+type basicAuth struct {
+	user   string
+	passwd string
+}
+
+func (a basicAuth) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.passwd)
+	return nil
+}
+
+//basic认证
+func BasicAuth(user, passwd string) ToFuAuth {
+	return basicAuth{user: user, passwd: passwd}
+}
+
+//设置认证方式
+func (httpReq *ToFuHttp) SetAuth(a ToFuAuth) *ToFuHttp {
+	httpReq.auth = a
+	httpReq.buildTransport()
+	return httpReq
+}
+
+//设置固定的Bearer token
+func (httpReq *ToFuHttp) SetBearerToken(token string) *ToFuHttp {
+	return httpReq.SetAuth(BearerAuth(token))
+}
+
+//设置basic认证
+func (httpReq *ToFuHttp) SetBasicAuth(user, passwd string) *ToFuHttp {
+	return httpReq.SetAuth(BasicAuth(user, passwd))
+}
+
+//OAuth2获取到的token
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int64     `json:"expires_in"`
+	Expiry       time.Time `json:"-"` //过期时间，为零值表示不过期
+}
+
+//是否已过期或在early之内过期
+func (t *OAuth2Token) expired(early time.Duration) bool {
+	if t == nil || len(t.AccessToken) == 0 {
+		return true
+	}
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return !time.Now().Add(early).Before(t.Expiry)
+}
+
+//OAuth2认证，支持client_credentials及refresh_token两种方式
+//token缓存到过期前才重新获取，收到401时丢弃缓存的token重试一次
+type ToFuOAuth2 struct {
+	lock         *sync.Mutex
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	refreshToken string        //设置了时用refresh_token方式
+	authInParams bool          //client_id、client_secret放在body里，默认用basic认证
+	early        time.Duration //提前多久刷新
+	client       *http.Client  //请求token用的client
+	token        *OAuth2Token
+}
+
+//实例化一个OAuth2认证，默认提前30秒刷新token
+func NewOAuth2(tokenURL, clientID, clientSecret string, scopes ...string) *ToFuOAuth2 {
+	return &ToFuOAuth2{
+		lock:         new(sync.Mutex),
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		early:        30 * time.Second,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+//设置refresh token，之后用refresh_token方式获取token
+func (o *ToFuOAuth2) SetRefreshToken(rt string) *ToFuOAuth2 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.refreshToken = rt
+	o.token = nil
+	return o
+}
+
+//client_id、client_secret放在请求body里而不是basic认证的header里
+func (o *ToFuOAuth2) SetAuthInParams(b bool) *ToFuOAuth2 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.authInParams = b
+	return o
+}
+
+//设置提前多久刷新token
+func (o *ToFuOAuth2) SetEarlyRefresh(d time.Duration) *ToFuOAuth2 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.early = d
+	return o
+}
+
+//设置请求token用的client
+func (o *ToFuOAuth2) SetHttpClient(c *http.Client) *ToFuOAuth2 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.client = c
+	return o
+}
+
+//提取当前的refresh token，服务端轮换了refresh token时会更新，可以保存下来下次用
+func (o *ToFuOAuth2) GetRefreshToken() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.refreshToken
+}
+
+//提取可用的token，缓存的快过期了就重新获取
+func (o *ToFuOAuth2) Token(ctx context.Context) (OAuth2Token, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if !o.token.expired(o.early) {
+		return *o.token, nil
+	}
+	tk, err := o.fetchToken(ctx)
+	if err != nil {
+		return OAuth2Token{}, err
+	}
+	o.token = tk
+	return *tk, nil
+}
+
+//实现ToFuAuth接口
+func (o *ToFuOAuth2) Apply(req *http.Request) error {
+	tk, err := o.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	tokenType := tk.TokenType
+	if len(tokenType) == 0 || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+tk.AccessToken)
+	return nil
+}
+
+//丢弃缓存的token，下次请求时重新获取
+func (o *ToFuOAuth2) Invalidate() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.token = nil
+}
+
+//请求token，调用方需持有锁
+func (o *ToFuOAuth2) fetchToken(ctx context.Context) (*OAuth2Token, error) {
+	vals := make(url.Values)
+	if len(o.refreshToken) > 0 {
+		vals.Set("grant_type", "refresh_token")
+		vals.Set("refresh_token", o.refreshToken)
+	} else {
+		vals.Set("grant_type", "client_credentials")
+	}
+	if len(o.scopes) > 0 {
+		vals.Set("scope", strings.Join(o.scopes, " "))
+	}
+	if o.authInParams {
+		vals.Set("client_id", o.clientID)
+		vals.Set("client_secret", o.clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(vals.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !o.authInParams {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: fetch token failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: read token failed: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("oauth2: fetch token failed: %s %s", resp.Status, body)
+	}
+	tk := &OAuth2Token{}
+	if err = json.Unmarshal(body, tk); err != nil {
+		return nil, fmt.Errorf("oauth2: invalid token response: %v", err)
+	}
+	if len(tk.AccessToken) == 0 {
+		return nil, fmt.Errorf("oauth2: no access_token in response: %s", body)
+	}
+	if tk.ExpiresIn > 0 {
+		tk.Expiry = time.Now().Add(time.Duration(tk.ExpiresIn) * time.Second)
+	}
+	//服务端轮换了refresh token
+	if len(tk.RefreshToken) > 0 {
+		o.refreshToken = tk.RefreshToken
+	}
+	return tk, nil
+}
+
+//HMAC签名认证
+//待签名的字符串为：方法\n路径及查询参数\n时间戳\n随机串\nbody的sha256
+//签名结果用base64编码后连同key、时间戳、随机串放到header里
+type ToFuHMACSigner struct {
+	keyID  string
+	secret []byte
+	prefix string //header名称的前缀，默认"X-Auth-"
+}
+
+//实例化一个HMAC-SHA256签名认证
+func NewHMACSigner(keyID, secret string) *ToFuHMACSigner {
+	return &ToFuHMACSigner{keyID: keyID, secret: []byte(secret), prefix: "X-Auth-"}
+}
+
+//设置header名称的前缀，如"X-Api-"对应"X-Api-Key"、"X-Api-Timestamp"等
+func (s *ToFuHMACSigner) SetHeaderPrefix(prefix string) *ToFuHMACSigner {
+	s.prefix = prefix
+	return s
+}
+
+//实现ToFuAuth接口
+func (s *ToFuHMACSigner) Apply(req *http.Request) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nb := make([]byte, 8)
+	if _, err := rand.Read(nb); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nb)
+	req.Header.Set(s.prefix+"Key", s.keyID)
+	req.Header.Set(s.prefix+"Timestamp", ts)
+	req.Header.Set(s.prefix+"Nonce", nonce)
+	req.Header.Set(s.prefix+"Signature", s.Sign(req.Method, req.URL.RequestURI(), ts, nonce, body))
+	return nil
+}
+
+//计算签名，服务端可以用同样的方法校验
+func (s *ToFuHMACSigner) Sign(method, requestURI, ts, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	str := strings.Join([]string{strings.ToUpper(method), requestURI, ts, nonce, hex.EncodeToString(sum[:])}, "\n")
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(str))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//带认证的transport
+type authTransport struct {
+	next http.RoundTripper
+	auth ToFuAuth
+}
+
+//实现http.RoundTripper接口，401时丢弃缓存的凭证重试一次
+//重定向到别的host时不带凭证，避免泄露给第三方
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !sameHost(req, originalRequest(req)) {
+		return t.next.RoundTrip(req)
+	}
+	r2 := req.Clone(req.Context())
+	if err := t.auth.Apply(r2); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(r2)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	inv, ok := t.auth.(authInvalidator)
+	if !ok {
+		return resp, err
+	}
+	//body没办法重新生成的不能重试
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && req.GetBody == nil {
+		return resp, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	inv.Invalidate()
+
+	r3 := req.Clone(req.Context())
+	if hasBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r3.Body = body
+	}
+	if err = t.auth.Apply(r3); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(r3)
+}
+
+//重定向链上最开始的请求
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+//两个请求的host（含端口）是否相同
+func sameHost(a, b *http.Request) bool {
+	return strings.EqualFold(a.URL.Host, b.URL.Host)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestToFuOAuth2(t *testing.T) {
+	fmt.Println(t.Name())
+	var issued int32
+	var lock sync.Mutex
+	var grants []string
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "cid" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		lock.Lock()
+		grants = append(grants, r.PostForm.Get("grant_type")+":"+r.PostForm.Get("refresh_token")+":"+r.PostForm.Get("scope"))
+		lock.Unlock()
+		n := atomic.AddInt32(&issued, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("tk%d", n),
+			"token_type":    "bearer",
+			"expires_in":    3600,
+			"refresh_token": fmt.Sprintf("rt%d", n),
+		})
+	}))
+	defer tokenSrv.Close()
+
+	//tk1被服务端吊销，用它请求返回401
+	var revoked int32 = 1
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "Bearer tk1" && atomic.LoadInt32(&revoked) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s|%s", auth, body)
+	}))
+	defer apiSrv.Close()
+
+	oauth := NewOAuth2(tokenSrv.URL, "cid", "secret", "read", "write")
+	client := NewHttpClient().SetAuth(oauth).SetUrl(apiSrv.URL)
+	resp, err := client.AddField("a", "1").PostBin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//第一次拿到的tk1返回401，丢弃后重新获取tk2，body要重发
+	if s := resp.GetBodyString(); s != "Bearer tk2|a=1" {
+		t.Errorf("unexpected body %q", s)
+	}
+	//缓存的token可以复用
+	for i := 0; i < 3; i++ {
+		if _, err = client.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if issued != 2 {
+		t.Errorf("issued %d tokens, want 2", issued)
+	}
+	if oauth.GetRefreshToken() != "rt2" {
+		t.Errorf("refresh token %q", oauth.GetRefreshToken())
+	}
+	if grants[0] != "client_credentials::read write" {
+		t.Errorf("unexpected grant %q", grants[0])
+	}
+
+	//快过期时用refresh token重新获取
+	oauth.SetEarlyRefresh(2 * time.Hour)
+	if _, err = oauth.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if g := grants[len(grants)-1]; g != "refresh_token:rt2:read write" {
+		t.Errorf("unexpected grant %q", g)
+	}
+	if oauth.GetRefreshToken() != "rt3" {
+		t.Errorf("rotated refresh token %q", oauth.GetRefreshToken())
+	}
+
+	//获取token失败时返回错误
+	bad := NewOAuth2(tokenSrv.URL, "cid", "wrong")
+	if _, err = NewHttpClient().SetAuth(bad).SetUrl(apiSrv.URL).Get(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected token error, got %v", err)
+	}
+}
+
+func TestToFuHMACSigner(t *testing.T) {
+	fmt.Println(t.Name())
+	signer := NewHMACSigner("key1", "s3cret")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sign := signer.Sign(r.Method, r.URL.RequestURI(), r.Header.Get("X-Auth-Timestamp"), r.Header.Get("X-Auth-Nonce"), body)
+		if r.Header.Get("X-Auth-Key") != "key1" || sign != r.Header.Get("X-Auth-Signature") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, "%s", body)
+	}))
+	defer ts.Close()
+
+	resp, err := NewHttpClient().SetAuth(signer).SetUrl(ts.URL+"/path?x=1").AddField("k", "v").PostBin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatusCode() != 200 || resp.GetBodyString() != "k=v" {
+		t.Errorf("unexpected response %d %q", resp.GetStatusCode(), resp.GetBodyString())
+	}
+	//签名与body相关
+	a := signer.Sign("POST", "/p", "1", "n", []byte("a"))
+	b := signer.Sign("POST", "/p", "1", "n", []byte("b"))
+	if a == b {
+		t.Errorf("signature should depend on body")
+	}
+}
+
+func TestToFuHttp_StaticAuth(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+	resp, err := NewHttpClient().SetBearerToken("abc").SetUrl(ts.URL).Get()
+	if err != nil || resp.GetBodyString() != "Bearer abc" {
+		t.Errorf("bearer: %v %q", err, resp.GetBodyString())
+	}
+	resp, err = NewHttpClient().SetBasicAuth("u", "p").SetUrl(ts.URL).Get()
+	if err != nil || resp.GetBodyString() != "Basic dTpw" {
+		t.Errorf("basic: %v %q", err, resp.GetBodyString())
+	}
+}
+
+func TestToFuHttp_AuthRedirect(t *testing.T) {
+	fmt.Println(t.Name())
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "other:"+r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/other":
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		case "/self":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		default:
+			fmt.Fprint(w, "self:"+r.Header.Get("Authorization"))
+		}
+	}))
+	defer ts.Close()
+
+	//同一个host的重定向还带着凭证，跨host的不带
+	resp, err := NewHttpClient().SetBearerToken("abc").SetUrl(ts.URL + "/self").Get()
+	if err != nil || resp.GetBodyString() != "self:Bearer abc" {
+		t.Errorf("same host redirect: %v %q", err, resp.GetBodyString())
+	}
+	resp, err = NewHttpClient().SetBearerToken("abc").SetUrl(ts.URL + "/other").Get()
+	if err != nil || resp.GetBodyString() != "other:" {
+		t.Errorf("cross host redirect: %v %q", err, resp.GetBodyString())
+	}
+}
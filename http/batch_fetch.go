@@ -0,0 +1,249 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//失败数达到上限时返回的错误
+var ErrTooManyFailures = errors.New("batch fetch: too many failures")
+
+//批量抓取时单个请求的描述
+type FetchSpec struct {
+	Method    string            //请求方法，默认GET
+	URL       string            //请求的地址
+	Header    map[string]string //额外的头信息
+	Fields    map[string]string //提交的字段，GET、HEAD时拼到URL的查询参数里
+	Multipart bool              //是否用multipart/form-data提交，默认为x-www-form-urlencoded
+	Tag       interface{}       //调用方自定义的数据，原样放到结果里
+}
+
+//单个请求的结果
+type FetchResult struct {
+	Index    int           //在输入里的序号，从0开始
+	Spec     FetchSpec     //请求的描述
+	Response ToFuResponse  //响应
+	Err      error         //出错信息
+	Start    time.Time     //开始的时间
+	Duration time.Duration //耗时，包括限流等待、重试的时间
+}
+
+//批量抓取器，在ToFuHttp基础上并发请求
+//所有请求共用client的cookie罐子、限流、熔断、重试、header等设置
+type ToFuBatchFetcher struct {
+	client      *ToFuHttp
+	concurrency int
+	deadline    time.Duration
+	maxFailures int
+	isFailure   func(r FetchResult) bool
+}
+
+//一次批量抓取
+type ToFuBatchRun struct {
+	results chan FetchResult
+	cancel  context.CancelFunc
+	stop    chan struct{} //调用Cancel后关闭，不再往results里写
+	once    *sync.Once
+	lock    *sync.Mutex
+	err     error
+	total   int
+	failed  int
+}
+
+//实例化一个批量抓取器，默认并发数为10，不限制总时长及失败数
+func NewBatchFetcher(client *ToFuHttp) *ToFuBatchFetcher {
+	return &ToFuBatchFetcher{
+		client:      client,
+		concurrency: 10,
+		isFailure: func(r FetchResult) bool {
+			return r.Err != nil
+		},
+	}
+}
+
+//设置并发数
+func (f *ToFuBatchFetcher) SetConcurrency(n int) *ToFuBatchFetcher {
+	if n <= 0 {
+		n = 1
+	}
+	f.concurrency = n
+	return f
+}
+
+//设置整批请求的最长时间，到时间后未完成的请求都会被取消
+func (f *ToFuBatchFetcher) SetDeadline(d time.Duration) *ToFuBatchFetcher {
+	f.deadline = d
+	return f
+}
+
+//设置最多允许失败的请求数，达到后停止剩下的请求，<=0表示不限制
+func (f *ToFuBatchFetcher) SetMaxFailures(n int) *ToFuBatchFetcher {
+	f.maxFailures = n
+	return f
+}
+
+//设置判断失败的方法，默认只有出错的算失败，如需要把4XX、5XX也算上可以自己设置
+func (f *ToFuBatchFetcher) SetFailureCheck(fn func(r FetchResult) bool) *ToFuBatchFetcher {
+	if fn != nil {
+		f.isFailure = fn
+	}
+	return f
+}
+
+//抓取列表里的所有请求，结果按完成的顺序返回
+func (f *ToFuBatchFetcher) Fetch(ctx context.Context, specs []FetchSpec) *ToFuBatchRun {
+	ch := make(chan FetchSpec, len(specs))
+	for _, s := range specs {
+		ch <- s
+	}
+	close(ch)
+	return f.FetchChan(ctx, ch)
+}
+
+//抓取channel里的请求直到channel关闭，结果按完成的顺序返回
+func (f *ToFuBatchFetcher) FetchChan(ctx context.Context, specs <-chan FetchSpec) *ToFuBatchRun {
+	var cancel context.CancelFunc
+	if f.deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	run := &ToFuBatchRun{
+		results: make(chan FetchResult, f.concurrency),
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+		once:    new(sync.Once),
+		lock:    new(sync.Mutex),
+	}
+	type job struct {
+		index int
+		spec  FetchSpec
+	}
+	jobs := make(chan job)
+
+	//分发请求
+	go func() {
+		defer close(jobs)
+		index := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok := <-specs:
+				if !ok {
+					return
+				}
+				select {
+				case jobs <- job{index: index, spec: s}:
+					index++
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < f.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r := f.fetchOne(ctx, j.index, j.spec)
+				run.lock.Lock()
+				run.total++
+				if f.isFailure(r) {
+					run.failed++
+					if f.maxFailures > 0 && run.failed >= f.maxFailures && run.err == nil {
+						run.err = ErrTooManyFailures
+						cancel()
+					}
+				}
+				run.lock.Unlock()
+				//调用方Cancel后可能不再读结果，直接丢弃，不能一直阻塞在这里
+				select {
+				case run.results <- r:
+				case <-run.stop:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		run.lock.Lock()
+		if run.err == nil && ctx.Err() != nil {
+			run.err = ctx.Err()
+		}
+		run.lock.Unlock()
+		cancel()
+		close(run.results)
+	}()
+	return run
+}
+
+//抓取列表里的所有请求，结果按输入的顺序返回，被取消未执行的请求没有结果
+func (f *ToFuBatchFetcher) FetchAll(ctx context.Context, specs []FetchSpec) ([]FetchResult, error) {
+	run := f.Fetch(ctx, specs)
+	var ret []FetchResult
+	for r := range run.Results() {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Index < ret[j].Index
+	})
+	return ret, run.Err()
+}
+
+//执行单个请求
+func (f *ToFuBatchFetcher) fetchOne(ctx context.Context, index int, spec FetchSpec) FetchResult {
+	r := FetchResult{Index: index, Spec: spec, Start: time.Now()}
+	method := spec.Method
+	if len(method) <= 0 {
+		method = http.MethodGet
+	}
+	client := f.client.Clone().SetContext(ctx).SetUrl(spec.URL).SetMethod(method).SetMultipart(spec.Multipart)
+	for k, v := range spec.Header {
+		client.request.Header.Set(k, v)
+	}
+	r.Response, r.Err = client.AddFields(spec.Fields).Do()
+	r.Duration = time.Since(r.Start)
+	return r
+}
+
+//结果的channel，所有请求结束后关闭
+func (run *ToFuBatchRun) Results() <-chan FetchResult {
+	return run.results
+}
+
+//取消剩下的请求，之后可以不再读Results，正在进行的请求的结果可能会被丢弃
+func (run *ToFuBatchRun) Cancel() {
+	run.once.Do(func() {
+		close(run.stop)
+	})
+	run.cancel()
+}
+
+//结束的原因，Results关闭后调用才有意义
+//全部正常完成时为nil，失败过多时为ErrTooManyFailures，超时或取消时为context的错误
+func (run *ToFuBatchRun) Err() error {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	return run.err
+}
+
+//已完成的请求数及其中失败的数量
+func (run *ToFuBatchRun) Counts() (total, failed int) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	return run.total, run.failed
+}
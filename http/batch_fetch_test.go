@@ -0,0 +1,134 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestToFuBatchFetcher(t *testing.T) {
+	fmt.Println(t.Name())
+	var cur, maxN, flaky int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		defer atomic.AddInt32(&cur, -1)
+		for {
+			m := atomic.LoadInt32(&maxN)
+			if n <= m || atomic.CompareAndSwapInt32(&maxN, m, n) {
+				break
+			}
+		}
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(time.Second)
+		case "/flaky":
+			//第一次失败，重试后成功
+			if atomic.AddInt32(&flaky, 1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc"})
+		}
+		time.Sleep(10 * time.Millisecond)
+		r.ParseForm()
+		ck, _ := r.Cookie("sid")
+		sid := ""
+		if ck != nil {
+			sid = ck.Value
+		}
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, r.PostForm.Get("q"), sid)
+	}))
+	defer ts.Close()
+
+	jar := NewCookieJar()
+	client := NewHttpClient().SetCookieJar(jar).SetRetry(2, 10*time.Millisecond)
+	client.SetUrl(ts.URL + "/login").Get()
+
+	var specs []FetchSpec
+	for i := 0; i < 20; i++ {
+		specs = append(specs, FetchSpec{URL: fmt.Sprintf("%s/p%d", ts.URL, i)})
+	}
+	specs = append(specs, FetchSpec{Method: "POST", URL: ts.URL + "/search", Fields: map[string]string{"q": "go"}})
+	specs = append(specs, FetchSpec{URL: ts.URL + "/flaky"})
+	specs = append(specs, FetchSpec{Method: "PUT", URL: ts.URL + "/item", Fields: map[string]string{"q": "x"}})
+	specs = append(specs, FetchSpec{Method: "delete", URL: ts.URL + "/item"})
+	specs = append(specs, FetchSpec{Method: "HEAD", URL: ts.URL + "/item"})
+
+	results, err := NewBatchFetcher(client).SetConcurrency(4).FetchAll(context.Background(), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(specs) {
+		t.Fatalf("got %d results, want %d", len(results), len(specs))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Index != i || r.Duration <= 0 {
+			t.Errorf("result %d: %+v", i, r)
+		}
+	}
+	if body := results[20].Response.GetBodyString(); body != "POST /search go abc" {
+		t.Errorf("POST result: %q", body)
+	}
+	if body := results[21].Response.GetBodyString(); !strings.HasPrefix(body, "GET /flaky") {
+		t.Errorf("retry not applied: %d %q", results[21].Response.GetStatusCode(), body)
+	}
+	//GET、POST以外的方法
+	if body := results[22].Response.GetBodyString(); body != "PUT /item x abc" {
+		t.Errorf("PUT result: %q", body)
+	}
+	if body := results[23].Response.GetBodyString(); body != "DELETE /item  abc" {
+		t.Errorf("DELETE result: %q", body)
+	}
+	if r := results[24]; r.Response.GetStatusCode() != http.StatusOK || len(r.Response.GetBody()) != 0 {
+		t.Errorf("HEAD result: %d %q", r.Response.GetStatusCode(), r.Response.GetBody())
+	}
+	if maxN > 4 {
+		t.Errorf("concurrency %d exceeds 4", maxN)
+	}
+
+	//整批的超时
+	specs = []FetchSpec{{URL: ts.URL + "/a"}, {URL: ts.URL + "/slow"}}
+	start := time.Now()
+	run := NewBatchFetcher(NewHttpClient()).SetDeadline(200*time.Millisecond).Fetch(context.Background(), specs)
+	n := 0
+	for range run.Results() {
+		n++
+	}
+	if run.Err() != context.DeadlineExceeded || time.Since(start) > 800*time.Millisecond || n != 2 {
+		t.Errorf("deadline: %v %v %d", run.Err(), time.Since(start), n)
+	}
+
+	//失败数达到上限
+	specs = nil
+	for i := 0; i < 50; i++ {
+		specs = append(specs, FetchSpec{URL: "http://127.0.0.1:1/"})
+	}
+	run = NewBatchFetcher(NewHttpClient()).SetConcurrency(2).SetMaxFailures(3).Fetch(context.Background(), specs)
+	for range run.Results() {
+	}
+	total, failed := run.Counts()
+	if run.Err() != ErrTooManyFailures || total >= 50 || failed < 3 {
+		t.Errorf("max failures: %v %d %d", run.Err(), total, failed)
+	}
+
+	//Cancel后不读结果，也不会一直阻塞
+	specs = nil
+	for i := 0; i < 20; i++ {
+		specs = append(specs, FetchSpec{URL: fmt.Sprintf("%s/c%d", ts.URL, i)})
+	}
+	run = NewBatchFetcher(NewHttpClient()).SetConcurrency(2).Fetch(context.Background(), specs)
+	time.Sleep(100 * time.Millisecond)
+	run.Cancel()
+	for start = time.Now(); run.Err() == nil && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if run.Err() != context.Canceled {
+		t.Errorf("cancel without reading: %v", run.Err())
+	}
+}
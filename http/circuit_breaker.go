@@ -0,0 +1,377 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //关闭，请求正常通过
+	BreakerOpen                         //打开，请求直接失败
+	BreakerHalfOpen                     //半开，放少量请求过去试探
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+//熔断器打开时返回的错误
+type BreakerOpenError struct {
+	Host       string        //被熔断的host
+	State      BreakerState  //当前的状态
+	RetryAfter time.Duration //大约多久之后会进入半开状态
+}
+
+func (e *BreakerOpenError) Error() string {
+	if e.State == BreakerHalfOpen {
+		return fmt.Sprintf("circuit breaker for %s is half-open, too many requests", e.Host)
+	}
+	return fmt.Sprintf("circuit breaker for %s is open, retry after %v", e.Host, e.RetryAfter)
+}
+
+//判断是否为熔断器打开导致的错误
+func IsBreakerOpen(err error) bool {
+	var be *BreakerOpenError
+	return errors.As(err, &be)
+}
+
+//熔断器的计数
+type BreakerCounts struct {
+	Requests             int //请求数
+	Successes            int //成功数
+	Failures             int //失败数
+	ConsecutiveSuccesses int //连续成功数
+	ConsecutiveFailures  int //连续失败数
+}
+
+//按host区分的熔断器
+type ToFuBreaker struct {
+	lock                *sync.Mutex
+	consecutiveFailures int           //连续失败多少次后打开，<=0表示不按此判断
+	failureRatio        float64       //失败比例达到多少后打开，<=0表示不按此判断
+	minRequests         int           //按失败比例判断时最少的请求数
+	interval            time.Duration //关闭状态下多久清零一次计数，<=0表示不清零
+	cooldown            time.Duration //打开后多久进入半开状态
+	halfOpenMax         int           //半开状态下允许通过的请求数，全部成功后关闭
+	isFailure           func(resp *http.Response, err error) bool
+	onStateChange       func(host string, from, to BreakerState)
+	hosts               map[string]*hostBreaker
+}
+
+//单个host的熔断状态
+type hostBreaker struct {
+	state      BreakerState
+	generation uint64
+	counts     BreakerCounts
+	expiry     time.Time
+}
+
+//状态变化，在锁外面回调
+type breakerChange struct {
+	host     string
+	from, to BreakerState
+}
+
+//实例化一个熔断器
+//默认连续失败5次打开，30秒后半开，半开状态下放1个请求试探
+//默认网络错误及5XX的响应算失败
+func NewBreaker() *ToFuBreaker {
+	return &ToFuBreaker{
+		lock:                new(sync.Mutex),
+		consecutiveFailures: 5,
+		minRequests:         10,
+		interval:            time.Minute,
+		cooldown:            30 * time.Second,
+		halfOpenMax:         1,
+		isFailure:           defaultIsFailure,
+		hosts:               make(map[string]*hostBreaker),
+	}
+}
+
+//默认的失败判断：出错或5XX
+func defaultIsFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+//设置连续失败多少次后打开，<=0表示不按此判断
+func (b *ToFuBreaker) SetConsecutiveFailures(n int) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.consecutiveFailures = n
+	return b
+}
+
+//设置失败比例达到多少时打开，请求数不到minRequests时不判断
+func (b *ToFuBreaker) SetFailureRatio(ratio float64, minRequests int) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failureRatio = ratio
+	b.minRequests = minRequests
+	return b
+}
+
+//设置关闭状态下统计的周期，每个周期清零一次计数
+func (b *ToFuBreaker) SetInterval(d time.Duration) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.interval = d
+	return b
+}
+
+//设置打开后多久进入半开状态
+func (b *ToFuBreaker) SetCooldown(d time.Duration) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.cooldown = d
+	return b
+}
+
+//设置半开状态下允许通过的请求数
+func (b *ToFuBreaker) SetHalfOpenMaxRequests(n int) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if n <= 0 {
+		n = 1
+	}
+	b.halfOpenMax = n
+	return b
+}
+
+//设置判断请求失败的方法
+func (b *ToFuBreaker) SetFailureCheck(fn func(resp *http.Response, err error) bool) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if fn == nil {
+		fn = defaultIsFailure
+	}
+	b.isFailure = fn
+	return b
+}
+
+//设置状态变化时的回调
+func (b *ToFuBreaker) OnStateChange(fn func(host string, from, to BreakerState)) *ToFuBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.onStateChange = fn
+	return b
+}
+
+//提取某个host当前的状态
+func (b *ToFuBreaker) State(host string) BreakerState {
+	host = strings.ToLower(host)
+	b.lock.Lock()
+	hb := b.hostBreaker(host)
+	var changes []breakerChange
+	state := b.currentState(host, hb, time.Now(), &changes)
+	fn := b.onStateChange
+	b.lock.Unlock()
+	notifyBreakerChanges(fn, changes)
+	return state
+}
+
+//提取某个host当前的计数
+func (b *ToFuBreaker) Counts(host string) BreakerCounts {
+	host = strings.ToLower(host)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if hb, ok := b.hosts[host]; ok {
+		return hb.counts
+	}
+	return BreakerCounts{}
+}
+
+//将某个host重置为关闭状态
+func (b *ToFuBreaker) Reset(host string) {
+	host = strings.ToLower(host)
+	b.lock.Lock()
+	hb := b.hostBreaker(host)
+	var changes []breakerChange
+	b.setState(host, hb, BreakerClosed, time.Now(), &changes)
+	fn := b.onStateChange
+	b.lock.Unlock()
+	notifyBreakerChanges(fn, changes)
+}
+
+//请求前调用，返回当前的generation，熔断时返回BreakerOpenError
+func (b *ToFuBreaker) allow(host string) (uint64, error) {
+	b.lock.Lock()
+	hb := b.hostBreaker(host)
+	now := time.Now()
+	var changes []breakerChange
+	state := b.currentState(host, hb, now, &changes)
+	var err error
+	if state == BreakerOpen {
+		err = &BreakerOpenError{Host: host, State: state, RetryAfter: hb.expiry.Sub(now)}
+	} else if state == BreakerHalfOpen && hb.counts.Requests >= b.halfOpenMax {
+		err = &BreakerOpenError{Host: host, State: state}
+	} else {
+		hb.counts.Requests++
+	}
+	gen := hb.generation
+	fn := b.onStateChange
+	b.lock.Unlock()
+	notifyBreakerChanges(fn, changes)
+	return gen, err
+}
+
+//请求结束后调用，记录成功或失败
+func (b *ToFuBreaker) done(host string, gen uint64, success bool) {
+	b.lock.Lock()
+	hb := b.hostBreaker(host)
+	now := time.Now()
+	var changes []breakerChange
+	state := b.currentState(host, hb, now, &changes)
+	if gen == hb.generation {
+		if success {
+			hb.counts.Successes++
+			hb.counts.ConsecutiveSuccesses++
+			hb.counts.ConsecutiveFailures = 0
+			if state == BreakerHalfOpen && hb.counts.ConsecutiveSuccesses >= b.halfOpenMax {
+				b.setState(host, hb, BreakerClosed, now, &changes)
+			}
+		} else {
+			hb.counts.Failures++
+			hb.counts.ConsecutiveFailures++
+			hb.counts.ConsecutiveSuccesses = 0
+			if state == BreakerHalfOpen || (state == BreakerClosed && b.shouldTrip(hb.counts)) {
+				b.setState(host, hb, BreakerOpen, now, &changes)
+			}
+		}
+	}
+	fn := b.onStateChange
+	b.lock.Unlock()
+	notifyBreakerChanges(fn, changes)
+}
+
+//请求被调用方取消了，不算成功也不算失败
+func (b *ToFuBreaker) cancel(host string, gen uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	hb := b.hostBreaker(host)
+	if gen == hb.generation && hb.counts.Requests > 0 {
+		hb.counts.Requests--
+	}
+}
+
+//是否需要打开
+func (b *ToFuBreaker) shouldTrip(c BreakerCounts) bool {
+	if b.consecutiveFailures > 0 && c.ConsecutiveFailures >= b.consecutiveFailures {
+		return true
+	}
+	if b.failureRatio > 0 && c.Requests >= b.minRequests && c.Requests > 0 {
+		return float64(c.Failures)/float64(c.Requests) >= b.failureRatio
+	}
+	return false
+}
+
+//计算当前的状态，到期的要切换，调用方需持有锁
+func (b *ToFuBreaker) currentState(host string, hb *hostBreaker, now time.Time, changes *[]breakerChange) BreakerState {
+	switch hb.state {
+	case BreakerClosed:
+		if !hb.expiry.IsZero() && hb.expiry.Before(now) {
+			b.newGeneration(hb, now)
+		}
+	case BreakerOpen:
+		if hb.expiry.Before(now) {
+			b.setState(host, hb, BreakerHalfOpen, now, changes)
+		}
+	}
+	return hb.state
+}
+
+//切换状态，调用方需持有锁
+func (b *ToFuBreaker) setState(host string, hb *hostBreaker, state BreakerState, now time.Time, changes *[]breakerChange) {
+	if hb.state == state {
+		return
+	}
+	prev := hb.state
+	hb.state = state
+	b.newGeneration(hb, now)
+	*changes = append(*changes, breakerChange{host: host, from: prev, to: state})
+}
+
+//开始新的一轮计数，调用方需持有锁
+func (b *ToFuBreaker) newGeneration(hb *hostBreaker, now time.Time) {
+	hb.generation++
+	hb.counts = BreakerCounts{}
+	var zero time.Time
+	switch hb.state {
+	case BreakerClosed:
+		if b.interval > 0 {
+			hb.expiry = now.Add(b.interval)
+		} else {
+			hb.expiry = zero
+		}
+	case BreakerOpen:
+		hb.expiry = now.Add(b.cooldown)
+	default:
+		hb.expiry = zero
+	}
+}
+
+//提取host的熔断状态，调用方需持有锁
+func (b *ToFuBreaker) hostBreaker(host string) *hostBreaker {
+	hb, ok := b.hosts[host]
+	if !ok {
+		hb = &hostBreaker{state: BreakerClosed}
+		b.newGeneration(hb, time.Now())
+		b.hosts[host] = hb
+	}
+	return hb
+}
+
+//回调状态变化
+func notifyBreakerChanges(fn func(host string, from, to BreakerState), changes []breakerChange) {
+	if fn == nil {
+		return
+	}
+	for _, c := range changes {
+		fn(c.host, c.from, c.to)
+	}
+}
+
+//带熔断的transport
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *ToFuBreaker
+}
+
+//实现http.RoundTripper接口
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	gen, err := t.breaker.allow(host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && (errors.Is(err, context.Canceled) || req.Context().Err() == context.Canceled) {
+		t.breaker.cancel(host, gen)
+		return resp, err
+	}
+	t.breaker.lock.Lock()
+	isFailure := t.breaker.isFailure
+	t.breaker.lock.Unlock()
+	t.breaker.done(host, gen, !isFailure(resp, err))
+	return resp, err
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestToFuBreaker(t *testing.T) {
+	fmt.Println(t.Name())
+	var failing int32 = 1
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+	host := ts.Listener.Addr().String()
+
+	var changes []string
+	breaker := NewBreaker().
+		SetConsecutiveFailures(3).
+		SetCooldown(100 * time.Millisecond).
+		OnStateChange(func(h string, from, to BreakerState) {
+			changes = append(changes, fmt.Sprintf("%s->%s", from, to))
+		})
+	get := func() (ToFuResponse, error) {
+		return NewHttpClient().SetBreaker(breaker).SetUrl(ts.URL).Get()
+	}
+
+	//连续失败3次后打开
+	for i := 0; i < 3; i++ {
+		if resp, err := get(); err != nil || resp.GetStatusCode() != 503 {
+			t.Fatalf("request %d: %v %d", i, err, resp.GetStatusCode())
+		}
+	}
+	if s := breaker.State(host); s != BreakerOpen {
+		t.Fatalf("state %v, want open", s)
+	}
+	_, err := get()
+	if !IsBreakerOpen(err) {
+		t.Fatalf("expected BreakerOpenError, got %v", err)
+	}
+	if hits != 3 {
+		t.Errorf("open breaker should not hit server, hits=%d", hits)
+	}
+
+	//冷却后半开，试探失败再次打开
+	time.Sleep(120 * time.Millisecond)
+	if _, err = get(); err != nil {
+		t.Fatal(err)
+	}
+	if s := breaker.State(host); s != BreakerOpen {
+		t.Fatalf("state %v, want open after failed probe", s)
+	}
+
+	//下游恢复，试探成功后关闭
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(120 * time.Millisecond)
+	if resp, err := get(); err != nil || resp.GetBodyString() != "ok" {
+		t.Fatalf("probe: %v", err)
+	}
+	if s := breaker.State(host); s != BreakerClosed {
+		t.Fatalf("state %v, want closed", s)
+	}
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if fmt.Sprint(changes) != want {
+		t.Errorf("changes %v, want %s", changes, want)
+	}
+}
+
+func TestToFuBreaker_FailureRatio(t *testing.T) {
+	fmt.Println(t.Name())
+	var n int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//每两个请求失败一个
+		if atomic.AddInt32(&n, 1)%2 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	breaker := NewBreaker().SetConsecutiveFailures(0).SetFailureRatio(0.5, 6)
+	for i := 0; i < 5; i++ {
+		NewHttpClient().SetBreaker(breaker).SetUrl(ts.URL).Get()
+	}
+	if s := breaker.State(u.Host); s != BreakerClosed {
+		t.Fatalf("state %v before min requests", s)
+	}
+	NewHttpClient().SetBreaker(breaker).SetUrl(ts.URL).Get()
+	if s := breaker.State(u.Host); s != BreakerOpen {
+		t.Fatalf("state %v, want open, counts %+v", s, breaker.Counts(u.Host))
+	}
+}
@@ -0,0 +1,426 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//cookie罐子，实现了http.CookieJar接口
+//按RFC 6265的domain、path规则匹配cookie，可以保存到JSON文件里，下次再加载回来
+type ToFuCookieJar struct {
+	lock    *sync.Mutex
+	entries map[string]map[string]cookieEntry //domain => (domain;path;name => cookie)
+	nextSeq uint64                            //用于保证同一时刻创建的cookie的顺序
+}
+
+//存储在罐子里的单个cookie
+type cookieEntry struct {
+	Name       string        `json:"name"`
+	Value      string        `json:"value"`
+	Domain     string        `json:"domain"`
+	Path       string        `json:"path"`
+	SameSite   http.SameSite `json:"same_site"`
+	Secure     bool          `json:"secure"`
+	HttpOnly   bool          `json:"http_only"`
+	Persistent bool          `json:"persistent"` //是否带有过期时间，为false时表示是会话cookie
+	HostOnly   bool          `json:"host_only"`  //没有设置Domain属性时只发给原始的host
+	Expires    time.Time     `json:"expires"`
+	Creation   time.Time     `json:"creation"`
+	LastAccess time.Time     `json:"last_access"`
+	seq        uint64        //同一秒内创建的cookie排序用
+}
+
+//cookie的唯一标识
+func (e *cookieEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+//是否可以发送给指定的host、path
+func (e *cookieEntry) shouldSend(https bool, host, path string) bool {
+	return e.domainMatch(host) && e.pathMatch(path) && (https || !e.Secure)
+}
+
+//RFC 6265 5.1.3 domain匹配
+func (e *cookieEntry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+	return !e.HostOnly && hasDotSuffix(host, e.Domain)
+}
+
+//RFC 6265 5.1.4 path匹配
+func (e *cookieEntry) pathMatch(path string) bool {
+	if path == e.Path {
+		return true
+	}
+	if strings.HasPrefix(path, e.Path) {
+		if e.Path[len(e.Path)-1] == '/' {
+			return true
+		} else if path[len(e.Path)] == '/' {
+			return true
+		}
+	}
+	return false
+}
+
+//实例化一个cookie罐子
+func NewCookieJar() *ToFuCookieJar {
+	return &ToFuCookieJar{
+		lock:    new(sync.Mutex),
+		entries: make(map[string]map[string]cookieEntry),
+	}
+}
+
+//从JSON文件中加载cookie罐子，文件不存在时返回空罐子
+func LoadCookieJar(filePath string) (*ToFuCookieJar, error) {
+	jar := NewCookieJar()
+	err := jar.LoadFromFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return jar, nil
+}
+
+//实现http.CookieJar接口，返回要发送给u的cookie列表
+func (jar *ToFuCookieJar) Cookies(u *url.URL) (cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return cookies
+	}
+	host, err := canonicalHost(u)
+	if err != nil {
+		return cookies
+	}
+	key := jarKey(host)
+
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	submap := jar.entries[key]
+	if submap == nil {
+		return cookies
+	}
+	https := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+	var selected []cookieEntry
+	for id, e := range submap {
+		if e.Persistent && !e.Expires.After(now) {
+			delete(submap, id)
+			continue
+		}
+		if !e.shouldSend(https, host, path) {
+			continue
+		}
+		e.LastAccess = now
+		submap[id] = e
+		selected = append(selected, e)
+	}
+	if len(submap) == 0 {
+		delete(jar.entries, key)
+	}
+	//RFC 6265 5.4：path长的在前面，path一样长时先创建的在前面
+	sort.Slice(selected, func(i, j int) bool {
+		s := selected
+		if len(s[i].Path) != len(s[j].Path) {
+			return len(s[i].Path) > len(s[j].Path)
+		}
+		if !s[i].Creation.Equal(s[j].Creation) {
+			return s[i].Creation.Before(s[j].Creation)
+		}
+		return s[i].seq < s[j].seq
+	})
+	for _, e := range selected {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return cookies
+}
+
+//实现http.CookieJar接口，处理u的响应里的set-cookie信息
+func (jar *ToFuCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host, err := canonicalHost(u)
+	if err != nil {
+		return
+	}
+	key := jarKey(host)
+	defPath := defaultPath(u.Path)
+	now := time.Now()
+
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	submap := jar.entries[key]
+	for _, cookie := range cookies {
+		e, remove, ok := jar.newEntry(cookie, now, defPath, host)
+		if !ok {
+			continue
+		}
+		id := e.id()
+		if remove {
+			if submap != nil {
+				delete(submap, id)
+			}
+			continue
+		}
+		if submap == nil {
+			submap = make(map[string]cookieEntry)
+		}
+		//已存在的cookie保留原来的创建时间
+		if old, ok := submap[id]; ok {
+			e.Creation = old.Creation
+			e.seq = old.seq
+		} else {
+			e.Creation = now
+			e.seq = jar.nextSeq
+			jar.nextSeq++
+		}
+		e.LastAccess = now
+		submap[id] = e
+	}
+	if len(submap) == 0 {
+		delete(jar.entries, key)
+	} else {
+		jar.entries[key] = submap
+	}
+}
+
+//罐子里所有的cookie，不区分域名，主要用于调试
+func (jar *ToFuCookieJar) AllCookies() []http.Cookie {
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	var ret []http.Cookie
+	for _, submap := range jar.entries {
+		for _, e := range submap {
+			c := http.Cookie{
+				Name:     e.Name,
+				Value:    e.Value,
+				Domain:   e.Domain,
+				Path:     e.Path,
+				Secure:   e.Secure,
+				HttpOnly: e.HttpOnly,
+				SameSite: e.SameSite,
+			}
+			if e.Persistent {
+				c.Expires = e.Expires
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+//清空罐子
+func (jar *ToFuCookieJar) Clear() {
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	jar.entries = make(map[string]map[string]cookieEntry)
+}
+
+//将罐子里未过期的cookie保存到JSON文件里（包括会话cookie，以便下次运行时继续使用登录态）
+func (jar *ToFuCookieJar) SaveToFile(filePath string) error {
+	jar.lock.Lock()
+	now := time.Now()
+	var list []cookieEntry
+	for _, submap := range jar.entries {
+		for _, e := range submap {
+			if e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			list = append(list, e)
+		}
+	}
+	jar.lock.Unlock()
+	//保证输出稳定
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Creation.Equal(list[j].Creation) {
+			return list[i].Creation.Before(list[j].Creation)
+		}
+		if list[i].seq != list[j].seq {
+			return list[i].seq < list[j].seq
+		}
+		return list[i].id() < list[j].id()
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	//先写临时文件再改名，避免写一半时中断把原文件搞坏
+	tmpFile := filePath + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filePath)
+}
+
+//从JSON文件中加载cookie，已过期的直接丢弃，同名的会覆盖罐子里现有的
+func (jar *ToFuCookieJar) LoadFromFile(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var list []cookieEntry
+	if err = json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	now := time.Now()
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	for _, e := range list {
+		if e.Persistent && !e.Expires.After(now) {
+			continue
+		}
+		if len(e.Name) == 0 || len(e.Domain) == 0 || len(e.Path) == 0 {
+			continue
+		}
+		key := jarKey(e.Domain)
+		submap := jar.entries[key]
+		if submap == nil {
+			submap = make(map[string]cookieEntry)
+			jar.entries[key] = submap
+		}
+		e.seq = jar.nextSeq
+		jar.nextSeq++
+		submap[e.id()] = e
+	}
+	return nil
+}
+
+//根据set-cookie的信息构造罐子里的cookie
+//remove为true表示要删除此cookie，ok为false表示此cookie不合法需要忽略
+func (jar *ToFuCookieJar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e cookieEntry, remove, ok bool) {
+	e.Name = c.Name
+	if len(e.Name) == 0 {
+		return e, false, false
+	}
+	if len(c.Path) == 0 || c.Path[0] != '/' {
+		e.Path = defPath
+	} else {
+		e.Path = c.Path
+	}
+	domain, hostOnly, ok := cookieDomain(host, c.Domain)
+	if !ok {
+		return e, false, false
+	}
+	e.Domain = domain
+	e.HostOnly = hostOnly
+
+	//MaxAge优先于Expires
+	if c.MaxAge < 0 {
+		return e, true, true
+	} else if c.MaxAge > 0 {
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		e.Persistent = true
+	} else if !c.Expires.IsZero() {
+		if !c.Expires.After(now) {
+			return e, true, true
+		}
+		e.Expires = c.Expires
+		e.Persistent = true
+	}
+
+	e.Value = c.Value
+	e.Secure = c.Secure
+	e.HttpOnly = c.HttpOnly
+	e.SameSite = c.SameSite
+	return e, false, true
+}
+
+//RFC 6265 5.3 计算cookie的domain，没有Domain属性时只发给当前host
+func cookieDomain(host, domain string) (string, bool, bool) {
+	if len(domain) == 0 {
+		return host, true, true
+	}
+	if isIP(host) {
+		//IP地址不允许设置Domain属性为别的值
+		if host != domain {
+			return "", false, false
+		}
+		return host, true, true
+	}
+	if domain[0] == '.' {
+		domain = domain[1:]
+	}
+	if len(domain) == 0 || domain[0] == '.' {
+		return "", false, false
+	}
+	domain = strings.ToLower(domain)
+	if domain[len(domain)-1] == '.' {
+		return "", false, false
+	}
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, false
+	}
+	//RFC 6265 5.3 第5步：不允许给"com"、"co.uk"这样的公共后缀设置cookie，host本身就是公共后缀时只发给这个host
+	if _, err := publicsuffix.EffectiveTLDPlusOne(domain); err != nil {
+		if host == domain {
+			return host, true, true
+		}
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+//RFC 6265 5.1.4 默认的path
+func defaultPath(path string) string {
+	if len(path) == 0 || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+//统一host的格式：去掉端口、转小写
+func canonicalHost(u *url.URL) (string, error) {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if len(host) == 0 {
+		return "", fmt.Errorf("invalid host: %s", u.Host)
+	}
+	return host, nil
+}
+
+//罐子里的分组key，按主域名分组，减少Cookies()时遍历的数量
+func jarKey(host string) string {
+	if isIP(host) {
+		return host
+	}
+	i := strings.LastIndex(host, ".")
+	if i <= 0 {
+		return host
+	}
+	prev := strings.LastIndex(host[:i], ".")
+	return host[prev+1:]
+}
+
+//是否为IP地址
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
+
+//s是否以"."+suffix结尾
+func hasDotSuffix(s, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitJoinRawCookie(t *testing.T) {
+	fmt.Println(t.Name())
+	kvs := SplitRawCookie(rawCookie)
+	if len(kvs) != 11 || kvs["BDSVRTM"] != "103" {
+		t.Errorf("SplitRawCookie failed: %v", kvs)
+	}
+	ck := JoinRawCookie(map[string]string{"b": "2", "a": "1"})
+	if ck != "a=1; b=2" {
+		t.Errorf("JoinRawCookie failed: %s", ck)
+	}
+	client := NewHttpClient().SetRawCookie("a=1").AddCookie("b", "2")
+	if ck := client.request.Header.Get("Cookie"); ck != "a=1; b=2" {
+		t.Errorf("AddCookie failed: %s", ck)
+	}
+}
+
+func TestToFuCookieJar_DomainPath(t *testing.T) {
+	fmt.Println(t.Name())
+	jar := NewCookieJar()
+	u, _ := url.Parse("http://www.example.com/account/login")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "path", Value: "3", Path: "/account"},
+		{Name: "secure", Value: "4", Path: "/", Secure: true},
+		{Name: "evil", Value: "5", Domain: "other.com"},
+		{Name: "tld", Value: "6", Domain: "com"},
+		{Name: "gone", Value: "7", MaxAge: -1},
+	})
+	cases := []struct {
+		url  string
+		want []string
+	}{
+		{"http://www.example.com/account/info", []string{"host", "path", "domain"}},
+		{"https://www.example.com/", []string{"domain", "secure"}},
+		{"http://api.example.com/account", []string{"domain"}},
+		{"http://example.com/accounts", []string{"domain"}},
+		{"http://other.com/", nil},
+	}
+	for _, c := range cases {
+		cu, _ := url.Parse(c.url)
+		var got []string
+		for _, ck := range jar.Cookies(cu) {
+			got = append(got, ck.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", c.url, got, c.want)
+		}
+	}
+}
+
+func TestToFuCookieJar_PublicSuffix(t *testing.T) {
+	fmt.Println(t.Name())
+	jar := NewCookieJar()
+	u, _ := url.Parse("http://www.example.co.uk/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "suffix", Value: "1", Domain: "co.uk"},
+		{Name: "domain", Value: "2", Domain: "example.co.uk"},
+	})
+	pu, _ := url.Parse("http://foo.github.io/")
+	jar.SetCookies(pu, []*http.Cookie{{Name: "pages", Value: "3", Domain: "github.io"}})
+	hu, _ := url.Parse("http://github.io/")
+	jar.SetCookies(hu, []*http.Cookie{{Name: "self", Value: "4", Domain: "github.io"}})
+	cases := []struct {
+		url  string
+		want []string
+	}{
+		{"http://other.co.uk/", nil},
+		{"http://api.example.co.uk/", []string{"domain"}},
+		{"http://bar.github.io/", nil},
+		{"http://github.io/", []string{"self"}},
+	}
+	for _, c := range cases {
+		cu, _ := url.Parse(c.url)
+		var got []string
+		for _, ck := range jar.Cookies(cu) {
+			got = append(got, ck.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", c.url, got, c.want)
+		}
+	}
+}
+
+func TestToFuCookieJar_Session(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: "remember", Value: "1", Path: "/", Expires: time.Now().Add(time.Hour)})
+		case "/me":
+			ck, err := r.Cookie("sid")
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, ck.Value)
+		}
+	}))
+	defer ts.Close()
+
+	jar := NewCookieJar()
+	if _, err := NewHttpClient().SetCookieJar(jar).SetUrl(ts.URL + "/login").Get(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := NewHttpClient().SetCookieJar(jar).SetUrl(ts.URL + "/me").Get()
+	if err != nil || resp.GetBodyString() != "abc" {
+		t.Fatalf("cookie not sent back: %v %d %s", err, resp.GetStatusCode(), resp.GetBodyString())
+	}
+
+	//保存到文件再加载回来，模拟下次运行
+	f := filepath.Join(t.TempDir(), "cookies.json")
+	if err = jar.SaveToFile(f); err != nil {
+		t.Fatal(err)
+	}
+	jar2, err := LoadCookieJar(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(jar2.AllCookies()); n != 2 {
+		t.Errorf("loaded %d cookies, want 2", n)
+	}
+	resp, err = NewHttpClient().SetCookieJar(jar2).SetUrl(ts.URL + "/me").Get()
+	if err != nil || resp.GetBodyString() != "abc" {
+		t.Fatalf("loaded cookie not sent: %v %d %s", err, resp.GetStatusCode(), resp.GetBodyString())
+	}
+
+	//文件不存在时返回空罐子
+	jar3, err := LoadCookieJar(f + ".none")
+	if err != nil || len(jar3.AllCookies()) != 0 {
+		t.Errorf("LoadCookieJar on missing file: %v", err)
+	}
+	os.Remove(f)
+}
@@ -0,0 +1,633 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//导出成curl命令，便于在终端里调试，请求方式、body的格式同Do
+//会带上header、cookie（包括cookie罐子里的）、字段、文件、固定的认证信息及代理、超时等设置
+//OAuth2、HMAC等需要动态生成的认证信息不会导出
+func (httpReq *ToFuHttp) ToCurl() string {
+	method := httpReq.method()
+	isForm := httpReq.rawBody == nil && !httpReq.multipart && len(httpReq.files) == 0
+	inQuery := isForm && (method == http.MethodGet || method == http.MethodHead)
+	hasBody := !isForm || (len(httpReq.vals) > 0 && !inQuery)
+
+	rawURL := ""
+	if httpReq.request.URL != nil {
+		u := *httpReq.request.URL
+		if inQuery && len(httpReq.vals) > 0 {
+			q := u.Query()
+			for k, vs := range httpReq.vals {
+				for _, v := range vs {
+					q.Add(k, v)
+				}
+			}
+			u.RawQuery = q.Encode()
+		}
+		rawURL = u.String()
+	}
+	lines := []string{"curl " + shellQuote(rawURL)}
+	switch {
+	case method == http.MethodHead:
+		lines = append(lines, "--head")
+	case hasBody && method != http.MethodPost, !hasBody && method != http.MethodGet:
+		lines = append(lines, "-X "+method)
+	}
+
+	//header按名称排序，cookie单独用-b
+	header := httpReq.request.Header
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var cookies []string
+	for _, k := range keys {
+		ck := http.CanonicalHeaderKey(k)
+		if ck == "Cookie" {
+			cookies = append(cookies, header[k]...)
+			continue
+		}
+		//字段、文件的Content-Type由curl生成
+		if ck == "Content-Type" && httpReq.rawBody == nil {
+			continue
+		}
+		for _, v := range header[k] {
+			lines = append(lines, "-H "+shellQuote(k+": "+v))
+		}
+	}
+	switch a := httpReq.auth.(type) {
+	case bearerAuth:
+		lines = append(lines, "-H "+shellQuote("Authorization: Bearer "+string(a)))
+	case basicAuth:
+		lines = append(lines, "-u "+shellQuote(a.user+":"+a.passwd))
+	}
+	if httpReq.client.Jar != nil && httpReq.request.URL != nil {
+		for _, c := range httpReq.client.Jar.Cookies(httpReq.request.URL) {
+			cookies = append(cookies, c.Name+"="+c.Value)
+		}
+	}
+	if len(cookies) > 0 {
+		lines = append(lines, "-b "+shellQuote(strings.Join(cookies, "; ")))
+	}
+
+	//body
+	switch {
+	case httpReq.rawBody != nil:
+		lines = append(lines, "--data-raw "+shellQuote(string(httpReq.rawBody)))
+	case !isForm:
+		keys = keys[:0]
+		for k := range httpReq.vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if len(httpReq.vals[k]) == 0 {
+				continue
+			}
+			v := httpReq.vals[k][0]
+			//-F里以@、<开头的值curl会当成文件，";"后面的会当成参数，都要用--form-string原样提交
+			if strings.ContainsAny(v, ";@<\"") {
+				lines = append(lines, "--form-string "+shellQuote(k+"="+v))
+			} else {
+				lines = append(lines, "-F "+shellQuote(k+"="+v))
+			}
+		}
+		for _, f := range httpReq.files {
+			v := f.fieldName + "=@" + formQuote(f.filePath)
+			if len(f.fileName) > 0 && f.fileName != filepath.Base(f.filePath) {
+				v += ";filename=" + formQuote(f.fileName)
+			}
+			lines = append(lines, "-F "+shellQuote(v))
+		}
+	case hasBody:
+		lines = append(lines, "--data-raw "+shellQuote(httpReq.vals.Encode()))
+	}
+
+	//其他设置
+	if len(header.Get("Accept-Encoding")) > 0 {
+		lines = append(lines, "--compressed")
+	}
+	if c := httpReq.transport.TLSClientConfig; c != nil && c.InsecureSkipVerify {
+		lines = append(lines, "--insecure")
+	}
+	if httpReq.proxy.proxyURL != nil {
+		lines = append(lines, "-x "+shellQuote(httpReq.proxy.proxyURL.String()))
+	}
+	if httpReq.client.Timeout > 0 {
+		lines = append(lines, "--max-time "+strconv.FormatFloat(httpReq.client.Timeout.Seconds(), 'f', -1, 64))
+	}
+	return strings.Join(lines, " \\\n  ")
+}
+
+//根据curl命令构造请求，如从浏览器开发者工具里复制的"Copy as cURL (bash)"
+//用Do发送，请求方法按curl的规则确定；不会带NewHttpClient默认的header
+//支持的选项：-X、-H、-b、-d、--data-raw、--data-binary、--data-urlencode、-F、--form-string、
+//-u、-A、-e、-x、-k、-G、-I、-m、--connect-timeout、--compressed、--http1.1、--url，
+//以及-s、-S、-L、-v、-i、-f、--http2等不影响请求内容的选项
+func ParseCurl(cmd string) (*ToFuHttp, error) {
+	args, err := splitShellArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || strings.TrimSuffix(filepath.Base(args[0]), ".exe") != "curl" {
+		return nil, fmt.Errorf("curl: not a curl command")
+	}
+	httpReq := NewHttpClient()
+	httpReq.request.Header = make(http.Header)
+	var (
+		method, rawURL string
+		data, cookies  []string
+		get, head      bool
+		compressed     bool
+		forms          []string
+	)
+	var opts []curlOption
+	for i := 1; i < len(args); i++ {
+		o, err := nextCurlOptions(args, &i)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, o...)
+	}
+	for _, o := range opts {
+		name, val := o.name, o.val
+		if len(name) == 0 {
+			if len(rawURL) == 0 {
+				rawURL = val
+			}
+			continue
+		}
+		if !curlOptions[name] {
+			switch name {
+			case "k":
+				httpReq.SetInsecureSkipVerify(true)
+			case "G":
+				get = true
+			case "I":
+				head = true
+			case "compressed":
+				compressed = true
+			case "http1.1":
+				httpReq.SetForceHTTP2(false)
+			}
+			continue
+		}
+		switch name {
+		case "url":
+			rawURL = val
+		case "X":
+			method = strings.ToUpper(val)
+		case "H":
+			idx := strings.Index(val, ":")
+			if idx <= 0 {
+				//"Name;"表示发送空的header
+				if strings.HasSuffix(val, ";") {
+					httpReq.request.Header.Add(strings.TrimSuffix(val, ";"), "")
+					continue
+				}
+				return nil, fmt.Errorf("curl: invalid header %q", val)
+			}
+			k, v := strings.TrimSpace(val[:idx]), strings.TrimSpace(val[idx+1:])
+			if len(v) == 0 {
+				httpReq.request.Header.Del(k)
+			} else if http.CanonicalHeaderKey(k) == "Cookie" {
+				cookies = append(cookies, v)
+			} else {
+				httpReq.request.Header.Add(k, v)
+			}
+		case "b":
+			if !strings.Contains(val, "=") {
+				return nil, fmt.Errorf("curl: cookie file is not supported: %s", val)
+			}
+			cookies = append(cookies, val)
+		case "d", "data-binary":
+			if strings.HasPrefix(val, "@") {
+				b, err := ioutil.ReadFile(val[1:])
+				if err != nil {
+					return nil, err
+				}
+				val = string(b)
+				//-d读文件时去掉换行
+				if name == "d" {
+					val = strings.NewReplacer("\r", "", "\n", "").Replace(val)
+				}
+			}
+			data = append(data, val)
+		case "data-raw":
+			data = append(data, val)
+		case "data-urlencode":
+			data = append(data, curlURLEncode(val))
+		case "F":
+			forms = append(forms, val)
+		case "form-string":
+			//加个前缀表示不解析@、<
+			forms = append(forms, "\x00"+val)
+		case "u":
+			user, pass := val, ""
+			if idx := strings.Index(val, ":"); idx >= 0 {
+				user, pass = val[:idx], val[idx+1:]
+			}
+			httpReq.SetBasicAuth(user, pass)
+		case "A":
+			httpReq.request.Header.Set("User-Agent", val)
+		case "e":
+			httpReq.request.Header.Set("Referer", val)
+		case "x":
+			httpReq.SetProxy(val)
+			if httpReq.proxy.err != nil {
+				return nil, httpReq.proxy.err
+			}
+		case "m", "connect-timeout":
+			sec, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("curl: invalid timeout %q", val)
+			}
+			if name == "m" {
+				httpReq.client.Timeout = time.Duration(sec * float64(time.Second))
+			} else {
+				httpReq.SetDialTimeout(time.Duration(sec * float64(time.Second)))
+			}
+		}
+	}
+
+	//URL
+	if len(rawURL) == 0 {
+		return nil, fmt.Errorf("curl: no URL specified")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("curl: invalid url: %v", err)
+	}
+	if get && len(data) > 0 {
+		if len(u.RawQuery) > 0 {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(data, "&")
+		data = nil
+	}
+	httpReq.request.URL = u
+	if len(cookies) > 0 {
+		httpReq.SetRawCookie(strings.Join(cookies, "; "))
+	}
+	if compressed && len(httpReq.request.Header.Get("Accept-Encoding")) == 0 {
+		httpReq.request.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	}
+
+	//请求方法
+	switch {
+	case len(method) > 0:
+	case head:
+		method = http.MethodHead
+	case get:
+		method = http.MethodGet
+	case len(data) > 0 || len(forms) > 0:
+		method = http.MethodPost
+	default:
+		method = http.MethodGet
+	}
+	httpReq.SetMethod(method)
+
+	//body
+	if len(forms) > 0 {
+		if len(data) > 0 {
+			return nil, fmt.Errorf("curl: -F and -d can not be used together")
+		}
+		httpReq.SetMultipart(true)
+		httpReq.request.Header.Del("Content-Type")
+		for _, f := range forms {
+			if err = httpReq.addCurlForm(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(data) > 0 {
+		body := strings.Join(data, "&")
+		ct := httpReq.request.Header.Get("Content-Type")
+		if len(ct) == 0 {
+			ct = "application/x-www-form-urlencoded"
+		}
+		//GET、HEAD请求的字段Do会放到URL里，所以原样发送
+		isForm := strings.HasPrefix(ct, "application/x-www-form-urlencoded") &&
+			method != http.MethodGet && method != http.MethodHead
+		if vals, ok := parseFormBody(body); ok && isForm {
+			httpReq.request.Header.Del("Content-Type")
+			httpReq.vals = vals
+		} else {
+			httpReq.request.Header.Set("Content-Type", ct)
+			httpReq.SetRawBody([]byte(body))
+		}
+	}
+	return httpReq, nil
+}
+
+//curl的选项：名称 => 是否带参数
+var curlOptions = map[string]bool{
+	"X": true, "H": true, "b": true, "d": true, "data-raw": true, "data-binary": true,
+	"data-urlencode": true, "F": true, "form-string": true, "u": true, "A": true, "e": true,
+	"x": true, "m": true, "connect-timeout": true, "url": true, "o": true,
+	"k": false, "G": false, "I": false, "L": false, "s": false, "S": false, "v": false,
+	"i": false, "f": false, "compressed": false, "http1.1": false, "http2": false,
+}
+
+//长选项对应的名称
+var curlLongOptions = map[string]string{
+	"request": "X", "header": "H", "cookie": "b", "data": "d", "data-ascii": "d",
+	"form": "F", "user": "u", "user-agent": "A", "referer": "e", "proxy": "x",
+	"max-time": "m", "output": "o", "insecure": "k", "get": "G", "head": "I",
+	"location": "L", "silent": "s", "show-error": "S", "verbose": "v", "include": "i", "fail": "f",
+}
+
+//命令行里的一个选项，name为空时val是URL等非选项的参数
+type curlOption struct {
+	name string
+	val  string
+}
+
+//解析下一个参数，需要参数的选项会多消耗一个
+//合并在一起的短选项如"-sSL"会拆开，参数也可以直接跟在后面如"-XPOST"
+func nextCurlOptions(args []string, i *int) ([]curlOption, error) {
+	arg := args[*i]
+	if len(arg) < 2 || arg[0] != '-' {
+		return []curlOption{{val: arg}}, nil
+	}
+	var ret []curlOption
+	name, rest := "", ""
+	if strings.HasPrefix(arg, "--") {
+		name = arg[2:]
+		if n, ok := curlLongOptions[name]; ok {
+			name = n
+		}
+		if _, ok := curlOptions[name]; !ok {
+			return nil, fmt.Errorf("curl: unsupported option %s", arg)
+		}
+	} else {
+		for j := 1; j < len(arg); j++ {
+			name = arg[j : j+1]
+			withVal, ok := curlOptions[name]
+			if !ok {
+				return nil, fmt.Errorf("curl: unsupported option -%s", name)
+			}
+			if withVal {
+				rest = arg[j+1:]
+				break
+			}
+			if j < len(arg)-1 {
+				ret = append(ret, curlOption{name: name})
+			}
+		}
+	}
+	if !curlOptions[name] {
+		return append(ret, curlOption{name: name}), nil
+	}
+	if len(rest) == 0 {
+		if *i+1 >= len(args) {
+			return nil, fmt.Errorf("curl: option %s needs an argument", arg)
+		}
+		*i++
+		rest = args[*i]
+	}
+	return append(ret, curlOption{name: name, val: rest}), nil
+}
+
+//处理-F的参数："name=value"、"name=@file;filename=x;type=y"、"name=<file"
+func (httpReq *ToFuHttp) addCurlForm(f string) error {
+	literal := strings.HasPrefix(f, "\x00")
+	f = strings.TrimPrefix(f, "\x00")
+	idx := strings.Index(f, "=")
+	if idx <= 0 {
+		return fmt.Errorf("curl: invalid form %q", f)
+	}
+	name, val := f[:idx], f[idx+1:]
+	switch {
+	case literal:
+		httpReq.AddField(name, val)
+	case strings.HasPrefix(val, "@"):
+		parts := splitFormParams(val[1:])
+		path, fileName := parts[0], filepath.Base(parts[0])
+		for _, p := range parts[1:] {
+			if strings.HasPrefix(p, "filename=") {
+				fileName = p[len("filename="):]
+			}
+		}
+		return httpReq.AddFile(name, path, fileName)
+	case strings.HasPrefix(val, "<"):
+		b, err := ioutil.ReadFile(splitFormParams(val[1:])[0])
+		if err != nil {
+			return err
+		}
+		httpReq.AddField(name, string(b))
+	default:
+		httpReq.AddField(name, splitFormParams(val)[0])
+	}
+	return nil
+}
+
+//按";"切分-F的参数，双引号里的不切分
+func splitFormParams(s string) []string {
+	var ret []string
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == ';' && !quoted:
+			ret = append(ret, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(ret, cur.String())
+}
+
+//-F里的文件名含有特殊字符时加上双引号
+func formQuote(s string) string {
+	if !strings.ContainsAny(s, ";,\"") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+//--data-urlencode的参数："content"、"=content"、"name=content"
+func curlURLEncode(s string) string {
+	idx := strings.Index(s, "=")
+	if idx < 0 {
+		return url.QueryEscape(s)
+	}
+	if idx == 0 {
+		return url.QueryEscape(s[1:])
+	}
+	return s[:idx] + "=" + url.QueryEscape(s[idx+1:])
+}
+
+//解析urlencoded格式的body，每一项都是"k=v"且没有重复的key时才返回ok
+func parseFormBody(body string) (url.Values, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	for _, kv := range strings.Split(body, "&") {
+		if !strings.Contains(kv, "=") {
+			return nil, false
+		}
+	}
+	vals, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, false
+	}
+	for _, vs := range vals {
+		if len(vs) != 1 {
+			return nil, false
+		}
+	}
+	return vals, true
+}
+
+// 用单引号括起来，里面的单引号转成'\”
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+//按shell的规则切分命令行，支持单引号、双引号、$'...'、反斜杠转义及续行
+func splitShellArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	started := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if started {
+				args = append(args, cur.String())
+				cur.Reset()
+				started = false
+			}
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("curl: unexpected end after backslash")
+			}
+			i++
+			//续行
+			if s[i] == '\n' {
+				continue
+			}
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+				continue
+			}
+			cur.WriteByte(s[i])
+			started = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("curl: unterminated single quote")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			started = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				cur.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("curl: unterminated double quote")
+			}
+			started = true
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			n, err := readANSIQuoted(s[i+2:], &cur)
+			if err != nil {
+				return nil, err
+			}
+			i += n + 2
+			started = true
+		default:
+			cur.WriteByte(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+//解析$'...'里的内容，返回到结尾单引号为止消耗的字节数
+func readANSIQuoted(s string, cur *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i, nil
+		}
+		if c != '\\' || i+1 >= len(s) {
+			cur.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			cur.WriteByte('\n')
+		case 't':
+			cur.WriteByte('\t')
+		case 'r':
+			cur.WriteByte('\r')
+		case '0':
+			cur.WriteByte(0)
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+			j := i + 1
+			for j < len(s) && j < i+1+size && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return 0, fmt.Errorf("curl: invalid escape \\%c", s[i])
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+			if s[i] == 'x' {
+				cur.WriteByte(byte(n))
+			} else {
+				var buf [utf8.UTFMax]byte
+				cur.Write(buf[:utf8.EncodeRune(buf[:], rune(n))])
+			}
+			i = j - 1
+		default:
+			//\\、\'、\"等
+			cur.WriteByte(s[i])
+		}
+	}
+	return 0, fmt.Errorf("curl: unterminated $' quote")
+}
+
+//是否十六进制字符
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
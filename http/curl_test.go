@@ -0,0 +1,235 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//回显请求的方法、URL、header、body
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ret := map[string]interface{}{
+			"method": r.Method,
+			"uri":    r.URL.RequestURI(),
+			"cookie": r.Header.Get("Cookie"),
+			"ua":     r.Header.Get("User-Agent"),
+			"auth":   r.Header.Get("Authorization"),
+			"ct":     r.Header.Get("Content-Type"),
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.ParseMultipartForm(1 << 20)
+			ret["form"] = r.MultipartForm.Value
+			files := map[string]string{}
+			for k, fhs := range r.MultipartForm.File {
+				f, _ := fhs[0].Open()
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				files[k] = fhs[0].Filename + ":" + string(b)
+			}
+			ret["files"] = files
+		} else {
+			b, _ := ioutil.ReadAll(r.Body)
+			ret["body"] = string(b)
+		}
+		json.NewEncoder(w).Encode(ret)
+	}))
+}
+
+func TestToFuHttp_ToCurl(t *testing.T) {
+	fmt.Println(t.Name())
+	c := NewHttpClient().SetUrl("http://example.com/api?x=1")
+	c.request.Header = make(http.Header)
+	c.AddHeader("X-Token", "it's").AddCookies(map[string]string{"b": "2", "a": "1"})
+	c.SetBearerToken("tk").SetTimeout(5)
+	c.AddField("name", "tom").AddField("q", "a b")
+	c.SetMethod("PUT")
+	expect := strings.Join([]string{
+		"curl 'http://example.com/api?x=1'",
+		"-X PUT",
+		`-H 'X-Token: it'\''s'`,
+		"-H 'Authorization: Bearer tk'",
+		"-b 'a=1; b=2'",
+		"--data-raw 'name=tom&q=a+b'",
+		"--max-time 5",
+	}, " \\\n  ")
+	if s := c.ToCurl(); s != expect {
+		t.Errorf("unexpected curl:\n%s\nwant:\n%s", s, expect)
+	}
+
+	//GET的字段放到URL里
+	c.SetMethod("GET")
+	if s := c.ToCurl(); !strings.HasPrefix(s, "curl 'http://example.com/api?name=tom&q=a+b&x=1' \\\n  -H") {
+		t.Errorf("unexpected curl:\n%s", s)
+	}
+
+	//文件
+	dir, _ := ioutil.TempDir("", "curl")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("hello"), 0644)
+	c = NewHttpClient().SetUrl("http://example.com/upload").SetInsecureSkipVerify(true)
+	c.AddField("id", "@1")
+	if err := c.AddFile("file", path, "b;c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	s := c.ToCurl()
+	for _, want := range []string{
+		"--form-string 'id=@1'",
+		"-F 'file=@" + path + `;filename="b;c.txt"'`,
+		"--compressed",
+		"--insecure",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("missing %q in:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "-X") {
+		t.Errorf("POST should not need -X:\n%s", s)
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := newEchoServer()
+	defer ts.Close()
+
+	//浏览器复制出来的格式
+	cmd := "curl '" + ts.URL + `/api/items?page=2' \
+  -H 'accept: application/json' \
+  -H 'content-type: application/json' \
+  -H "user-agent: Mozilla/5.0 \"test\"" \
+  -b 'sid=abc; theme=dark' \
+  --data-raw $'{"name":"it\'s","tags":["a\u00e9"]}' \
+  --compressed`
+	c, err := ParseCurl(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	resp.DecodeJSON(&got)
+	want := map[string]interface{}{
+		"method": "POST",
+		"uri":    "/api/items?page=2",
+		"cookie": "sid=abc; theme=dark",
+		"ua":     `Mozilla/5.0 "test"`,
+		"ct":     "application/json",
+		"body":   `{"name":"it's","tags":["aé"]}`,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+
+	//导出再解析，发出的请求一样
+	c2, err := ParseCurl(c.ToCurl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2, err := c2.Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp2.GetBodyString() != resp.GetBodyString() {
+		t.Errorf("round trip mismatch:\n%s\n%s", resp.GetBodyString(), resp2.GetBodyString())
+	}
+
+	//表单、-G、-u、合并的短选项
+	c, err = ParseCurl("curl -sSL -XPATCH -u user:pass -d a=1 --data-urlencode 'b=x y' " + ts.URL + "/form")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	resp, _ = c.Do()
+	resp.DecodeJSON(&got)
+	if got["method"] != "PATCH" || got["body"] != "a=1&b=x+y" || got["auth"] != "Basic dXNlcjpwYXNz" ||
+		got["ct"] != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected %v", got)
+	}
+	c, _ = ParseCurl("curl -G -d a=1 -d b=2 '" + ts.URL + "/q?x=0'")
+	got = nil
+	resp, _ = c.Do()
+	resp.DecodeJSON(&got)
+	if got["method"] != "GET" || got["uri"] != "/q?x=0&a=1&b=2" {
+		t.Errorf("unexpected %v", got)
+	}
+}
+
+func TestParseCurl_Multipart(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := newEchoServer()
+	defer ts.Close()
+	dir, _ := ioutil.TempDir("", "curl")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("hello"), 0644)
+
+	c, err := ParseCurl(fmt.Sprintf("curl -F name=tom -F 'file=@%s;filename=x.txt' --form-string 'at=@raw' %s", path, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//导出的命令里要有文件
+	if s := c.ToCurl(); !strings.Contains(s, "-F 'file=@"+path+";filename=x.txt'") {
+		t.Errorf("unexpected curl:\n%s", s)
+	}
+	resp, err := c.Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Method string
+		Form   map[string][]string
+		Files  map[string]string
+	}
+	resp.DecodeJSON(&got)
+	if got.Method != "POST" || got.Form["name"][0] != "tom" || got.Form["at"][0] != "@raw" || got.Files["file"] != "x.txt:hello" {
+		t.Errorf("unexpected %+v", got)
+	}
+
+	//含有";"等特殊字符的值导出后再解析不能变
+	memo := `a;b "c" d@e<f`
+	c = NewHttpClient().SetUrl(ts.URL).AddField("memo", memo)
+	if err = c.AddFile("file", path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ParseCurl(c.ToCurl()); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = c.Do(); err != nil {
+		t.Fatal(err)
+	}
+	got.Form = nil
+	resp.DecodeJSON(&got)
+	if len(got.Form["memo"]) != 1 || got.Form["memo"][0] != memo {
+		t.Errorf("round trip: %q", got.Form["memo"])
+	}
+}
+
+func TestParseCurl_Error(t *testing.T) {
+	fmt.Println(t.Name())
+	for _, cmd := range []string{
+		"wget http://a.com",
+		"curl",
+		"curl -H",
+		"curl --unknown http://a.com",
+		"curl 'http://a.com",
+		`curl "http://a.com`,
+		"curl -b cookies.txt http://a.com",
+		"curl -F a=1 -d b=2 http://a.com",
+	} {
+		if _, err := ParseCurl(cmd); err == nil {
+			t.Errorf("expected error for %q", cmd)
+		}
+	}
+}
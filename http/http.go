@@ -7,25 +7,54 @@ package http
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 )
 
 //客户端结构体
 type ToFuHttp struct {
-	buf     *bytes.Buffer     //发送的数据，一般POST用
-	vals    url.Values        //提交上来的数据
-	writer  *multipart.Writer //POST/PUT时的写入数据
-	client  *http.Client      //连接客户端
-	request *http.Request     //要发送的请求
+	buf       *bytes.Buffer     //发送的数据，一般POST用
+	vals      url.Values        //提交上来的数据
+	writer    *multipart.Writer //POST/PUT时的写入数据
+	client    *http.Client      //连接客户端
+	transport *http.Transport   //底层的transport，client.Transport是在它基础上包装出来的
+	dialer    *net.Dialer       //建立TCP连接用的
+	request   *http.Request     //要发送的请求
+	ctx       context.Context   //请求用的context，用于取消请求
+	limiter   *ToFuLimiter      //限流器
+	breaker   *ToFuBreaker      //熔断器
+	recorder  *ToFuRecorder     //录制回放，测试用
+	proxy     proxyConf         //代理的设置
+	retry     retryConf         //失败重试的设置
+	auth      ToFuAuth          //认证方式
+	tracer    *ToFuTracer       //请求耗时的汇总统计
+	files     []uploadFile      //已添加的文件，导出curl命令用
+	rawBody   []byte            //原样发送的body，设置了时忽略字段、文件
+	multipart bool              //Do发送时是否用multipart格式
+}
+
+//要上传的文件
+type uploadFile struct {
+	fieldName string
+	filePath  string
+	fileName  string
+}
+
+//失败重试的设置
+type retryConf struct {
+	times    int           //最多重试几次，0表示不重试
+	interval time.Duration //第一次重试前等待的时间，之后每次翻倍
 }
 
 //实例化一个端
@@ -36,18 +65,21 @@ func NewHttpClient() *ToFuHttp {
 		vals:   make(url.Values),
 		writer: multipart.NewWriter(b),
 	}
-	transport := http.Transport{
-		DisableKeepAlives: false,
+	httpReq.dialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
 	}
+	httpReq.transport = newTransport(httpReq.dialer)
 	httpReq.client = &http.Client{
-		Timeout:   time.Duration(int64(30) * int64(time.Second)),
-		Transport: &transport,
+		Timeout: time.Duration(int64(30) * int64(time.Second)),
 	}
+	httpReq.buildTransport()
 	httpReq.request = &http.Request{}
 	httpReq.request.Header = make(http.Header)
 	httpReq.AddHeaders(map[string]string{
-		"Cache-Control": "max-age=0",
-		"User-Agent":    "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.79 Safari/537.36",
+		"Accept-Encoding": "gzip, deflate, br",
+		"Cache-Control":   "max-age=0",
+		"User-Agent":      "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.79 Safari/537.36",
 	})
 	return httpReq
 }
@@ -70,6 +102,7 @@ func (httpReq *ToFuHttp) AddFile(fieldName, filePath, fileName string) error {
 		fmt.Fprintf(os.Stderr, "copy file failed: %s", fileName)
 		return err
 	}
+	httpReq.files = append(httpReq.files, uploadFile{fieldName: fieldName, filePath: filePath, fileName: fileName})
 	return nil
 }
 
@@ -100,9 +133,48 @@ func (httpReq *ToFuHttp) SetUrl(u string) *ToFuHttp {
 	return httpReq
 }
 
-//设置长连接选项
-func (httpReq *ToFuHttp) SetKeepAlive(b bool) *ToFuHttp {
-	httpReq.client.Transport.(*http.Transport).DisableKeepAlives = b
+//设置请求方法，Do发送时用，默认有body时为POST，否则为GET
+func (httpReq *ToFuHttp) SetMethod(method string) *ToFuHttp {
+	httpReq.request.Method = strings.ToUpper(method)
+	return httpReq
+}
+
+//设置原样发送的body，如JSON，Content-Type需自己设置，Do发送时用
+func (httpReq *ToFuHttp) SetRawBody(body []byte) *ToFuHttp {
+	httpReq.rawBody = body
+	return httpReq
+}
+
+//设置Do发送时字段是否用multipart格式，添加了文件时总是用multipart
+func (httpReq *ToFuHttp) SetMultipart(b bool) *ToFuHttp {
+	httpReq.multipart = b
+	return httpReq
+}
+
+//设置请求用的context，context取消时请求及限流等待都会中止
+func (httpReq *ToFuHttp) SetContext(ctx context.Context) *ToFuHttp {
+	httpReq.ctx = ctx
+	return httpReq
+}
+
+//设置限流器，多个client可以共用一个限流器
+func (httpReq *ToFuHttp) SetLimiter(l *ToFuLimiter) *ToFuHttp {
+	httpReq.limiter = l
+	httpReq.buildTransport()
+	return httpReq
+}
+
+//设置熔断器，下游故障时快速失败，不用等到超时
+func (httpReq *ToFuHttp) SetBreaker(b *ToFuBreaker) *ToFuHttp {
+	httpReq.breaker = b
+	httpReq.buildTransport()
+	return httpReq
+}
+
+//设置录制回放器，用于离线测试
+func (httpReq *ToFuHttp) SetRecorder(r *ToFuRecorder) *ToFuHttp {
+	httpReq.recorder = r
+	httpReq.buildTransport()
 	return httpReq
 }
 
@@ -112,12 +184,23 @@ func (httpReq *ToFuHttp) SetUserAgent(ua string) *ToFuHttp {
 	return httpReq
 }
 
-//设置cookie（设置header的相应值）
+//设置cookie（设置header的相应值，会覆盖原来的）
 func (httpReq *ToFuHttp) SetRawCookie(ck string) *ToFuHttp {
-	httpReq.AddHeader("Cookie", ck)
+	httpReq.request.Header.Set("Cookie", ck)
 	return httpReq
 }
 
+//设置cookie罐子，响应里的set-cookie会自动存进去，后续的请求会自动带上
+func (httpReq *ToFuHttp) SetCookieJar(jar http.CookieJar) *ToFuHttp {
+	httpReq.client.Jar = jar
+	return httpReq
+}
+
+//提取当前用的cookie罐子，未设置时为nil
+func (httpReq *ToFuHttp) GetCookieJar() http.CookieJar {
+	return httpReq.client.Jar
+}
+
 //添加单个cookie的键值
 func (httpReq *ToFuHttp) AddCookie(k, v string) *ToFuHttp {
 	httpReq.AddCookies(map[string]string{k: v})
@@ -159,19 +242,33 @@ func (httpReq *ToFuHttp) SetTimeout(timeout int) *ToFuHttp {
 	return httpReq
 }
 
-//设置代理用的和端口（设置header的相应值）
-func (httpReq *ToFuHttp) SetProxy(proxyHost string) *ToFuHttp {
-	//如果只给了IP:PORT这样的，默认为http方式
-	check, _ := regexp.MatchString(`^[\d]{1,3}\.[\d]{1,3}\.[\d]{1,3}\.[\d]{1,3}:[\d]{1,5}$`, proxyHost)
-	if check {
-		proxyHost = "http://" + proxyHost
-	}
-	httpReq.client.Transport.(*http.Transport).Proxy = func(_ *http.Request) (*url.URL, error) {
-		return url.Parse(proxyHost)
-	}
+//设置失败重试，网络出错或5XX时重试，每次重试的等待时间翻倍
+//注意POST请求也会重试，非幂等的接口慎用
+func (httpReq *ToFuHttp) SetRetry(times int, interval time.Duration) *ToFuHttp {
+	httpReq.retry = retryConf{times: times, interval: interval}
 	return httpReq
 }
 
+//复制一个客户端，共用底层的http.Client（连接池、cookie罐子、限流、熔断等），
+//header、context、重试等设置会复制过去，要提交的字段、文件、SetRawBody的body及SetMultipart不复制
+//可以用于并发请求，但在复制出来的客户端上修改超时、代理等会影响所有共用的
+func (httpReq *ToFuHttp) Clone() *ToFuHttp {
+	b := new(bytes.Buffer)
+	ret := *httpReq
+	ret.buf = b
+	ret.vals = make(url.Values)
+	ret.writer = multipart.NewWriter(b)
+	ret.files = nil
+	ret.rawBody = nil
+	ret.multipart = false
+	ret.request = &http.Request{Method: httpReq.request.Method, Header: httpReq.request.Header.Clone()}
+	if httpReq.request.URL != nil {
+		u := *httpReq.request.URL
+		ret.request.URL = &u
+	}
+	return &ret
+}
+
 //批量添加字段
 func (httpReq *ToFuHttp) AddFields(data map[string]string) *ToFuHttp {
 	if len(data) > 0 {
@@ -198,29 +295,102 @@ func (httpReq *ToFuHttp) Get() (ToFuResponse, error) {
 	httpReq.request.Method = http.MethodGet
 	httpReq.writer.Close()
 	defer httpReq.buf.Reset()
-	response, err := httpReq.client.Do(httpReq.request)
+	req, err := httpReq.newRequest(http.MethodGet, nil, "")
 	if err != nil {
 		return ToFuResponse{}, err
 	}
-	return processResponse(response)
+	return httpReq.doRequest(req)
 }
 
 //发起POST请求并返回数据
 func (httpReq *ToFuHttp) PostBin() (ToFuResponse, error) {
 	httpReq.request.Method = http.MethodPost
-	u := httpReq.request.URL.String()
 	httpReq.writer.Close()
-	response, err := httpReq.client.PostForm(u, httpReq.vals)
+	body := strings.NewReader(httpReq.vals.Encode())
+	req, err := httpReq.newRequest(http.MethodPost, body, "application/x-www-form-urlencoded")
 	if err != nil {
-		fmt.Println(err)
 		return ToFuResponse{}, err
 	}
-	return processResponse(response)
+	ret, err := httpReq.doRequest(req)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return ret, err
 }
 
 //发起POST请求并返回数据
 func (httpReq *ToFuHttp) Post() (ToFuResponse, error) {
 	httpReq.request.Method = http.MethodPost
+	ret, err := httpReq.sendMultipart(http.MethodPost)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return ret, err
+}
+
+//按SetMethod设置的方法发起请求：
+//设置了SetRawBody的原样发送body；
+//添加了文件或SetMultipart(true)的用multipart格式发送字段及文件；
+//否则GET、HEAD请求的字段拼到URL的查询参数里，其他方法的用urlencoded格式放到body里
+func (httpReq *ToFuHttp) Do() (ToFuResponse, error) {
+	method := httpReq.method()
+	httpReq.request.Method = method
+	if httpReq.rawBody != nil {
+		httpReq.writer.Close()
+		defer httpReq.buf.Reset()
+		req, err := httpReq.newRequest(method, bytes.NewReader(httpReq.rawBody), "")
+		if err != nil {
+			return ToFuResponse{}, err
+		}
+		return httpReq.doRequest(req)
+	}
+	if httpReq.multipart || len(httpReq.files) > 0 {
+		return httpReq.sendMultipart(method)
+	}
+	httpReq.writer.Close()
+	defer httpReq.buf.Reset()
+	if len(httpReq.vals) == 0 {
+		req, err := httpReq.newRequest(method, nil, "")
+		if err != nil {
+			return ToFuResponse{}, err
+		}
+		return httpReq.doRequest(req)
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		req, err := httpReq.newRequest(method, nil, "")
+		if err != nil {
+			return ToFuResponse{}, err
+		}
+		q := req.URL.Query()
+		for k, vs := range httpReq.vals {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+		return httpReq.doRequest(req)
+	}
+	body := strings.NewReader(httpReq.vals.Encode())
+	req, err := httpReq.newRequest(method, body, "application/x-www-form-urlencoded")
+	if err != nil {
+		return ToFuResponse{}, err
+	}
+	return httpReq.doRequest(req)
+}
+
+//Do要用的请求方法，未设置时有body的为POST，否则为GET
+func (httpReq *ToFuHttp) method() string {
+	if len(httpReq.request.Method) > 0 {
+		return httpReq.request.Method
+	}
+	if httpReq.rawBody != nil || len(httpReq.files) > 0 || len(httpReq.vals) > 0 {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+//用multipart格式发送字段及文件
+func (httpReq *ToFuHttp) sendMultipart(method string) (ToFuResponse, error) {
 	for k, vs := range httpReq.vals {
 		if len(vs) <= 0 {
 			continue
@@ -229,30 +399,130 @@ func (httpReq *ToFuHttp) Post() (ToFuResponse, error) {
 	}
 	httpReq.writer.Close()
 	defer httpReq.buf.Reset()
+	httpReq.files = nil
 	//拼装请求的body
 	ct := httpReq.writer.FormDataContentType()
-	bf := httpReq.buf
-	u := httpReq.request.URL.String()
-	response, err := httpReq.client.Post(u, ct, bf)
+	bf := bytes.NewReader(httpReq.buf.Bytes())
+	req, err := httpReq.newRequest(method, bf, ct)
 	if err != nil {
-		fmt.Println(err)
 		return ToFuResponse{}, err
 	}
-	return processResponse(response)
+	return httpReq.doRequest(req)
+}
+
+//根据当前的设置构造要发送的请求，header、context都会带上
+func (httpReq *ToFuHttp) newRequest(method string, body io.Reader, contentType string) (*http.Request, error) {
+	if httpReq.request.URL == nil {
+		return nil, fmt.Errorf("invalid url")
+	}
+	ctx := httpReq.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, httpReq.request.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = httpReq.request.Header.Clone()
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+//发送请求并处理响应，设置了重试的按设置重试
+func (httpReq *ToFuHttp) doRequest(req *http.Request) (ToFuResponse, error) {
+	interval := httpReq.retry.interval
+	for i := 0; ; i++ {
+		tr := newReqTrace(req.URL.Host)
+		response, err := httpReq.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace())))
+		if i >= httpReq.retry.times || !shouldRetry(req, response, err) {
+			var ret ToFuResponse
+			if err == nil {
+				ret, err = processResponse(response)
+			}
+			ret.trace = tr.done()
+			if httpReq.tracer != nil {
+				httpReq.tracer.add(ret.trace, err)
+			}
+			return ret, err
+		}
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		//body要重新生成
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return ToFuResponse{}, berr
+			}
+			req.Body = body
+		} else if req.Body != nil && req.Body != http.NoBody {
+			return ToFuResponse{}, err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return ToFuResponse{}, req.Context().Err()
+		}
+		interval *= 2
+	}
+}
+
+//是否需要重试：网络出错或5XX，熔断、录制回放未命中、context结束的不重试
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		if IsBreakerOpen(err) {
+			return false
+		}
+		var miss *RecorderMissError
+		return !errors.As(err, &miss)
+	}
+	return resp.StatusCode >= 500
+}
+
+//在底层transport的基础上按顺序包装限流等功能
+func (httpReq *ToFuHttp) buildTransport() {
+	var rt http.RoundTripper = httpReq.transport
+	if httpReq.recorder != nil {
+		rt = &recorderTransport{next: rt, recorder: httpReq.recorder}
+	}
+	if httpReq.limiter != nil {
+		rt = &limiterTransport{next: rt, limiter: httpReq.limiter}
+	}
+	//熔断放在限流外面，熔断时不用排队
+	if httpReq.breaker != nil {
+		rt = &breakerTransport{next: rt, breaker: httpReq.breaker}
+	}
+	if httpReq.auth != nil {
+		rt = &authTransport{next: rt, auth: httpReq.auth}
+	}
+	httpReq.client.Transport = rt
 }
 
 //处理响应信息
 func processResponse(response *http.Response) (ToFuResponse, error) {
-	ret := ToFuResponse{header: make(map[string]string)}
+	ret := ToFuResponse{header: response.Header}
 	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
+	//自己设置了Accept-Encoding时transport不会自动解压，需要根据Content-Encoding处理
+	ce := response.Header.Get("Content-Encoding")
+	body, err := decompressBody(response.Body, ce)
 	if err != nil {
 		ret.err = err
 		return ret, err
 	}
 	ret.body = body
-	for k, vs := range response.Header {
-		ret.header[k] = strings.Join(vs, ";")
+	ret.contentLen = response.ContentLength
+	if len(ce) > 0 {
+		ret.header.Del("Content-Encoding")
+		ret.header.Del("Content-Length")
+		ret.contentLen = int64(len(body))
 	}
 	for _, cptr := range response.Cookies() {
 		ret.setCookie = append(ret.setCookie, *cptr)
@@ -261,7 +531,6 @@ func processResponse(response *http.Response) (ToFuResponse, error) {
 	ret.status = response.Status
 	ret.statusCode = response.StatusCode
 	ret.proto = response.Proto
-	ret.contentLen = response.ContentLength
 	loc, err := response.Location()
 	if err == nil {
 		ret.location = loc.String()
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestToFuHttp_Proxy(t *testing.T) {
+	fmt.Println(t.Name())
+	//简单的http代理，返回代理名称及认证信息
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", name, r.URL.String(), r.Header.Get("Proxy-Authorization"))
+		}))
+	}
+	p1, p2 := newProxy("p1"), newProxy("p2")
+	defer p1.Close()
+	defer p2.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "direct")
+	}))
+	defer direct.Close()
+
+	//不带scheme的默认为http，带认证
+	resp, err := NewHttpClient().SetProxy(p1.Listener.Addr().String()).SetProxyAuth("user", "pass").
+		SetUrl("http://example.com/a").Get()
+	if err != nil || resp.GetBodyString() != "p1 http://example.com/a Basic dXNlcjpwYXNz" {
+		t.Errorf("proxy auth: %v %q", err, resp.GetBodyString())
+	}
+
+	//不走代理的列表
+	resp, err = NewHttpClient().SetProxy(p1.URL).SetNoProxy("example.org", "127.0.0.0/8").SetUrl(direct.URL).Get()
+	if err != nil || resp.GetBodyString() != "direct" {
+		t.Errorf("no proxy: %v %q", err, resp.GetBodyString())
+	}
+
+	//代理池轮流使用
+	pool, err := NewProxyPool(p1.URL, p2.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 3; i++ {
+		resp, err = NewHttpClient().SetProxyPool(pool).SetUrl("http://example.com/").Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp.GetBodyString()[:2])
+	}
+	if fmt.Sprint(got) != "[p1 p2 p1]" {
+		t.Errorf("proxy pool: %v", got)
+	}
+
+	if _, err = NewHttpClient().SetProxy("ftp://127.0.0.1:21").SetUrl("http://example.com/").Get(); err == nil {
+		t.Errorf("expected error for unsupported proxy scheme")
+	}
+	if u, _ := parseProxyURL("socks5://u:p@127.0.0.1:1080"); u == nil || u.Scheme != "socks5" || u.User.Username() != "u" {
+		t.Errorf("parse socks5 proxy: %v", u)
+	}
+}
+
+func TestMatchNoProxy(t *testing.T) {
+	fmt.Println(t.Name())
+	rules := []string{".example.com", "api.test.org:8443", "10.0.0.0/8", "192.168.1.1"}
+	cases := map[string]bool{
+		"http://example.com/":          true,
+		"http://www.example.com/":      true,
+		"http://badexample.com/":       false,
+		"https://api.test.org:8443/":   true,
+		"https://api.test.org/":        false,
+		"http://10.2.3.4/":             true,
+		"http://192.168.1.1:8080/":     true,
+		"http://192.168.1.2/":          false,
+		"http://sub.api.test.org:8443": true,
+	}
+	for raw, want := range cases {
+		u, _ := url.Parse(raw)
+		if got := matchNoProxy(rules, u); got != want {
+			t.Errorf("%s: got %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestToFuHttp_TLS(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", len(r.TLS.PeerCertificates))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	//自签名证书，默认校验失败
+	if _, err := NewHttpClient().SetUrl(ts.URL).Get(); err == nil {
+		t.Errorf("expected certificate error")
+	}
+
+	//添加根证书及客户端证书
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	ioutil.WriteFile(caFile, pemData, 0644)
+	client := NewHttpClient().SetMinTLSVersion(tls.VersionTLS12).SetClientCert(ts.TLS.Certificates[0])
+	if err := client.AddRootCAFile(caFile); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.SetUrl(ts.URL).Get()
+	if err != nil || resp.GetBodyString() != "1" {
+		t.Errorf("mTLS: %v %q", err, resp.GetBodyString())
+	}
+
+	//跳过校验
+	resp, err = NewHttpClient().SetInsecureSkipVerify(true).SetClientCert(ts.TLS.Certificates[0]).SetUrl(ts.URL).Get()
+	if err != nil || resp.GetBodyString() != "1" {
+		t.Errorf("insecure: %v %q", err, resp.GetBodyString())
+	}
+}
@@ -6,22 +6,41 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 //响应结构体，在response基础上封装
 type ToFuResponse struct {
-	body             []byte            //响应的body
-	status           string            //响应码的描述信息，"200 OK"
-	statusCode       int               //响应码"200"
-	proto            string            //所用协议"HTTP/1.1"
-	header           map[string]string //头信息
-	contentLen       int64             //返回内容长度
-	setCookie        []http.Cookie     //要设置的cookie信息
-	location         string            //当statusCode为3XX如301时重定向的链接
-	err              error             //请求的出错信息
-	transferEncoding []string          //所用的编码信息
+	body             []byte        //响应的body
+	status           string        //响应码的描述信息，"200 OK"
+	statusCode       int           //响应码"200"
+	proto            string        //所用协议"HTTP/1.1"
+	header           http.Header   //头信息
+	contentLen       int64         //返回内容长度
+	setCookie        []http.Cookie //要设置的cookie信息
+	location         string        //当statusCode为3XX如301时重定向的链接
+	err              error         //请求的出错信息
+	transferEncoding []string      //所用的编码信息
+	trace            ToFuTrace     //各阶段的耗时
 }
 
 //提取body信息
@@ -49,11 +68,41 @@ func (tfr ToFuResponse) GetProto() string {
 	return tfr.proto
 }
 
-//提取响应的头信息
+//提取请求各阶段的耗时
+func (tfr ToFuResponse) GetTrace() ToFuTrace {
+	return tfr.trace
+}
+
+//提取响应的头信息，多个值的用";"连起来了
+//像Set-Cookie、Link这样的多值头信息请用GetHeaders或GetHeaderValues
 func (tfr ToFuResponse) GetHeader() map[string]string {
+	ret := make(map[string]string)
+	for k, vs := range tfr.header {
+		ret[k] = strings.Join(vs, ";")
+	}
+	return ret
+}
+
+//提取完整的响应头信息
+func (tfr ToFuResponse) GetHeaders() http.Header {
 	return tfr.header
 }
 
+//提取某个头信息的第一个值
+func (tfr ToFuResponse) GetHeaderValue(k string) string {
+	return tfr.header.Get(k)
+}
+
+//提取某个头信息的所有值
+func (tfr ToFuResponse) GetHeaderValues(k string) []string {
+	return tfr.header.Values(k)
+}
+
+//提取Content-Type
+func (tfr ToFuResponse) GetContentType() string {
+	return tfr.header.Get("Content-Type")
+}
+
 //获取响应信息的长度
 func (tfr ToFuResponse) GetContentLen() int64 {
 	return tfr.contentLen
@@ -79,8 +128,105 @@ func (tfr ToFuResponse) Error() error {
 	return tfr.err
 }
 
+//将body当成JSON解析到v里，JSON规定必须是UTF-8编码（RFC 8259），不做转码，只去掉开头的BOM
+func (tfr ToFuResponse) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(bytes.TrimPrefix(tfr.body, utf8BOM), v)
+}
+
+//将body当成XML解析到v里，会根据XML声明里的encoding自动转码
+func (tfr ToFuResponse) DecodeXML(v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(tfr.body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder.Decode(v)
+}
+
+//将body转为UTF-8编码的字符串，详见GetBodyUTF8
+func (tfr ToFuResponse) GetBodyText() (string, error) {
+	body, err := tfr.GetBodyUTF8()
+	return string(body), err
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+//将body转为UTF-8编码
+//整个body是合法的UTF-8时原样返回，不是时编码依次从BOM、Content-Type里的charset、HTML的meta标签里判断
+//都没有时当成GB18030（兼容GBK、GB2312）处理
+func (tfr ToFuResponse) GetBodyUTF8() ([]byte, error) {
+	//DetermineEncoding只看前1024个字节，前面都是ASCII时会误判成windows-1252
+	if utf8.Valid(tfr.body) {
+		return tfr.body, nil
+	}
+	enc, name, certain := charset.DetermineEncoding(tfr.body, tfr.GetContentType())
+	if !certain && name == "windows-1252" {
+		enc = simplifiedchinese.GB18030
+	}
+	return decodeBytes(tfr.body, enc)
+}
+
+//按指定的编码将body转为UTF-8，如"gbk"、"gb2312"、"big5"
+func (tfr ToFuResponse) GetBodyWithCharset(label string) ([]byte, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %s", label)
+	}
+	return decodeBytes(tfr.body, enc)
+}
+
+//用指定的编码转为UTF-8
+func decodeBytes(b []byte, enc encoding.Encoding) ([]byte, error) {
+	if enc == nil || enc == encoding.Nop {
+		return b, nil
+	}
+	ret, _, err := transform.Bytes(enc.NewDecoder(), b)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//根据Content-Encoding解压body，支持gzip、deflate、br
+//body为空时（如HEAD、204、304）直接返回
+func decompressBody(body io.Reader, contentEncoding string) ([]byte, error) {
+	br := bufio.NewReader(body)
+	if _, err := br.Peek(1); err == io.EOF {
+		return nil, nil
+	}
+	body = br
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		reader = gr
+	case "deflate":
+		//标准的deflate是带zlib头的，但有些服务器直接返回裸的deflate数据
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(raw))
+			defer fr.Close()
+			reader = fr
+		} else {
+			defer zr.Close()
+			reader = zr
+		}
+	case "br":
+		reader = brotli.NewReader(body)
+	default:
+		reader = body
+	}
+	return ioutil.ReadAll(reader)
+}
+
 //对原始cookie进行五马分尸
 func SplitRawCookie(ck string) (ret map[string]string) {
+	ret = make(map[string]string)
 	ck = strings.TrimSpace(ck)
 	if len(ck) == 0 {
 		return
@@ -107,12 +253,18 @@ func SplitRawCookie(ck string) (ret map[string]string) {
 
 //合并cookie
 func JoinRawCookie(ck map[string]string) (ret string) {
-	if len(ret) == 0 {
+	if len(ck) == 0 {
 		return ""
 	}
+	var keys []string
+	for k := range ck {
+		keys = append(keys, k)
+	}
+	//按key排序，保证每次拼出来的一样
+	sort.Strings(keys)
 	var tmp []string
-	for k, v := range ck {
-		tmp = append(tmp, k+"="+v)
+	for _, k := range keys {
+		tmp = append(tmp, k+"="+ck[k])
 	}
 	ret = strings.Join(tmp, "; ")
 	return
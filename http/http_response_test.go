@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToFuResponse_Decode(t *testing.T) {
+	fmt.Println(t.Name())
+	gbkBody, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("<html><body>你好，世界</body></html>"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Add("Set-Cookie", "a=1; Path=/")
+			w.Header().Add("Set-Cookie", "b=2; Path=/")
+			w.Header().Add("Link", `</p2>; rel="next"`)
+			w.Header().Add("Link", `</p1>; rel="prev"`)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"name":"wendao","age":18}`)
+		case "/xml":
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(`<?xml version="1.0" encoding="GBK"?><user><name>`))
+			name, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("问道"))
+			w.Write(name)
+			w.Write([]byte(`</name></user>`))
+		case "/gbk":
+			w.Header().Set("Content-Type", "text/html; charset=gb2312")
+			w.Write(gbkBody)
+		case "/nocharset":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(gbkBody)
+		case "/longjson":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"pad":"%s","name":"问道"}`, strings.Repeat("a", 2048))
+		case "/empty":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusNoContent)
+		case "/gzip", "/deflate", "/br":
+			var buf bytes.Buffer
+			switch r.URL.Path {
+			case "/gzip":
+				zw := gzip.NewWriter(&buf)
+				zw.Write([]byte("compressed"))
+				zw.Close()
+				w.Header().Set("Content-Encoding", "gzip")
+			case "/deflate":
+				zw := zlib.NewWriter(&buf)
+				zw.Write([]byte("compressed"))
+				zw.Close()
+				w.Header().Set("Content-Encoding", "deflate")
+			case "/br":
+				zw := brotli.NewWriter(&buf)
+				zw.Write([]byte("compressed"))
+				zw.Close()
+				w.Header().Set("Content-Encoding", "br")
+			}
+			w.Write(buf.Bytes())
+		}
+	}))
+	defer ts.Close()
+
+	resp, err := NewHttpClient().SetUrl(ts.URL + "/json").Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := resp.GetHeaderValues("Set-Cookie"); len(vs) != 2 {
+		t.Errorf("Set-Cookie values: %v", vs)
+	}
+	if vs := resp.GetHeaders()["Link"]; len(vs) != 2 || vs[1] != `</p1>; rel="prev"` {
+		t.Errorf("Link values: %v", vs)
+	}
+	var user struct {
+		Name string `json:"name" xml:"name"`
+		Age  int    `json:"age"`
+	}
+	if err = resp.DecodeJSON(&user); err != nil || user.Name != "wendao" || user.Age != 18 {
+		t.Errorf("DecodeJSON: %v %+v", err, user)
+	}
+
+	resp, _ = NewHttpClient().SetUrl(ts.URL + "/xml").Get()
+	if err = resp.DecodeXML(&user); err != nil || user.Name != "问道" {
+		t.Errorf("DecodeXML: %v %+v", err, user)
+	}
+
+	for _, p := range []string{"/gbk", "/nocharset"} {
+		resp, _ = NewHttpClient().SetUrl(ts.URL + p).Get()
+		text, err := resp.GetBodyText()
+		if err != nil || text != "<html><body>你好，世界</body></html>" {
+			t.Errorf("GetBodyText %s: %v %s", p, err, text)
+		}
+	}
+	if b, err := resp.GetBodyWithCharset("gbk"); err != nil || string(b) != "<html><body>你好，世界</body></html>" {
+		t.Errorf("GetBodyWithCharset: %v %s", err, b)
+	}
+
+	//超过1K的ASCII后才有中文，不能被当成windows-1252
+	resp, _ = NewHttpClient().SetUrl(ts.URL + "/longjson").Get()
+	var long struct {
+		Name string `json:"name"`
+	}
+	if err = resp.DecodeJSON(&long); err != nil || long.Name != "问道" {
+		t.Errorf("DecodeJSON long: %v %+v", err, long)
+	}
+	if text, err := resp.GetBodyText(); err != nil || !strings.HasSuffix(text, `"name":"问道"}`) {
+		t.Errorf("GetBodyText long: %v %s", err, text[len(text)-20:])
+	}
+
+	//204时body为空，不用解压
+	resp, err = NewHttpClient().SetUrl(ts.URL + "/empty").Get()
+	if err != nil || len(resp.GetBody()) != 0 {
+		t.Errorf("empty gzip body: %v %q", err, resp.GetBody())
+	}
+
+	for _, p := range []string{"/gzip", "/deflate", "/br"} {
+		resp, err = NewHttpClient().SetUrl(ts.URL + p).Get()
+		if err != nil || resp.GetBodyString() != "compressed" {
+			t.Errorf("decompress %s: %v %q", p, err, resp.GetBodyString())
+		}
+	}
+}
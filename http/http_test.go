@@ -2,6 +2,7 @@ package http
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
 )
 
@@ -34,3 +35,21 @@ func fortest(ch chan int) {
 	fmt.Println(err, ret.GetBodyString())
 	ch <- 1
 }
+
+func TestToFuHttp_Clone(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := newEchoServer()
+	defer ts.Close()
+	c := NewHttpClient().SetUrl(ts.URL).SetMethod(http.MethodPut).SetRawBody([]byte("old")).SetMultipart(true)
+	//复制出来的不带原来的body，用自己的字段
+	resp, err := c.Clone().AddField("name", "tom").Do()
+	var got struct {
+		Method string
+		Body   string
+		Ct     string
+	}
+	resp.DecodeJSON(&got)
+	if err != nil || got.Method != http.MethodPut || got.Body != "name=tom" || got.Ct != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected %v %+v", err, got)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+//提取transport的TLS配置，没有时新建一个
+func (httpReq *ToFuHttp) tlsConfig() *tls.Config {
+	if httpReq.transport.TLSClientConfig == nil {
+		httpReq.transport.TLSClientConfig = &tls.Config{}
+	}
+	return httpReq.transport.TLSClientConfig
+}
+
+//直接设置TLS配置，会覆盖之前的其他TLS设置
+func (httpReq *ToFuHttp) SetTLSConfig(c *tls.Config) *ToFuHttp {
+	httpReq.transport.TLSClientConfig = c
+	return httpReq
+}
+
+//设置信任的根证书，不设置时用系统的
+func (httpReq *ToFuHttp) SetRootCAs(pool *x509.CertPool) *ToFuHttp {
+	httpReq.tlsConfig().RootCAs = pool
+	return httpReq
+}
+
+//从PEM文件中添加信任的根证书，如自签名的CA
+func (httpReq *ToFuHttp) AddRootCAFile(pemFile string) error {
+	data, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		return err
+	}
+	c := httpReq.tlsConfig()
+	if c.RootCAs == nil {
+		c.RootCAs = x509.NewCertPool()
+	}
+	if !c.RootCAs.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no valid certificate in %s", pemFile)
+	}
+	return nil
+}
+
+//设置双向认证用的客户端证书
+func (httpReq *ToFuHttp) SetClientCert(cert tls.Certificate) *ToFuHttp {
+	c := httpReq.tlsConfig()
+	c.Certificates = append(c.Certificates, cert)
+	return httpReq
+}
+
+//从PEM文件中加载双向认证用的客户端证书、私钥
+func (httpReq *ToFuHttp) SetClientCertFile(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	httpReq.SetClientCert(cert)
+	return nil
+}
+
+//是否跳过证书校验，只能在测试环境下使用
+func (httpReq *ToFuHttp) SetInsecureSkipVerify(b bool) *ToFuHttp {
+	httpReq.tlsConfig().InsecureSkipVerify = b
+	return httpReq
+}
+
+//设置最低的TLS版本，如tls.VersionTLS12
+func (httpReq *ToFuHttp) SetMinTLSVersion(v uint16) *ToFuHttp {
+	httpReq.tlsConfig().MinVersion = v
+	return httpReq
+}
+
+//设置SNI用的服务器名称，默认为请求的host
+func (httpReq *ToFuHttp) SetServerName(name string) *ToFuHttp {
+	httpReq.tlsConfig().ServerName = name
+	return httpReq
+}
@@ -0,0 +1,260 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+//单个请求各阶段的耗时，没有经历的阶段为0（如复用连接时没有DNS、建连、TLS握手）
+//有重定向时为最后一跳的耗时，Total包含所有跳及读取body的时间
+type ToFuTrace struct {
+	Host       string        //请求的host
+	RemoteAddr string        //实际连接的地址
+	Reused     bool          //是否复用了连接
+	DNS        time.Duration //DNS解析
+	Connect    time.Duration //建立TCP连接
+	TLS        time.Duration //TLS握手
+	TTFB       time.Duration //从开始请求到收到第一个字节
+	Total      time.Duration //从开始请求到读完body
+}
+
+//某个阶段耗时的汇总
+type ToFuTiming struct {
+	Count int64 //经历了此阶段的请求数
+	Total time.Duration
+	Avg   time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+//按host汇总的耗时统计
+type ToFuTraceStat struct {
+	Host     string //host，全局汇总时为空
+	Requests int64  //请求数
+	Reused   int64  //复用了连接的请求数
+	Errors   int64  //出错的请求数
+	DNS      ToFuTiming
+	Connect  ToFuTiming
+	TLS      ToFuTiming
+	TTFB     ToFuTiming
+	Total    ToFuTiming
+}
+
+//请求耗时的汇总统计，多个client可以共用一个
+type ToFuTracer struct {
+	lock  *sync.Mutex
+	stats map[string]*ToFuTraceStat
+}
+
+//实例化一个耗时统计
+func NewTracer() *ToFuTracer {
+	return &ToFuTracer{
+		lock:  new(sync.Mutex),
+		stats: make(map[string]*ToFuTraceStat),
+	}
+}
+
+//设置耗时统计，每个请求的耗时会按host汇总进去
+func (httpReq *ToFuHttp) SetTracer(t *ToFuTracer) *ToFuHttp {
+	httpReq.tracer = t
+	return httpReq
+}
+
+//提取当前用的耗时统计，未设置时为nil
+func (httpReq *ToFuHttp) GetTracer() *ToFuTracer {
+	return httpReq.tracer
+}
+
+//提取某个host的统计信息
+func (t *ToFuTracer) GetHostStat(host string) ToFuTraceStat {
+	host = limiterHost(host)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st, ok := t.stats[host]
+	if !ok {
+		return ToFuTraceStat{Host: host}
+	}
+	return st.export()
+}
+
+//提取所有host的统计信息
+func (t *ToFuTracer) GetStats() map[string]ToFuTraceStat {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make(map[string]ToFuTraceStat)
+	for host, st := range t.stats {
+		ret[host] = st.export()
+	}
+	return ret
+}
+
+//所有host汇总的统计信息
+func (t *ToFuTracer) GetTotalStat() ToFuTraceStat {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	total := &ToFuTraceStat{}
+	for _, st := range t.stats {
+		total.Requests += st.Requests
+		total.Reused += st.Reused
+		total.Errors += st.Errors
+		total.DNS.merge(st.DNS)
+		total.Connect.merge(st.Connect)
+		total.TLS.merge(st.TLS)
+		total.TTFB.merge(st.TTFB)
+		total.Total.merge(st.Total)
+	}
+	return total.export()
+}
+
+//清空统计信息
+func (t *ToFuTracer) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stats = make(map[string]*ToFuTraceStat)
+}
+
+//汇总一个请求的耗时
+func (t *ToFuTracer) add(tr ToFuTrace, err error) {
+	host := limiterHost(tr.Host)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st, ok := t.stats[host]
+	if !ok {
+		st = &ToFuTraceStat{Host: host}
+		t.stats[host] = st
+	}
+	st.Requests++
+	if err != nil {
+		st.Errors++
+	}
+	if tr.Reused {
+		st.Reused++
+	}
+	st.DNS.add(tr.DNS)
+	st.Connect.add(tr.Connect)
+	st.TLS.add(tr.TLS)
+	st.TTFB.add(tr.TTFB)
+	st.Total.add(tr.Total)
+}
+
+//导出统计信息，计算平均值
+func (st *ToFuTraceStat) export() ToFuTraceStat {
+	ret := *st
+	for _, tm := range []*ToFuTiming{&ret.DNS, &ret.Connect, &ret.TLS, &ret.TTFB, &ret.Total} {
+		if tm.Count > 0 {
+			tm.Avg = tm.Total / time.Duration(tm.Count)
+		}
+	}
+	return ret
+}
+
+//累加一次耗时，为0表示没有经历此阶段
+func (tm *ToFuTiming) add(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if tm.Count == 0 || d < tm.Min {
+		tm.Min = d
+	}
+	if d > tm.Max {
+		tm.Max = d
+	}
+	tm.Count++
+	tm.Total += d
+}
+
+//合并另一个汇总
+func (tm *ToFuTiming) merge(o ToFuTiming) {
+	if o.Count == 0 {
+		return
+	}
+	if tm.Count == 0 || o.Min < tm.Min {
+		tm.Min = o.Min
+	}
+	if o.Max > tm.Max {
+		tm.Max = o.Max
+	}
+	tm.Count += o.Count
+	tm.Total += o.Total
+}
+
+//记录单个请求各阶段的时间点，httptrace的回调可能在不同的goroutine里
+type reqTrace struct {
+	lock      sync.Mutex
+	start     time.Time
+	dnsStart  time.Time
+	connStart time.Time
+	tlsStart  time.Time
+	trace     ToFuTrace
+}
+
+//开始记录一个请求
+func newReqTrace(host string) *reqTrace {
+	return &reqTrace{start: time.Now(), trace: ToFuTrace{Host: host}}
+}
+
+//生成httptrace的回调
+func (rt *reqTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			rt.lock.Lock()
+			rt.dnsStart = time.Now()
+			rt.lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rt.lock.Lock()
+			rt.trace.DNS = time.Since(rt.dnsStart)
+			rt.lock.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			rt.lock.Lock()
+			rt.connStart = time.Now()
+			rt.lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			rt.lock.Lock()
+			if err == nil {
+				rt.trace.Connect = time.Since(rt.connStart)
+			}
+			rt.lock.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			rt.lock.Lock()
+			rt.tlsStart = time.Now()
+			rt.lock.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rt.lock.Lock()
+			rt.trace.TLS = time.Since(rt.tlsStart)
+			rt.lock.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.lock.Lock()
+			rt.trace.Reused = info.Reused
+			if info.Conn != nil {
+				rt.trace.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+			rt.lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			rt.lock.Lock()
+			rt.trace.TTFB = time.Since(rt.start)
+			rt.lock.Unlock()
+		},
+	}
+}
+
+//请求结束，返回各阶段的耗时
+func (rt *reqTrace) done() ToFuTrace {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.trace.Total = time.Since(rt.start)
+	return rt.trace
+}
@@ -0,0 +1,99 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+//实例化底层的transport，默认值与http.DefaultTransport一致，每个host的空闲连接数调大一些
+func newTransport(dialer *net.Dialer) *http.Transport {
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+//设置是否使用长连接，默认使用
+func (httpReq *ToFuHttp) SetKeepAlive(b bool) *ToFuHttp {
+	httpReq.transport.DisableKeepAlives = !b
+	return httpReq
+}
+
+//设置所有host总的最大空闲连接数，0表示不限制
+func (httpReq *ToFuHttp) SetMaxIdleConns(n int) *ToFuHttp {
+	httpReq.transport.MaxIdleConns = n
+	return httpReq
+}
+
+//设置每个host的最大空闲连接数，默认10
+func (httpReq *ToFuHttp) SetMaxIdleConnsPerHost(n int) *ToFuHttp {
+	httpReq.transport.MaxIdleConnsPerHost = n
+	return httpReq
+}
+
+//设置每个host的最大连接数（包括正在用的），0表示不限制
+func (httpReq *ToFuHttp) SetMaxConnsPerHost(n int) *ToFuHttp {
+	httpReq.transport.MaxConnsPerHost = n
+	return httpReq
+}
+
+//设置空闲连接多久后关闭，默认90秒
+func (httpReq *ToFuHttp) SetIdleConnTimeout(t time.Duration) *ToFuHttp {
+	httpReq.transport.IdleConnTimeout = t
+	return httpReq
+}
+
+//设置建立TCP连接的超时时间，默认30秒
+func (httpReq *ToFuHttp) SetDialTimeout(t time.Duration) *ToFuHttp {
+	httpReq.dialer.Timeout = t
+	return httpReq
+}
+
+//设置TCP keep-alive探测的间隔，默认30秒，负数表示不探测
+func (httpReq *ToFuHttp) SetTCPKeepAlive(t time.Duration) *ToFuHttp {
+	httpReq.dialer.KeepAlive = t
+	return httpReq
+}
+
+//设置TLS握手的超时时间，默认10秒
+func (httpReq *ToFuHttp) SetTLSHandshakeTimeout(t time.Duration) *ToFuHttp {
+	httpReq.transport.TLSHandshakeTimeout = t
+	return httpReq
+}
+
+//设置等待响应头的超时时间，0表示不限制（仍受SetTimeout的总超时限制）
+func (httpReq *ToFuHttp) SetResponseHeaderTimeout(t time.Duration) *ToFuHttp {
+	httpReq.transport.ResponseHeaderTimeout = t
+	return httpReq
+}
+
+//设置是否尝试HTTP/2，默认尝试
+//自定义了TLS设置后标准库默认不再尝试HTTP/2，打开此项后仍会通过ALPN协商HTTP/2
+//关闭时只用HTTP/1.1，需在发请求之前设置
+func (httpReq *ToFuHttp) SetForceHTTP2(b bool) *ToFuHttp {
+	httpReq.transport.ForceAttemptHTTP2 = b
+	if b {
+		httpReq.transport.TLSNextProto = nil
+	} else {
+		//TLSNextProto为非nil的空map时禁用HTTP/2
+		httpReq.transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return httpReq
+}
+
+//关闭所有空闲的连接
+func (httpReq *ToFuHttp) CloseIdleConnections() {
+	httpReq.transport.CloseIdleConnections()
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestToFuHttp_KeepAlive(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	for _, keep := range []bool{true, false} {
+		tracer := NewTracer()
+		client := NewHttpClient().SetKeepAlive(keep).SetTracer(tracer).SetUrl(ts.URL)
+		var last ToFuResponse
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			last = resp
+		}
+		if last.GetTrace().Reused != keep {
+			t.Errorf("keepalive=%v: reused=%v", keep, last.GetTrace().Reused)
+		}
+		st := tracer.GetHostStat(ts.Listener.Addr().String())
+		wantReused := int64(0)
+		if keep {
+			wantReused = 2
+		}
+		if st.Requests != 3 || st.Reused != wantReused {
+			t.Errorf("keepalive=%v: stat %+v", keep, st)
+		}
+		if st.TTFB.Count != 3 || st.TTFB.Avg <= 0 || st.TTFB.Min > st.TTFB.Max {
+			t.Errorf("keepalive=%v: ttfb %+v", keep, st.TTFB)
+		}
+		if st.Connect.Count != 3-wantReused {
+			t.Errorf("keepalive=%v: connect %+v", keep, st.Connect)
+		}
+	}
+}
+
+func TestToFuHttp_TraceTLS(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	tracer := NewTracer()
+	resp, err := NewHttpClient().SetInsecureSkipVerify(true).
+		SetMaxIdleConnsPerHost(2).
+		SetIdleConnTimeout(time.Minute).
+		SetDialTimeout(time.Second).
+		SetTLSHandshakeTimeout(time.Second).
+		SetTracer(tracer).SetUrl(ts.URL).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetBodyString() != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2, got %s", resp.GetBodyString())
+	}
+	tr := resp.GetTrace()
+	if tr.TLS <= 0 || tr.Connect <= 0 || tr.TTFB <= 0 || tr.Total < tr.TTFB || tr.Reused {
+		t.Errorf("unexpected trace %+v", tr)
+	}
+
+	//关闭HTTP/2
+	resp, err = NewHttpClient().SetInsecureSkipVerify(true).SetForceHTTP2(false).SetTracer(tracer).SetUrl(ts.URL).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetBodyString() != "HTTP/1.1" {
+		t.Errorf("expected HTTP/1.1, got %s", resp.GetBodyString())
+	}
+	total := tracer.GetTotalStat()
+	if total.Requests != 2 || total.TLS.Count != 2 || total.Errors != 0 {
+		t.Errorf("unexpected total %+v", total)
+	}
+
+	//出错的也会统计
+	ts.Close()
+	if _, err = NewHttpClient().SetTracer(tracer).SetUrl(ts.URL).Get(); err == nil {
+		t.Fatal("expected error")
+	}
+	if total = tracer.GetTotalStat(); total.Errors != 1 {
+		t.Errorf("errors %d", total.Errors)
+	}
+	tracer.Reset()
+	if len(tracer.GetStats()) != 0 {
+		t.Errorf("reset failed")
+	}
+}
@@ -0,0 +1,414 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//限流器：令牌桶限制每秒请求数（全局、每个host），同时限制每个host同时进行中的请求数
+//达到限制时请求会排队等待，等待期间context取消则直接返回错误
+type ToFuLimiter struct {
+	lock         *sync.Mutex
+	global       *tokenBucket            //全局的令牌桶，nil表示不限制
+	hostRate     float64                 //每个host默认的每秒请求数，<=0表示不限制
+	hostBurst    int                     //每个host默认的突发请求数
+	hostRates    map[string]bucketConf   //单独设置过的host
+	buckets      map[string]*tokenBucket //每个host的令牌桶
+	maxInFlight  int                     //每个host默认最多同时进行中的请求数，<=0表示不限制
+	hostInFlight map[string]int          //单独设置过的host
+	slots        map[string]chan struct{}
+	stats        map[string]*limiterHostStat
+}
+
+//令牌桶的配置
+type bucketConf struct {
+	rate  float64
+	burst int
+}
+
+//每个host的统计信息
+type limiterHostStat struct {
+	waiting   int
+	inFlight  int
+	requests  int64
+	waited    int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	lastWait  time.Duration
+}
+
+//限流器的统计信息
+type ToFuLimiterStat struct {
+	Host      string        //host，全局汇总时为空
+	Waiting   int           //当前正在排队的请求数
+	InFlight  int           //当前正在进行中的请求数
+	Requests  int64         //通过限流器的请求总数
+	Waited    int64         //需要等待的请求数
+	TotalWait time.Duration //总共等待的时间
+	AvgWait   time.Duration //平均每个请求等待的时间
+	MaxWait   time.Duration //等待最长的一次
+	LastWait  time.Duration //最近一次等待的时间
+}
+
+//实例化一个限流器，默认不做任何限制
+func NewLimiter() *ToFuLimiter {
+	return &ToFuLimiter{
+		lock:         new(sync.Mutex),
+		hostRates:    make(map[string]bucketConf),
+		buckets:      make(map[string]*tokenBucket),
+		hostInFlight: make(map[string]int),
+		slots:        make(map[string]chan struct{}),
+		stats:        make(map[string]*limiterHostStat),
+	}
+}
+
+//设置全局每秒的请求数及允许的突发请求数
+func (l *ToFuLimiter) SetGlobalRate(rate float64, burst int) *ToFuLimiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if rate <= 0 {
+		l.global = nil
+	} else {
+		l.global = newTokenBucket(rate, burst)
+	}
+	return l
+}
+
+//设置每个host默认的每秒请求数及允许的突发请求数
+func (l *ToFuLimiter) SetHostRate(rate float64, burst int) *ToFuLimiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.hostRate = rate
+	l.hostBurst = burst
+	//已经创建过的令牌桶重新按新配置创建
+	for host := range l.buckets {
+		if _, ok := l.hostRates[host]; !ok {
+			delete(l.buckets, host)
+		}
+	}
+	return l
+}
+
+//单独设置某个host的每秒请求数及允许的突发请求数
+func (l *ToFuLimiter) SetRateForHost(host string, rate float64, burst int) *ToFuLimiter {
+	host = limiterHost(host)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.hostRates[host] = bucketConf{rate: rate, burst: burst}
+	delete(l.buckets, host)
+	return l
+}
+
+//设置每个host默认最多同时进行中的请求数
+func (l *ToFuLimiter) SetMaxInFlight(n int) *ToFuLimiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.maxInFlight = n
+	for host := range l.slots {
+		if _, ok := l.hostInFlight[host]; !ok {
+			delete(l.slots, host)
+		}
+	}
+	return l
+}
+
+//单独设置某个host最多同时进行中的请求数
+func (l *ToFuLimiter) SetMaxInFlightForHost(host string, n int) *ToFuLimiter {
+	host = limiterHost(host)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.hostInFlight[host] = n
+	delete(l.slots, host)
+	return l
+}
+
+//等待直到可以向host发起请求，返回的release在请求结束后必须调用
+//context取消或超时时返回context的错误
+func (l *ToFuLimiter) Wait(ctx context.Context, host string) (release func(), err error) {
+	host = limiterHost(host)
+	start := time.Now()
+
+	l.lock.Lock()
+	st := l.hostStat(host)
+	st.waiting++
+	slot := l.hostSlot(host)
+	bucket := l.hostBucket(host)
+	global := l.global
+	l.lock.Unlock()
+
+	finish := func(ok bool) {
+		wait := time.Since(start)
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		st.waiting--
+		if !ok {
+			return
+		}
+		st.inFlight++
+		st.requests++
+		st.lastWait = wait
+		if wait > time.Millisecond {
+			st.waited++
+			st.totalWait += wait
+			if wait > st.maxWait {
+				st.maxWait = wait
+			}
+		}
+	}
+
+	//先占一个并发的位置
+	if slot != nil {
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			finish(false)
+			return nil, ctx.Err()
+		}
+	}
+	releaseSlot := func() {
+		if slot != nil {
+			<-slot
+		}
+	}
+
+	//再拿令牌，全局的和host的都要拿到
+	now := time.Now()
+	var delay time.Duration
+	var reserved []*tokenBucket
+	for _, b := range []*tokenBucket{global, bucket} {
+		if b == nil {
+			continue
+		}
+		d := b.reserve(now)
+		reserved = append(reserved, b)
+		if d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			for _, b := range reserved {
+				b.cancel()
+			}
+			releaseSlot()
+			finish(false)
+			return nil, ctx.Err()
+		}
+	}
+	finish(true)
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			releaseSlot()
+			l.lock.Lock()
+			st.inFlight--
+			l.lock.Unlock()
+		})
+	}
+	return release, nil
+}
+
+//提取某个host的统计信息
+func (l *ToFuLimiter) GetHostStat(host string) ToFuLimiterStat {
+	host = limiterHost(host)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	st, ok := l.stats[host]
+	if !ok {
+		return ToFuLimiterStat{Host: host}
+	}
+	return st.export(host)
+}
+
+//提取所有host的统计信息
+func (l *ToFuLimiter) GetStats() map[string]ToFuLimiterStat {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ret := make(map[string]ToFuLimiterStat)
+	for host, st := range l.stats {
+		ret[host] = st.export(host)
+	}
+	return ret
+}
+
+//所有host汇总的统计信息
+func (l *ToFuLimiter) GetTotalStat() ToFuLimiterStat {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	total := &limiterHostStat{}
+	for _, st := range l.stats {
+		total.waiting += st.waiting
+		total.inFlight += st.inFlight
+		total.requests += st.requests
+		total.waited += st.waited
+		total.totalWait += st.totalWait
+		if st.maxWait > total.maxWait {
+			total.maxWait = st.maxWait
+		}
+	}
+	return total.export("")
+}
+
+//导出统计信息
+func (st *limiterHostStat) export(host string) ToFuLimiterStat {
+	ret := ToFuLimiterStat{
+		Host:      host,
+		Waiting:   st.waiting,
+		InFlight:  st.inFlight,
+		Requests:  st.requests,
+		Waited:    st.waited,
+		TotalWait: st.totalWait,
+		MaxWait:   st.maxWait,
+		LastWait:  st.lastWait,
+	}
+	if st.requests > 0 {
+		ret.AvgWait = st.totalWait / time.Duration(st.requests)
+	}
+	return ret
+}
+
+//提取host的统计信息，调用方需持有锁
+func (l *ToFuLimiter) hostStat(host string) *limiterHostStat {
+	st, ok := l.stats[host]
+	if !ok {
+		st = &limiterHostStat{}
+		l.stats[host] = st
+	}
+	return st
+}
+
+//提取host的并发位置，调用方需持有锁
+func (l *ToFuLimiter) hostSlot(host string) chan struct{} {
+	if slot, ok := l.slots[host]; ok {
+		return slot
+	}
+	n, ok := l.hostInFlight[host]
+	if !ok {
+		n = l.maxInFlight
+	}
+	if n <= 0 {
+		return nil
+	}
+	slot := make(chan struct{}, n)
+	l.slots[host] = slot
+	return slot
+}
+
+//提取host的令牌桶，调用方需持有锁
+func (l *ToFuLimiter) hostBucket(host string) *tokenBucket {
+	if b, ok := l.buckets[host]; ok {
+		return b
+	}
+	conf, ok := l.hostRates[host]
+	if !ok {
+		conf = bucketConf{rate: l.hostRate, burst: l.hostBurst}
+	}
+	if conf.rate <= 0 {
+		return nil
+	}
+	b := newTokenBucket(conf.rate, conf.burst)
+	l.buckets[host] = b
+	return b
+}
+
+//统一host格式
+func limiterHost(host string) string {
+	return strings.ToLower(host)
+}
+
+//令牌桶
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64   //每秒生成的令牌数
+	burst  float64   //桶的容量
+	tokens float64   //当前的令牌数，可以为负数，表示已经被预定了
+	last   time.Time //上次计算令牌的时间
+}
+
+//实例化一个令牌桶，初始时是满的
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+//预定一个令牌，返回需要等待多久才能用
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+//取消预定，把令牌还回去
+func (b *tokenBucket) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+//带限流的transport
+type limiterTransport struct {
+	next    http.RoundTripper
+	limiter *ToFuLimiter
+}
+
+//实现http.RoundTripper接口，body读完关闭后才释放并发的位置
+func (t *limiterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Wait(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+//关闭时调用release的body
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
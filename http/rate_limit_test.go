@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestToFuLimiter_Rate(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	limiter := NewLimiter().SetGlobalRate(20, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := NewHttpClient().SetLimiter(limiter).SetUrl(ts.URL).Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//第一个直接通过，后面4个每个等50ms
+	if cost := time.Since(start); cost < 180*time.Millisecond {
+		t.Errorf("rate limit not applied, cost %v", cost)
+	}
+	st := limiter.GetTotalStat()
+	if st.Requests != 5 || st.Waited < 3 || st.InFlight != 0 {
+		t.Errorf("unexpected stat: %+v", st)
+	}
+
+	//等待期间context超时
+	limiter = NewLimiter().SetHostRate(1, 1)
+	NewHttpClient().SetLimiter(limiter).SetUrl(ts.URL).Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err := NewHttpClient().SetLimiter(limiter).SetContext(ctx).SetUrl(ts.URL).Get()
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("context not honored: %v %v", err, time.Since(start))
+	}
+}
+
+func TestToFuLimiter_InFlight(t *testing.T) {
+	fmt.Println(t.Name())
+	var cur, maxN int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			m := atomic.LoadInt32(&maxN)
+			if n <= m || atomic.CompareAndSwapInt32(&maxN, m, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		atomic.AddInt32(&cur, -1)
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	limiter := NewLimiter().SetMaxInFlight(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewHttpClient().SetLimiter(limiter).SetUrl(ts.URL).Get()
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if st := limiter.GetTotalStat(); st.Waiting == 0 {
+		t.Errorf("expected queued requests: %+v", st)
+	}
+	wg.Wait()
+	if maxN != 2 {
+		t.Errorf("max in flight %d, want 2", maxN)
+	}
+	if st := limiter.GetTotalStat(); st.Requests != 6 || st.InFlight != 0 || st.Waiting != 0 {
+		t.Errorf("unexpected stat: %+v", st)
+	}
+}
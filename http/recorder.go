@@ -0,0 +1,382 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     http
+ * @date        2018-01-25 19:19
+ */
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//录制回放的模式
+type RecorderMode int
+
+const (
+	RecorderReplayOrRecord RecorderMode = iota //有录制的就回放，没有的发起真实请求并录制下来
+	RecorderReplay                             //只回放，没有录制的按strict决定报错还是发起真实请求
+	RecorderRecord                             //总是发起真实请求并录制，覆盖原有的录制
+)
+
+//被替换掉的敏感头信息的值
+const redactedValue = "[REDACTED]"
+
+//判断请求与录制的交互是否匹配，body为请求的body
+type RecordMatcher func(req *http.Request, body []byte, i *Interaction) bool
+
+//按请求方法匹配
+func MatchMethod(req *http.Request, body []byte, i *Interaction) bool {
+	return req.Method == i.Request.Method
+}
+
+//按完整的URL匹配
+func MatchURL(req *http.Request, body []byte, i *Interaction) bool {
+	return req.URL.String() == i.Request.URL
+}
+
+//按body的sha256匹配
+func MatchBodyHash(req *http.Request, body []byte, i *Interaction) bool {
+	return bodyHash(body) == i.Request.BodyHash
+}
+
+//按指定的头信息匹配
+//录制时被替换掉的敏感头信息（如Authorization、Cookie）没办法比较值，只要求个数相同
+func MatchHeaders(names ...string) RecordMatcher {
+	return func(req *http.Request, body []byte, i *Interaction) bool {
+		for _, name := range names {
+			live, recorded := req.Header.Values(name), i.Request.Header.Values(name)
+			if isRedacted(recorded) {
+				if len(live) != len(recorded) {
+					return false
+				}
+				continue
+			}
+			if strings.Join(live, "\n") != strings.Join(recorded, "\n") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+//录制的值是否都被替换掉了
+func isRedacted(vs []string) bool {
+	for _, v := range vs {
+		if v != redactedValue {
+			return false
+		}
+	}
+	return len(vs) > 0
+}
+
+//没有录制时返回的错误
+type RecorderMissError struct {
+	Method string
+	URL    string
+}
+
+func (e *RecorderMissError) Error() string {
+	return fmt.Sprintf("recorder: no recorded interaction for %s %s", e.Method, e.URL)
+}
+
+//一次录制的请求及响应
+type Interaction struct {
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+	Duration   time.Duration    `json:"duration"`
+}
+
+//录制的请求
+type RecordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` //为"base64"时表示body是二进制数据
+	BodyHash     string      `json:"body_hash"`
+}
+
+//录制的响应
+type RecordedResponse struct {
+	Status       string      `json:"status"`
+	StatusCode   int         `json:"status_code"`
+	Proto        string      `json:"proto"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+//录制文件的格式
+type cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+//录制、回放http请求，用于离线测试使用ToFuHttp的代码
+type ToFuRecorder struct {
+	lock         *sync.Mutex
+	cassettePath string
+	mode         RecorderMode
+	strict       bool
+	matchers     []RecordMatcher
+	redact       map[string]bool
+	interactions []*Interaction
+	used         map[*Interaction]bool
+	dirty        bool
+}
+
+//实例化一个录制器，录制文件已存在时会加载进来
+//默认按请求方法、URL匹配，Authorization、Proxy-Authorization、Cookie、Set-Cookie、X-Api-Key会被替换掉
+func NewRecorder(cassettePath string, mode RecorderMode) (*ToFuRecorder, error) {
+	r := &ToFuRecorder{
+		lock:         new(sync.Mutex),
+		cassettePath: cassettePath,
+		mode:         mode,
+		matchers:     []RecordMatcher{MatchMethod, MatchURL},
+		redact:       make(map[string]bool),
+		used:         make(map[*Interaction]bool),
+	}
+	r.RedactHeaders("Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key")
+	if mode == RecorderRecord {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(cassettePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+	var c cassette
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %v", cassettePath, err)
+	}
+	r.interactions = c.Interactions
+	return r, nil
+}
+
+//设置严格模式，回放模式下没有录制的请求直接报错
+func (r *ToFuRecorder) SetStrict(b bool) *ToFuRecorder {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.strict = b
+	return r
+}
+
+//设置匹配规则，所有的规则都满足才算匹配
+func (r *ToFuRecorder) SetMatchers(matchers ...RecordMatcher) *ToFuRecorder {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.matchers = matchers
+	return r
+}
+
+//追加要替换掉的敏感头信息，请求、响应里的都会替换
+func (r *ToFuRecorder) RedactHeaders(names ...string) *ToFuRecorder {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, name := range names {
+		r.redact[http.CanonicalHeaderKey(name)] = true
+	}
+	return r
+}
+
+//所有录制的交互
+func (r *ToFuRecorder) Interactions() []*Interaction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*Interaction(nil), r.interactions...)
+}
+
+//保存录制文件，没有新录制的不会写文件
+func (r *ToFuRecorder) Save() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(cassette{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(r.cassettePath, data, 0644); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+//找匹配的交互，优先用没用过的，都用过了就用最后一个匹配的
+func (r *ToFuRecorder) find(req *http.Request, body []byte) *Interaction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var last *Interaction
+	for _, i := range r.interactions {
+		matched := true
+		for _, m := range r.matchers {
+			if !m(req, body, i) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+//录制一次交互
+func (r *ToFuRecorder) record(i *Interaction) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.redactHeader(i.Request.Header)
+	r.redactHeader(i.Response.Header)
+	r.interactions = append(r.interactions, i)
+	r.used[i] = true
+	r.dirty = true
+}
+
+//替换掉敏感的头信息，调用方需持有锁
+func (r *ToFuRecorder) redactHeader(h http.Header) {
+	for k, vs := range h {
+		if !r.redact[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for idx := range vs {
+			vs[idx] = redactedValue
+		}
+	}
+}
+
+//录制回放的transport
+type recorderTransport struct {
+	next     http.RoundTripper
+	recorder *ToFuRecorder
+}
+
+//实现http.RoundTripper接口
+func (t *recorderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	rec := t.recorder
+	rec.lock.Lock()
+	mode, strict := rec.mode, rec.strict
+	rec.lock.Unlock()
+
+	if mode != RecorderRecord {
+		if i := rec.find(req, body); i != nil {
+			return i.Response.toResponse(req)
+		}
+		if mode == RecorderReplay {
+			if strict {
+				return nil, &RecorderMissError{Method: req.Method, URL: req.URL.String()}
+			}
+			return t.next.RoundTrip(req)
+		}
+	}
+
+	//发起真实的请求并录制下来
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	i := &Interaction{RecordedAt: start, Duration: time.Since(start)}
+	i.Request.Method = req.Method
+	i.Request.URL = req.URL.String()
+	i.Request.Header = req.Header.Clone()
+	i.Request.Body, i.Request.BodyEncoding = encodeRecordBody(body)
+	i.Request.BodyHash = bodyHash(body)
+	i.Response.Status = resp.Status
+	i.Response.StatusCode = resp.StatusCode
+	i.Response.Proto = resp.Proto
+	i.Response.Header = resp.Header.Clone()
+	i.Response.Body, i.Response.BodyEncoding = encodeRecordBody(respBody)
+	rec.record(i)
+	return resp, nil
+}
+
+//将录制的响应还原成http.Response
+func (rr RecordedResponse) toResponse(req *http.Request) (*http.Response, error) {
+	body, err := decodeRecordBody(rr.Body, rr.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+	proto := rr.Proto
+	if len(proto) == 0 {
+		proto = "HTTP/1.1"
+	}
+	major, minor, _ := http.ParseHTTPVersion(proto)
+	header := rr.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        rr.Status,
+		StatusCode:    rr.StatusCode,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+//文本的body直接存，二进制的用base64
+func encodeRecordBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+//还原body
+func decodeRecordBody(s, enc string) ([]byte, error) {
+	if enc == "base64" {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+//body的sha256
+func bodyHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
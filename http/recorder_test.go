@@ -0,0 +1,71 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToFuRecorder(t *testing.T) {
+	fmt.Println(t.Name())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "secret-sid"})
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, r.PostForm.Get("name"))
+	}))
+	cassettePath := filepath.Join(t.TempDir(), "cassette.json")
+
+	//录制
+	rec, err := NewRecorder(cassettePath, RecorderReplayOrRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.SetMatchers(MatchMethod, MatchURL, MatchBodyHash)
+	client := func() *ToFuHttp {
+		return NewHttpClient().SetRecorder(rec).AddHeader("Authorization", "Bearer secret").AddHeader("Cookie", "sid=secret-cookie")
+	}
+	if _, err = client().SetUrl(ts.URL + "/a").Get(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client().SetUrl(ts.URL+"/b").AddField("name", "wendao").PostBin(); err != nil {
+		t.Fatal(err)
+	}
+	if err = rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	data, _ := ioutil.ReadFile(cassettePath)
+	if strings.Contains(string(data), "secret") || !strings.Contains(string(data), redactedValue) {
+		t.Errorf("Authorization, Cookie, Set-Cookie not redacted: %s", data)
+	}
+
+	//服务已经关掉了，严格模式下回放
+	rec, err = NewRecorder(cassettePath, RecorderReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//被替换掉的头信息也能用来匹配，只要求有这个头
+	rec.SetStrict(true).SetMatchers(MatchMethod, MatchURL, MatchBodyHash, MatchHeaders("Authorization", "Cookie"))
+	resp, err := client().SetUrl(ts.URL + "/a").Get()
+	if err != nil || resp.GetBodyString() != "GET /a " {
+		t.Errorf("replay GET: %v %q", err, resp.GetBodyString())
+	}
+	resp, err = client().SetUrl(ts.URL+"/b").AddField("name", "wendao").PostBin()
+	if err != nil || resp.GetBodyString() != "POST /b wendao" {
+		t.Errorf("replay POST: %v %q", err, resp.GetBodyString())
+	}
+	_, err = client().SetUrl(ts.URL+"/b").AddField("name", "other").PostBin()
+	var miss *RecorderMissError
+	if !errors.As(err, &miss) {
+		t.Errorf("expected RecorderMissError, got %v", err)
+	}
+	_, err = NewHttpClient().SetRecorder(rec).AddHeader("Cookie", "sid=secret-cookie").SetUrl(ts.URL + "/a").Get()
+	if !errors.As(err, &miss) {
+		t.Errorf("missing Authorization should not match, got %v", err)
+	}
+}
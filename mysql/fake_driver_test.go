@@ -0,0 +1,234 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//测试用的假驱动，按SQL返回预先设置好的结果
+func init() {
+	sql.Register("fakemysql", fakeDriver{})
+}
+
+//预先设置的结果
+type fakeResult struct {
+	cols     []string
+	types    []string //字段的类型，如"INT"、"DECIMAL"
+	rows     [][]driver.Value
+	err      error
+	affected int64
+	lastID   int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+//假的服务端，记录执行过的语句
+type fakeServer struct {
+	mu       sync.Mutex
+	results  map[string]fakeResult
+	queries  []string
+	args     [][]driver.Value
+	prepares int
+	closes   int
+	down     bool                                                       //模拟宕机，Ping、Prepare都会失败
+	handler  func(query string, args []driver.Value) (fakeResult, bool) //不为空时按参数返回结果
+}
+
+var (
+	fakeServers  sync.Map
+	fakeServerID int64
+)
+
+//新建一个连到假服务端的DBase
+func newFakeDBase(t *testing.T) (*DBase, *fakeServer) {
+	name := fmt.Sprintf("%s-%d", t.Name(), atomic.AddInt64(&fakeServerID, 1))
+	srv := &fakeServer{results: make(map[string]fakeResult)}
+	fakeServers.Store(name, srv)
+	db, err := sql.Open("fakemysql", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	my := NewDBase(MakeMySQLConf())
+	my.Db = db
+	return my, srv
+}
+
+//设置某条SQL的结果
+func (s *fakeServer) set(query string, r fakeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[query] = r
+}
+
+//执行过的SQL
+func (s *fakeServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+//清空执行过的SQL
+func (s *fakeServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries, s.args = nil, nil
+}
+
+func (s *fakeServer) record(query string, args []driver.Value) (fakeResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, query)
+	s.args = append(s.args, args)
+	if s.handler != nil {
+		if r, ok := s.handler(query, args); ok {
+			return r, ok
+		}
+	}
+	r, ok := s.results[query]
+	return r, ok
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	srv, ok := fakeServers.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake server %s", name)
+	}
+	return &fakeConn{srv: srv.(*fakeServer)}, nil
+}
+
+type fakeConn struct {
+	srv *fakeServer
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	if c.srv.down {
+		return nil, fmt.Errorf("server is down")
+	}
+	c.srv.prepares++
+	return &fakeStmt{srv: c.srv, query: query}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	if c.srv.down {
+		return fmt.Errorf("server is down")
+	}
+	return nil
+}
+
+//模拟宕机、恢复
+func (s *fakeServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.srv.record("BEGIN", nil)
+	return fakeTx{srv: c.srv}, nil
+}
+
+type fakeTx struct {
+	srv *fakeServer
+}
+
+func (tx fakeTx) Commit() error {
+	r, _ := tx.srv.record("COMMIT", nil)
+	return r.err
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.srv.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeStmt struct {
+	srv   *fakeServer
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	s.srv.mu.Lock()
+	s.srv.closes++
+	s.srv.mu.Unlock()
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	r, ok := s.srv.record(s.query, args)
+	if !ok {
+		return fakeResult{affected: 1}, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r, nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	r, ok := s.srv.record(s.query, args)
+	if !ok {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{cols: r.cols, types: r.types, rows: r.rows}, nil
+}
+
+//同go-sql-driver/mysql，不提供字段长度
+type fakeRows struct {
+	cols  []string
+	types []string
+	rows  [][]driver.Value
+	pos   int
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.types) {
+		return r.types[i]
+	}
+	return ""
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
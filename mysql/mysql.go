@@ -6,12 +6,12 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	gItem "github.com/liuyongshuai/goutils/elem"
-	"strconv"
-	"strings"
+	"time"
 )
 
 //MySQL连接的类
@@ -19,6 +19,9 @@ type DBase struct {
 	Db      *sql.DB
 	IsDebug bool
 	Conf    MySQLConf
+	stmts   *stmtCache  //预编译语句的缓存，Conf.StmtCacheSize为0时不缓存
+	tx      *sql.Tx     //不为空时所有的语句都在这个事务里执行，见WithTx
+	hooks   []QueryHook //每条SQL执行前后调用，见AddHook
 }
 
 func NewDBase(conf MySQLConf) *DBase {
@@ -30,17 +33,12 @@ func NewDBase(conf MySQLConf) *DBase {
 }
 
 //提取查询SQL的结果
-func reFormatRowsData(rows *sql.Rows) (ret []map[string]gItem.ItemElem, err error) {
+func reFormatRowsData(rows *sql.Rows, loc *time.Location, bitBool bool) (ret []map[string]gItem.ItemElem, err error) {
 	if rows == nil {
 		return
 	}
 	defer rows.Close()
-	//所有的列名
-	cols, err := rows.Columns()
-	if err != nil {
-		return ret, err
-	}
-	colTypes, err := rows.ColumnTypes()
+	m, err := newRowMapper(rows, loc, bitBool)
 	if err != nil {
 		return ret, err
 	}
@@ -48,57 +46,25 @@ func reFormatRowsData(rows *sql.Rows) (ret []map[string]gItem.ItemElem, err erro
 	if err = rows.Err(); err != nil {
 		return ret, err
 	}
-	//存储每一行值的slice，每次传地址给scan方法
-	vals := make([]sql.RawBytes, len(cols))
-	scanArgs := make([]interface{}, len(vals))
-	for i := range vals {
-		scanArgs[i] = &vals[i]
-	}
 	//遍历每一行，提取数据
 	for rows.Next() {
-		err = rows.Scan(scanArgs...)
+		tmp, err := m.scan(rows)
 		if err != nil {
 			return ret, fmt.Errorf("get data failed")
 		}
-		tmp := make(map[string]gItem.ItemElem)
-		//根据不同的类型来将[]byte转换一下
-		for i, colByteVal := range vals {
-			colType := colTypes[i]
-			realVal := convertMySQLType(colByteVal, colType)
-			tmp[cols[i]] = gItem.MakeItemElem(realVal)
-		}
 		ret = append(ret, tmp)
 	}
-	return ret, nil
+	return ret, rows.Err()
 }
 
 //连接MySQL
 func (my *DBase) Conn() (*DBase, error) {
 	conf := my.Conf
-	if len(conf.Host) <= 0 {
-		return nil, fmt.Errorf("invalid mysql host")
-	}
-	host := conf.Host
-	if len(conf.DbName) <= 0 {
-		return nil, fmt.Errorf("invalid mysql database")
-	}
-	dname := conf.DbName
-	if len(conf.Charset) <= 0 {
-		return nil, fmt.Errorf("invalid mysql charset")
-	}
-	autoCommit := "1"
-	if !conf.AutoCommit {
-		autoCommit = "0"
-	}
-	charset := conf.Charset
-	if conf.Timeout <= 0 {
-		return nil, fmt.Errorf("invalid mysql timeout")
+	if err := conf.Validate(); err != nil {
+		return nil, err
 	}
-	tm := strconv.FormatInt(int64(conf.Timeout), 10)
-	port := strconv.FormatUint(uint64(conf.Port), 10)
 	//开始连接
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s&autocommit=%s&timeout=%ss",
-		conf.User, conf.Passwd, host, port, dname, charset, autoCommit, tm)
+	dsn := conf.FormatDSN()
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		my.Db = nil
@@ -109,6 +75,10 @@ func (my *DBase) Conn() (*DBase, error) {
 	db.SetMaxOpenConns(conf.MaxOpenConns)
 	db.SetConnMaxLifetime(conf.ConnMaxLiftTime)
 	my.Db = db
+	my.stmts = nil
+	if conf.StmtCacheSize > 0 {
+		my.stmts = newStmtCache(db, conf.StmtCacheSize)
+	}
 	return my, nil
 }
 
@@ -119,7 +89,12 @@ func (my *DBase) OpenConnNum() int {
 
 //提取单行的单个字段
 func (my *DBase) FetchOne(sql string, args ...interface{}) (ret gItem.ItemElem, err error) {
-	r, err := my.FetchCols(sql, args...)
+	return my.FetchOneContext(context.Background(), sql, args...)
+}
+
+//提取单行的单个字段，可以用ctx取消
+func (my *DBase) FetchOneContext(ctx context.Context, sql string, args ...interface{}) (ret gItem.ItemElem, err error) {
+	r, err := my.FetchColsContext(ctx, sql, args...)
 	if err != nil {
 		return
 	}
@@ -131,7 +106,12 @@ func (my *DBase) FetchOne(sql string, args ...interface{}) (ret gItem.ItemElem,
 
 //提取所有行的第一个字段的列表
 func (my *DBase) FetchCols(sql string, args ...interface{}) ([]gItem.ItemElem, error) {
-	rets, err := my.FetchRows(sql, args...)
+	return my.FetchColsContext(context.Background(), sql, args...)
+}
+
+//提取所有行的第一个字段的列表，可以用ctx取消
+func (my *DBase) FetchColsContext(ctx context.Context, sql string, args ...interface{}) ([]gItem.ItemElem, error) {
+	rets, err := my.FetchRowsContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +129,12 @@ func (my *DBase) FetchCols(sql string, args ...interface{}) ([]gItem.ItemElem, e
 
 //提取一行数据
 func (my *DBase) FetchRow(sql string, args ...interface{}) (map[string]gItem.ItemElem, error) {
-	ret, err := my.FetchRows(sql, args...)
+	return my.FetchRowContext(context.Background(), sql, args...)
+}
+
+//提取一行数据，可以用ctx取消
+func (my *DBase) FetchRowContext(ctx context.Context, sql string, args ...interface{}) (map[string]gItem.ItemElem, error) {
+	ret, err := my.FetchRowsContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -160,25 +145,44 @@ func (my *DBase) FetchRow(sql string, args ...interface{}) (map[string]gItem.Ite
 }
 
 //提取多行数据
-func (my *DBase) FetchRows(sql string, args ...interface{}) ([]map[string]gItem.ItemElem, error) {
+func (my *DBase) FetchRows(fsql string, args ...interface{}) ([]map[string]gItem.ItemElem, error) {
+	return my.FetchRowsContext(context.Background(), fsql, args...)
+}
+
+//提取多行数据，可以用ctx取消
+func (my *DBase) FetchRowsContext(ctx context.Context, fsql string, args ...interface{}) (ret []map[string]gItem.ItemElem, err error) {
+	err = my.doFetch(ctx, fsql, args, func(rows *sql.Rows) (int64, error) {
+		ret, err = reFormatRowsData(rows, my.timeLocation(), my.Conf.BitAsBool)
+		return int64(len(ret)), err
+	})
+	return ret, err
+}
+
+//执行一条查询语句，用fn处理结果，fn返回读到的行数，rows在fn返回后关闭
+func (my *DBase) doFetch(ctx context.Context, sql string, args []interface{}, fn func(rows *sql.Rows) (int64, error)) (err error) {
 	if my.Db == nil {
-		return nil, fmt.Errorf("not connect MySQL")
+		return fmt.Errorf("not connect MySQL")
 	}
 	if my.IsDebug {
 		fmt.Printf("doFetch:\n")
 		fmt.Printf("\tSQL:%s\n", sql)
 		fmt.Println("args:\t", args)
 	}
-	stmt, err := my.Db.Prepare(sql)
+	var n int64
+	ctx, e := my.beforeQuery(ctx, OpQuery, sql, args)
+	defer func() { my.afterQuery(ctx, e, n, err) }()
+	stmt, release, err := my.prepare(ctx, sql)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer stmt.Close()
-	rows, err := stmt.Query(args...)
+	defer release()
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return reFormatRowsData(rows)
+	defer rows.Close()
+	n, err = fn(rows)
+	return err
 }
 
 //提取多行数据
@@ -186,18 +190,37 @@ func (my *DBase) FetchRows(sql string, args ...interface{}) ([]map[string]gItem.
 //cond为查询条件，全为and
 //fields为要查询的字段，为空时表示查询全部
 func (my *DBase) FetchCondRows(table string, cond map[string]interface{}, fields ...string) (ret []map[string]gItem.ItemElem, err error) {
-	f := filterTableFields(fields...)
-	cd, param := FormatCond(cond, "AND")
-	fsql := fmt.Sprintf("SELECT %s FROM `%s`", f, table)
-	if len(cd) > 0 {
-		fsql = fmt.Sprintf("%s WHERE %s", fsql, cd)
+	return my.FetchCondRowsContext(context.Background(), table, cond, fields...)
+}
+
+//提取多行数据，可以用ctx取消
+func (my *DBase) FetchCondRowsContext(ctx context.Context, table string, cond map[string]interface{}, fields ...string) (ret []map[string]gItem.ItemElem, err error) {
+	b := Select(fields...).From(table).Where(MapConds(cond)...)
+	return my.FetchRowsBuilderContext(ctx, b)
+}
+
+//执行SQLBuilder生成的查询语句
+func (my *DBase) FetchRowsBuilder(b *SQLBuilder) ([]map[string]gItem.ItemElem, error) {
+	return my.FetchRowsBuilderContext(context.Background(), b)
+}
+
+//执行SQLBuilder生成的查询语句，可以用ctx取消
+func (my *DBase) FetchRowsBuilderContext(ctx context.Context, b *SQLBuilder) ([]map[string]gItem.ItemElem, error) {
+	fsql, args, err := b.Build()
+	if err != nil {
+		return nil, err
 	}
-	return my.FetchRows(fsql, param...)
+	return my.FetchRowsContext(ctx, fsql, args...)
 }
 
 //执行一条insert/update/delete语句，返回影响行数
 func (my *DBase) Execute(sql string, args ...interface{}) (int64, bool, error) {
-	ret, err := my.doExec(sql, args...)
+	return my.ExecuteContext(context.Background(), sql, args...)
+}
+
+//执行一条insert/update/delete语句，返回影响行数，可以用ctx取消
+func (my *DBase) ExecuteContext(ctx context.Context, sql string, args ...interface{}) (int64, bool, error) {
+	ret, err := my.doExec(ctx, sql, args...)
 	if err != nil {
 		return 0, false, err
 	}
@@ -208,28 +231,46 @@ func (my *DBase) Execute(sql string, args ...interface{}) (int64, bool, error) {
 	return rowsAffected, true, nil
 }
 
+//执行SQLBuilder生成的写语句，返回影响行数
+func (my *DBase) ExecuteBuilder(b *SQLBuilder) (int64, bool, error) {
+	return my.ExecuteBuilderContext(context.Background(), b)
+}
+
+//执行SQLBuilder生成的写语句，返回影响行数，可以用ctx取消
+func (my *DBase) ExecuteBuilderContext(ctx context.Context, b *SQLBuilder) (int64, bool, error) {
+	esql, args, err := b.Build()
+	if err != nil {
+		return 0, false, err
+	}
+	return my.ExecuteContext(ctx, esql, args...)
+}
+
 //删除一条数据，返回lastAffectedRows
 func (my *DBase) DeleteData(table string, cond map[string]interface{}) (int64, bool, error) {
-	cd, param := FormatCond(cond, "AND")
-	dsql := fmt.Sprintf("DELETE FROM `%s`", table)
-	if len(cd) > 0 {
-		dsql = fmt.Sprintf("%s WHERE %s", dsql, cd)
-	}
-	return my.Execute(dsql, param...)
+	return my.DeleteDataContext(context.Background(), table, cond)
+}
+
+//删除一条数据，返回lastAffectedRows，可以用ctx取消
+func (my *DBase) DeleteDataContext(ctx context.Context, table string, cond map[string]interface{}) (int64, bool, error) {
+	return my.ExecuteBuilderContext(ctx, Delete(table).Where(MapConds(cond)...))
 }
 
 //写入一条数据，返回lastInsertId
 func (my *DBase) InsertData(table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
-	ignore := "IGNORE"
-	if !isIgnore {
-		ignore = ""
+	return my.InsertDataContext(context.Background(), table, data, isIgnore)
+}
+
+//写入一条数据，返回lastInsertId，可以用ctx取消
+func (my *DBase) InsertDataContext(ctx context.Context, table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
+	b := Insert(table).SetMap(data)
+	if isIgnore {
+		b.Ignore()
 	}
-	cd, param := FormatCond(data, ",")
-	if len(cd) == 0 {
+	isql, param, err := b.Build()
+	if err != nil {
 		return 0, false, fmt.Errorf("invalid insert data")
 	}
-	isql := fmt.Sprintf("INSERT %s INTO `%s` SET %s", ignore, table, cd)
-	ret, err := my.doExec(isql, param...)
+	ret, err := my.doExec(ctx, isql, param...)
 	if err != nil {
 		return 0, false, err
 	}
@@ -240,8 +281,13 @@ func (my *DBase) InsertData(table string, data map[string]interface{}, isIgnore
 	return lastInsertId, true, nil
 }
 
-//批量写入数据，返回影响行数
+//批量写入数据，返回影响行数，所有数据在一条语句里，数据量大时用BatchWriter分批写入
 func (my *DBase) InsertBatchData(table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
+	return my.InsertBatchDataContext(context.Background(), table, fields, data, isIgnore)
+}
+
+//批量写入数据，返回影响行数，可以用ctx取消
+func (my *DBase) InsertBatchDataContext(ctx context.Context, table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
 	fieldsLen := len(fields)
 	if fieldsLen <= 0 {
 		return 0, false, fmt.Errorf("invalid fields")
@@ -251,79 +297,58 @@ func (my *DBase) InsertBatchData(table string, fields []string, data [][]interfa
 			return 0, false, fmt.Errorf("invalid data,count(fields) != count(data)")
 		}
 	}
-
-	ignore := "IGNORE"
-	if !isIgnore {
-		ignore = ""
-	}
-	var args []interface{}
-	isql := fmt.Sprintf("INSERT %s INTO `%s` (`%s`) VALUES ", ignore, table, strings.Join(fields, "`,`"))
-	var tmp []string
-	var tmpArg []string
-	for i := 0; i < len(fields); i++ {
-		tmp = append(tmp, "?")
-	}
-	tmpStr := fmt.Sprintf("(%s)", strings.Join(tmp, ","))
-	for i := 0; i < len(data); i++ {
-		tmpArg = append(tmpArg, tmpStr)
-	}
-	isql = fmt.Sprintf("%s %s", isql, strings.Join(tmpArg, ","))
-	for _, dinfo := range data {
-		for _, d := range dinfo {
-			args = append(args, d)
-		}
-	}
-	ret, err := my.doExec(isql, args...)
-	if err != nil {
-		return 0, false, err
+	b := Insert(table).Columns(fields...)
+	if isIgnore {
+		b.Ignore()
 	}
-	rowsAffected, err := ret.RowsAffected()
-	if err != nil {
-		return 0, false, err
+	for _, d := range data {
+		b.Values(d...)
 	}
-	return rowsAffected, true, nil
+	return my.ExecuteBuilderContext(ctx, b)
 }
 
 //执行一条：INSERT INTO table (a,b,c) VALUES (1,2,3) ON DUPLICATE KEY UPDATE c=c+1 语句
 func (my *DBase) InsertUpdateData(table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error) {
-	icd, iparam := FormatCond(insert, ",")
-	ucd, uparam := FormatCond(update, ",")
-	if len(icd) == 0 || len(ucd) == 0 {
+	return my.InsertUpdateDataContext(context.Background(), table, insert, update)
+}
+
+//执行一条INSERT ... ON DUPLICATE KEY UPDATE语句，可以用ctx取消
+func (my *DBase) InsertUpdateDataContext(ctx context.Context, table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error) {
+	if len(insert) == 0 || len(update) == 0 {
 		return 0, false, fmt.Errorf("invalid insert/update data")
 	}
-	iparam = append(iparam, uparam...)
-	iusql := fmt.Sprintf("INSERT INTO `%s` SET %s ON DUPLICATE KEY UPDATE %s", table, icd, ucd)
-	return my.Execute(iusql, iparam...)
+	return my.ExecuteBuilderContext(ctx, Insert(table).SetMap(insert).OnDuplicateMap(update))
 }
 
 //更新一条数据，返回lastAffectedRows
 func (my *DBase) UpdateData(table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
-	dcd, dparam := FormatCond(data, ",")
-	ccd, cparam := FormatCond(cond, "AND")
-	if len(dcd) == 0 {
+	return my.UpdateDataContext(context.Background(), table, data, cond)
+}
+
+//更新一条数据，返回lastAffectedRows，可以用ctx取消
+func (my *DBase) UpdateDataContext(ctx context.Context, table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
+	if len(data) == 0 {
 		return 0, false, fmt.Errorf("invalid update data")
 	}
-	usql := fmt.Sprintf("UPDATE `%s` SET %s", table, dcd)
-	if len(ccd) > 0 {
-		usql = fmt.Sprintf("%s WHERE %s", usql, ccd)
-		dparam = append(dparam, cparam...)
-	}
-	return my.Execute(usql, dparam...)
+	return my.ExecuteBuilderContext(ctx, Update(table).SetMap(data).Where(MapConds(cond)...))
 }
 
 //执行一条select ... for update语句
 func (my *DBase) FetchForUpdate(table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error) {
-	cd, param := FormatCond(cond, "AND")
-	fusql := fmt.Sprintf("SELECT * FROM `%s`", table)
-	if len(cd) > 0 {
-		fusql = fmt.Sprintf("%s WHERE %s", fusql, cd)
+	return my.FetchForUpdateContext(context.Background(), table, cond)
+}
+
+//执行一条select ... for update语句，可以用ctx取消
+func (my *DBase) FetchForUpdateContext(ctx context.Context, table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error) {
+	fusql, param, err := Select().From(table).Where(MapConds(cond)...).ForUpdate().Build()
+	if err != nil {
+		return nil, err
 	}
-	fusql = fmt.Sprintf("%s FOR UPDATE", fusql)
-	return my.FetchRow(fusql, param...)
+	return my.FetchRowContext(ctx, fusql, param...)
 }
 
 //执行一条写语句
-func (my *DBase) doExec(sql string, args ...interface{}) (sql.Result, error) {
+func (my *DBase) doExec(ctx context.Context, sql string, args ...interface{}) (ret sql.Result, err error) {
 	if my.Db == nil {
 		return nil, fmt.Errorf("not connect MySQL")
 	}
@@ -332,18 +357,64 @@ func (my *DBase) doExec(sql string, args ...interface{}) (sql.Result, error) {
 		fmt.Printf("\tSQL:%s\n", sql)
 		fmt.Println("args:\t", args)
 	}
-	stmt, err := my.Db.Prepare(sql)
+	ctx, e := my.beforeQuery(ctx, OpExec, sql, args)
+	defer func() { my.afterQuery(ctx, e, rowsAffected(ret), err) }()
+	stmt, release, err := my.prepare(ctx, sql)
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
-	ret, err := stmt.Exec(args...)
+	defer release()
+	ret, err = stmt.ExecContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
 	return ret, nil
 }
 
+//影响行数，ret为nil或出错时为0
+func rowsAffected(ret sql.Result) int64 {
+	if ret == nil {
+		return 0
+	}
+	n, _ := ret.RowsAffected()
+	return n
+}
+
+//提取预编译的语句，开了缓存的从缓存里取，用完后要调用返回的release
+//在事务里时，转为事务的连接上的语句
+func (my *DBase) prepare(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	if my.tx == nil {
+		if my.stmts != nil {
+			return my.stmts.get(ctx, query)
+		}
+		return prepareOnce(ctx, my.Db, query)
+	}
+	if my.stmts == nil {
+		stmt, err := my.tx.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stmt, func() { stmt.Close() }, nil
+	}
+	stmt, release, err := my.stmts.get(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	txStmt := my.tx.StmtContext(ctx, stmt)
+	return txStmt, func() {
+		txStmt.Close()
+		release()
+	}, nil
+}
+
+//预编译语句缓存的统计信息
+func (my *DBase) StmtCacheStats() StmtCacheStats {
+	if my.stmts == nil {
+		return StmtCacheStats{}
+	}
+	return my.stmts.stats()
+}
+
 //关闭连接
 func (my *DBase) Close() error {
 	if my.IsDebug {
@@ -352,6 +423,9 @@ func (my *DBase) Close() error {
 	if my.Db == nil {
 		return fmt.Errorf("not connect mysql")
 	}
+	if my.stmts != nil {
+		my.stmts.clear()
+	}
 	return my.Db.Close()
 }
 
@@ -367,18 +441,28 @@ func (my *DBase) SetDebug(d bool) {
 
 //Ping
 func (my *DBase) Ping() error {
+	return my.PingContext(context.Background())
+}
+
+//Ping，可以用ctx设置超时
+func (my *DBase) PingContext(ctx context.Context) error {
 	if my.Db == nil {
 		return fmt.Errorf("Not Connect MySQL....")
 	}
-	return my.Db.Ping()
+	return my.Db.PingContext(ctx)
 }
 
 //开启事务
 func (my *DBase) BeginTransaction() (*sql.Tx, error) {
+	return my.BeginTransactionContext(context.Background(), nil)
+}
+
+//开启事务，ctx取消时事务会自动回滚，opts可以设置隔离级别、只读
+func (my *DBase) BeginTransactionContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
 	if my.IsDebug {
 		fmt.Printf("\nstart BeginTransaction....\n")
 	}
-	return my.Db.Begin()
+	return my.Db.BeginTx(ctx, opts)
 }
 
 //提交事务
@@ -0,0 +1,380 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//批量写入的方式
+const (
+	BatchInsert       = iota //INSERT
+	BatchInsertIgnore        //INSERT IGNORE
+	BatchUpsert              //INSERT ... ON DUPLICATE KEY UPDATE
+	BatchReplace             //REPLACE
+)
+
+const (
+	maxPlaceholders     = 65535   //一条语句最多的参数个数
+	defaultChunkRows    = 1000    //默认每批的行数
+	defaultChunkBytes   = 4 << 20 //默认每批的字节数
+	chunkOverheadPerVal = 4       //估算大小时每个值额外加的字节数
+)
+
+var (
+	loadReaderID int64
+	errLoadDone  = errors.New("load data finished")
+)
+
+//批量写入，按行数、字节数把数据切成多批，每批一条语句
+//
+//	n, err := NewBatchWriter(db, "user_stat", "uid", "day", "pv").
+//		OnDuplicate("pv", Expr("`pv` + VALUES(`pv`)")).
+//		InTx().
+//		Write(ctx, rows)
+type BatchWriter struct {
+	db         *DBase
+	table      string
+	columns    []string
+	mode       int
+	onDup      []sqlSet
+	chunkRows  int
+	chunkBytes int
+	inTx       bool
+}
+
+func NewBatchWriter(db *DBase, table string, columns ...string) *BatchWriter {
+	return &BatchWriter{
+		db:         db,
+		table:      table,
+		columns:    columns,
+		mode:       BatchInsert,
+		chunkRows:  defaultChunkRows,
+		chunkBytes: defaultChunkBytes,
+	}
+}
+
+//INSERT IGNORE
+func (w *BatchWriter) Ignore() *BatchWriter {
+	w.mode = BatchInsertIgnore
+	return w
+}
+
+//REPLACE
+func (w *BatchWriter) Replace() *BatchWriter {
+	w.mode = BatchReplace
+	return w
+}
+
+//INSERT ... ON DUPLICATE KEY UPDATE，val可以是Expr，如Expr("`pv` + VALUES(`pv`)")
+func (w *BatchWriter) OnDuplicate(field string, val interface{}) *BatchWriter {
+	w.mode = BatchUpsert
+	w.onDup = append(w.onDup, sqlSet{field: field, val: val})
+	return w
+}
+
+//冲突时用新的值覆盖这些字段，即`field` = VALUES(`field`)
+func (w *BatchWriter) OnDuplicateValues(fields ...string) *BatchWriter {
+	for _, f := range fields {
+		w.OnDuplicate(f, Expr("VALUES("+quoteIdent(f)+")"))
+	}
+	return w
+}
+
+//每批最多的行数，默认1000，还会受65535个参数的限制
+func (w *BatchWriter) ChunkRows(n int) *BatchWriter {
+	w.chunkRows = n
+	return w
+}
+
+//每批估算的最大字节数，默认4M，要小于max_allowed_packet
+func (w *BatchWriter) ChunkBytes(n int) *BatchWriter {
+	w.chunkBytes = n
+	return w
+}
+
+//所有批次在一个事务里执行，有一批失败时全部回滚
+//已经在事务里时直接用当前的事务
+func (w *BatchWriter) InTx() *BatchWriter {
+	w.inTx = true
+	return w
+}
+
+//分批写入，返回总的影响行数
+//不在事务里时，某一批失败后返回之前成功的影响行数及错误
+func (w *BatchWriter) Write(ctx context.Context, rows [][]interface{}) (int64, error) {
+	if len(w.columns) <= 0 {
+		return 0, fmt.Errorf("invalid fields")
+	}
+	if w.mode == BatchUpsert && len(w.onDup) <= 0 {
+		return 0, fmt.Errorf("empty on duplicate update data")
+	}
+	for i, row := range rows {
+		if len(row) != len(w.columns) {
+			return 0, fmt.Errorf("invalid data: row %d has %d values, want %d", i, len(row), len(w.columns))
+		}
+	}
+	if len(rows) <= 0 {
+		return 0, nil
+	}
+	if !w.inTx || w.db.tx != nil {
+		return w.write(ctx, w.db, rows)
+	}
+	var total int64
+	err := w.db.WithTx(ctx, func(tx *TxBase) error {
+		var err error
+		total, err = w.write(ctx, tx.DBase, rows)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+//按批执行
+func (w *BatchWriter) write(ctx context.Context, db *DBase, rows [][]interface{}) (int64, error) {
+	var total int64
+	for _, c := range w.chunks(rows) {
+		b := w.builder()
+		for _, row := range rows[c[0]:c[1]] {
+			b.Values(row...)
+		}
+		n, _, err := db.ExecuteBuilderContext(ctx, b)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("batch rows [%d,%d) failed: %w", c[0], c[1], err)
+		}
+	}
+	return total, nil
+}
+
+//每批的语句
+func (w *BatchWriter) builder() *SQLBuilder {
+	var b *SQLBuilder
+	if w.mode == BatchReplace {
+		b = Replace(w.table)
+	} else {
+		b = Insert(w.table)
+	}
+	b.Columns(w.columns...)
+	switch w.mode {
+	case BatchInsertIgnore:
+		b.Ignore()
+	case BatchUpsert:
+		b.onDup = w.onDup
+	}
+	return b
+}
+
+//按行数、字节数、参数个数切分，返回每批的[开始,结束)
+//一行就超过字节数限制时单独一批
+func (w *BatchWriter) chunks(rows [][]interface{}) [][2]int {
+	maxRows := w.chunkRows
+	if maxRows <= 0 {
+		maxRows = defaultChunkRows
+	}
+	//ON DUPLICATE KEY UPDATE里的参数每批都有
+	fixed := 0
+	for _, s := range w.onDup {
+		fixed += valuePlaceholders(s.val)
+	}
+	var ret [][2]int
+	start, size, ph := 0, 0, 0
+	for i, row := range rows {
+		rs, rp := 0, 0
+		for _, v := range row {
+			rs += valueSize(v) + chunkOverheadPerVal
+			rp += valuePlaceholders(v)
+		}
+		if i > start && (i-start >= maxRows || (w.chunkBytes > 0 && size+rs > w.chunkBytes) || fixed+ph+rp > maxPlaceholders) {
+			ret = append(ret, [2]int{start, i})
+			start, size, ph = i, 0, 0
+		}
+		size += rs
+		ph += rp
+	}
+	return append(ret, [2]int{start, len(rows)})
+}
+
+//一个值在语句里占的参数个数，同valueSQL
+func valuePlaceholders(val interface{}) int {
+	if v, ok := val.(sqlExpr); ok && v.raw {
+		return len(v.args)
+	}
+	return 1
+}
+
+//估算一个值发给MySQL时的字节数
+func valueSize(v interface{}) int {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(t)
+	case []byte:
+		return len(t)
+	case time.Time:
+		return 12
+	case sqlExpr:
+		n := len(t.sql)
+		for _, a := range t.args {
+			n += valueSize(a)
+		}
+		return n
+	default:
+		return 8
+	}
+}
+
+//用LOAD DATA LOCAL INFILE导入，比INSERT快很多，适合几百万行以上的数据
+//需要服务端开启local_infile；Replace、Ignore有效，OnDuplicate无效
+func (w *BatchWriter) Load(ctx context.Context, rows [][]interface{}) (int64, error) {
+	return w.LoadFunc(ctx, func(put func(vals ...interface{}) error) error {
+		for _, row := range rows {
+			if err := put(row...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//同Load，数据由fn边生成边调用put写入，不用全部放在内存里
+func (w *BatchWriter) LoadFunc(ctx context.Context, fn func(put func(vals ...interface{}) error) error) (int64, error) {
+	if len(w.columns) <= 0 {
+		return 0, fmt.Errorf("invalid fields")
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	loc := w.db.timeLocation()
+	go func() {
+		bw := bufio.NewWriterSize(pw, 64<<10)
+		err := fn(func(vals ...interface{}) error {
+			if len(vals) != len(w.columns) {
+				return fmt.Errorf("invalid data: %d values, want %d", len(vals), len(w.columns))
+			}
+			return writeLoadRow(bw, vals, loc)
+		})
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	n, err := w.LoadReader(ctx, pr)
+	//驱动没读完时让fn退出
+	pr.CloseWithError(errLoadDone)
+	if ferr := <-done; ferr != nil && ferr != errLoadDone && err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+//同Load，r为LOAD DATA默认格式的数据：tab分隔字段、\n分隔行、\N为NULL、\转义
+func (w *BatchWriter) LoadReader(ctx context.Context, r io.Reader) (int64, error) {
+	name := "goutils_" + strconv.FormatInt(atomic.AddInt64(&loadReaderID, 1), 10)
+	gomysql.RegisterReaderHandler(name, func() io.Reader { return r })
+	defer gomysql.DeregisterReaderHandler(name)
+	s := w.loadSQL(name)
+	if w.db.IsDebug {
+		fmt.Printf("LoadData:\n\tSQL:%s\n", s)
+	}
+	if w.db.Db == nil {
+		return 0, fmt.Errorf("not connect MySQL")
+	}
+	//LOAD DATA不能预编译，直接执行
+	exec := w.db.Db.ExecContext
+	if w.db.tx != nil {
+		exec = w.db.tx.ExecContext
+	}
+	ctx, e := w.db.beforeQuery(ctx, OpLoad, s, nil)
+	res, err := exec(ctx, s)
+	w.db.afterQuery(ctx, e, rowsAffected(res), err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+//LOAD DATA语句
+func (w *BatchWriter) loadSQL(reader string) string {
+	mode := ""
+	switch w.mode {
+	case BatchReplace:
+		mode = " REPLACE"
+	case BatchInsertIgnore:
+		mode = " IGNORE"
+	}
+	return "LOAD DATA LOCAL INFILE 'Reader::" + reader + "'" + mode + " INTO TABLE " + quoteIdent(w.table) +
+		" CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (" +
+		joinQuoted(w.columns, quoteIdent) + ")"
+}
+
+var loadEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r", "\x00", "\\0")
+
+//写一行LOAD DATA的数据，时间转为loc时区，和INSERT时驱动的处理一致
+func writeLoadRow(bw *bufio.Writer, vals []interface{}, loc *time.Location) error {
+	for i, v := range vals {
+		if i > 0 {
+			bw.WriteByte('\t')
+		}
+		v, err := loadValue(v)
+		if err != nil {
+			return err
+		}
+		var s string
+		switch t := v.(type) {
+		case nil:
+			bw.WriteString("\\N")
+			continue
+		case string:
+			s = t
+		case []byte:
+			s = string(t)
+		case bool:
+			s = "0"
+			if t {
+				s = "1"
+			}
+		case time.Time:
+			s = t.In(loc).Format("2006-01-02 15:04:05.999999")
+		default:
+			s = fmt.Sprint(t)
+		}
+		if _, err := loadEscaper.WriteString(bw, s); err != nil {
+			return err
+		}
+	}
+	return bw.WriteByte('\n')
+}
+
+//同INSERT时驱动的处理：ItemElem取原始值，driver.Valuer调用Value()，指针取指向的值
+func loadValue(v interface{}) (interface{}, error) {
+	if ie, ok := v.(gItem.ItemElem); ok {
+		v = ie.RawData()
+	}
+	cv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		//驱动支持大于int64的uint64，DefaultParameterConverter不支持
+		if _, ok := v.(uint64); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("invalid load data value %T: %v", v, err)
+	}
+	return cv, nil
+}
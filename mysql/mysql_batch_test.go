@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter_Chunks(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	rows := make([][]interface{}, 5)
+	for i := range rows {
+		rows[i] = []interface{}{i, strings.Repeat("x", 10)}
+	}
+	w := NewBatchWriter(nil, "t", "a", "b").ChunkRows(2)
+	if got := w.chunks(rows); !reflect.DeepEqual(got, [][2]int{{0, 2}, {2, 4}, {4, 5}}) {
+		t.Errorf("chunk by rows: %v", got)
+	}
+	//每行(8+4)+(10+4)=26字节
+	w.ChunkRows(100).ChunkBytes(60)
+	if got := w.chunks(rows); !reflect.DeepEqual(got, [][2]int{{0, 2}, {2, 4}, {4, 5}}) {
+		t.Errorf("chunk by bytes: %v", got)
+	}
+	//一行就超过限制时单独一批
+	w.ChunkBytes(10)
+	if got := w.chunks(rows[:2]); !reflect.DeepEqual(got, [][2]int{{0, 1}, {1, 2}}) {
+		t.Errorf("chunk big rows: %v", got)
+	}
+	//不超过65535个参数
+	cols := make([]string, 1000)
+	big := make([][]interface{}, 200)
+	for i := range big {
+		big[i] = make([]interface{}, len(cols))
+	}
+	if got := NewBatchWriter(nil, "t", cols...).chunks(big); len(got) != 4 || got[0][1] != 65 {
+		t.Errorf("chunk by placeholders: %v", got)
+	}
+	//ON DUPLICATE KEY UPDATE里的参数也要算上，65*1000+536>65535
+	w = NewBatchWriter(nil, "t", cols...)
+	for i := 0; i < 536; i++ {
+		w.OnDuplicate(cols[i], i)
+	}
+	if got := w.chunks(big); got[0][1] != 64 {
+		t.Errorf("chunk by placeholders with on duplicate: %v", got)
+	}
+	//Expr的参数
+	for i := range big {
+		for j := range big[i] {
+			big[i][j] = Expr("? + ?", 1, 2)
+		}
+	}
+	if got := NewBatchWriter(nil, "t", cols...).chunks(big); got[0][1] != 32 {
+		t.Errorf("chunk by expr placeholders: %v", got)
+	}
+}
+
+func TestBatchWriter_Write(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+	rows := [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}}
+
+	n, err := NewBatchWriter(db, "t", "id", "pv").ChunkRows(2).
+		OnDuplicate("pv", Expr("`pv` + VALUES(`pv`)")).OnDuplicateValues("id").
+		Write(ctx, rows)
+	want := []string{
+		"INSERT INTO `t` (`id`,`pv`) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE `pv` = `pv` + VALUES(`pv`),`id` = VALUES(`id`)",
+		"INSERT INTO `t` (`id`,`pv`) VALUES (?,?) ON DUPLICATE KEY UPDATE `pv` = `pv` + VALUES(`pv`),`id` = VALUES(`id`)",
+	}
+	if got := srv.executed(); n != 2 || err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected %d %v %q", n, err, got)
+	}
+
+	srv.reset()
+	NewBatchWriter(db, "t", "id", "pv").Replace().Write(ctx, rows)
+	NewBatchWriter(db, "t", "id", "pv").Ignore().Write(ctx, rows)
+	want = []string{
+		"REPLACE INTO `t` (`id`,`pv`) VALUES (?,?),(?,?),(?,?)",
+		"INSERT IGNORE INTO `t` (`id`,`pv`) VALUES (?,?),(?,?),(?,?)",
+	}
+	if got := srv.executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected %q", got)
+	}
+
+	//第二批失败，不在事务里时返回第一批的影响行数
+	srv.reset()
+	bad := "INSERT INTO `t` (`id`,`pv`) VALUES (?,?)"
+	srv.set(bad, fakeResult{err: fmt.Errorf("packet too large")})
+	n, err = NewBatchWriter(db, "t", "id", "pv").ChunkRows(2).Write(ctx, rows)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "[2,3)") {
+		t.Errorf("unexpected %d %v", n, err)
+	}
+	//在事务里时全部回滚
+	srv.reset()
+	n, err = NewBatchWriter(db, "t", "id", "pv").ChunkRows(2).InTx().Write(ctx, rows)
+	if got := srv.executed(); n != 0 || err == nil || len(got) != 4 || got[0] != "BEGIN" || got[3] != "ROLLBACK" {
+		t.Errorf("unexpected %d %v %q", n, err, got)
+	}
+	srv.reset()
+	if n, err = NewBatchWriter(db, "t", "id", "pv").InTx().Write(ctx, rows); n != 1 || err != nil || srv.executed()[2] != "COMMIT" {
+		t.Errorf("unexpected %d %v %q", n, err, srv.executed())
+	}
+
+	if _, err = NewBatchWriter(db, "t", "id", "pv").Write(ctx, [][]interface{}{{1}}); err == nil {
+		t.Errorf("invalid row should fail")
+	}
+	if n, err = NewBatchWriter(db, "t", "id").Write(ctx, nil); n != 0 || err != nil {
+		t.Errorf("empty rows: %d %v", n, err)
+	}
+}
+
+func TestBatchWriter_Load(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	writeLoadRow(bw, []interface{}{1, "a\tb\nc\\", nil, true, []byte("x"), time.Date(2018, 1, 25, 11, 19, 0, 0, time.UTC)}, loc)
+	bw.Flush()
+	if want := "1\ta\\tb\\nc\\\\\t\\N\t1\tx\t2018-01-25 19:19:00\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	//和INSERT一样处理driver.Valuer、ItemElem、指针
+	buf.Reset()
+	name := "p"
+	writeLoadRow(bw, []interface{}{sql.NullString{String: "x", Valid: true}, sql.NullInt64{}, gItem.MakeItemElem(int8(3)), &name, (*string)(nil), uint64(1 << 63)}, loc)
+	bw.Flush()
+	if want := "x\t\\N\t3\tp\t\\N\t9223372036854775808\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	if err := writeLoadRow(bw, []interface{}{struct{}{}}, loc); err == nil {
+		t.Errorf("expected error for struct value")
+	}
+
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	rows := make([][]interface{}, 10000)
+	for i := range rows {
+		rows[i] = []interface{}{i, "name"}
+	}
+	//假驱动不读数据，也不能卡住
+	n, err := NewBatchWriter(db, "t", "id", "name").Replace().Load(context.Background(), rows)
+	if n != 1 || err != nil {
+		t.Errorf("unexpected %d %v", n, err)
+	}
+	q := srv.executed()
+	if len(q) != 1 || !strings.HasPrefix(q[0], "LOAD DATA LOCAL INFILE 'Reader::goutils_") ||
+		!strings.HasSuffix(q[0], "' REPLACE INTO TABLE `t` CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`id`,`name`)") {
+		t.Errorf("unexpected %q", q)
+	}
+	//fn的错误
+	if _, err = NewBatchWriter(db, "t", "id").LoadFunc(context.Background(), func(put func(vals ...interface{}) error) error {
+		return put(1, 2)
+	}); err == nil {
+		t.Errorf("expected error")
+	}
+}
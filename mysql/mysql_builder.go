@@ -0,0 +1,634 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/liuyongshuai/goutils/elem"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//查询条件，生成条件语句及对应的参数
+type Cond interface {
+	ToSQL() (string, []interface{})
+}
+
+//原始的SQL片段，可以当条件用，也可以当Set、Eq等的值用，如Expr("`num`+?", 1)
+type sqlExpr struct {
+	sql  string
+	args []interface{}
+	raw  bool //调用方写的片段，和其他条件放一起时要加括号
+}
+
+func (e sqlExpr) ToSQL() (string, []interface{}) {
+	return e.sql, e.args
+}
+
+//原始的SQL片段
+func Expr(sql string, args ...interface{}) Cond {
+	return sqlExpr{sql: sql, args: args, raw: true}
+}
+
+//多个条件用AND或OR连起来
+type condGroup struct {
+	op    string
+	conds []Cond
+}
+
+func (g condGroup) ToSQL() (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	multi := g.size() > 1
+	for _, c := range g.conds {
+		if c == nil {
+			continue
+		}
+		s, a := c.ToSQL()
+		if len(s) <= 0 {
+			continue
+		}
+		//嵌套的组、原始的片段要加括号
+		switch sub := c.(type) {
+		case condGroup:
+			if sub.op != g.op && sub.size() > 1 {
+				s = "(" + s + ")"
+			}
+		case sqlExpr:
+			if sub.raw && multi {
+				s = "(" + s + ")"
+			}
+		}
+		parts = append(parts, s)
+		args = append(args, a...)
+	}
+	return strings.Join(parts, " "+g.op+" "), args
+}
+
+//非空条件的个数
+func (g condGroup) size() int {
+	n := 0
+	for _, c := range g.conds {
+		if c == nil {
+			continue
+		}
+		if s, _ := c.ToSQL(); len(s) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+//条件都满足
+func And(conds ...Cond) Cond {
+	return condGroup{op: "AND", conds: conds}
+}
+
+//条件满足任一个
+func Or(conds ...Cond) Cond {
+	return condGroup{op: "OR", conds: conds}
+}
+
+//条件取反
+func Not(c Cond) Cond {
+	s, args := c.ToSQL()
+	if len(s) <= 0 {
+		return sqlExpr{}
+	}
+	return sqlExpr{sql: "NOT (" + s + ")", args: args}
+}
+
+//字段与值比较，值为Expr时直接拼进去
+func compare(field, op string, val interface{}) Cond {
+	s, args := valueSQL(val)
+	return sqlExpr{sql: quoteIdent(field) + " " + op + " " + s, args: args}
+}
+
+//等于
+func Eq(field string, val interface{}) Cond {
+	return compare(field, "=", val)
+}
+
+//不等于
+func Neq(field string, val interface{}) Cond {
+	return compare(field, "!=", val)
+}
+
+//大于
+func Gt(field string, val interface{}) Cond {
+	return compare(field, ">", val)
+}
+
+//大于等于
+func Gte(field string, val interface{}) Cond {
+	return compare(field, ">=", val)
+}
+
+//小于
+func Lt(field string, val interface{}) Cond {
+	return compare(field, "<", val)
+}
+
+//小于等于
+func Lte(field string, val interface{}) Cond {
+	return compare(field, "<=", val)
+}
+
+//LIKE，pattern里的"%"、"_"由调用方自己加
+func Like(field string, pattern string) Cond {
+	return compare(field, "LIKE", pattern)
+}
+
+//NOT LIKE
+func NotLike(field string, pattern string) Cond {
+	return compare(field, "NOT LIKE", pattern)
+}
+
+//IS NULL
+func IsNull(field string) Cond {
+	return sqlExpr{sql: quoteIdent(field) + " IS NULL"}
+}
+
+//IS NOT NULL
+func IsNotNull(field string) Cond {
+	return sqlExpr{sql: quoteIdent(field) + " IS NOT NULL"}
+}
+
+//BETWEEN a AND b
+func Between(field string, a, b interface{}) Cond {
+	return sqlExpr{sql: quoteIdent(field) + " BETWEEN ? AND ?", args: []interface{}{a, b}}
+}
+
+//FIND_IN_SET(val, field)
+func FindInSet(field string, val interface{}) Cond {
+	return sqlExpr{sql: "FIND_IN_SET(?," + quoteIdent(field) + ")", args: []interface{}{val}}
+}
+
+//IN，只传一个slice时展开它，列表为空时条件恒为假
+func In(field string, vals ...interface{}) Cond {
+	vals = flattenArgs(vals)
+	if len(vals) <= 0 {
+		return sqlExpr{sql: "1=0"}
+	}
+	return sqlExpr{sql: quoteIdent(field) + " IN (" + placeholders(len(vals)) + ")", args: vals}
+}
+
+//NOT IN，列表为空时条件恒为真
+func NotIn(field string, vals ...interface{}) Cond {
+	vals = flattenArgs(vals)
+	if len(vals) <= 0 {
+		return sqlExpr{sql: "1=1"}
+	}
+	return sqlExpr{sql: quoteIdent(field) + " NOT IN (" + placeholders(len(vals)) + ")", args: vals}
+}
+
+//只有一个slice参数时展开，[]byte除外
+func flattenArgs(vals []interface{}) []interface{} {
+	if len(vals) != 1 || vals[0] == nil {
+		return vals
+	}
+	if _, ok := vals[0].([]byte); ok {
+		return vals
+	}
+	rv := reflect.ValueOf(vals[0])
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return vals
+	}
+	ret := make([]interface{}, rv.Len())
+	for i := range ret {
+		ret[i] = rv.Index(i).Interface()
+	}
+	return ret
+}
+
+//n个用","连起来的问号
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+//值对应的SQL片段，Expr直接拼进去，其余的用问号占位，ItemElem取原始数据
+func valueSQL(val interface{}) (string, []interface{}) {
+	switch v := val.(type) {
+	case sqlExpr:
+		if v.raw {
+			return v.sql, v.args
+		}
+	case elem.ItemElem:
+		return "?", []interface{}{v.RawData()}
+	}
+	return "?", []interface{}{val}
+}
+
+//给字段名、表名加上反引号，如"t.name"变为"`t`.`name`"，"users u"变为"`users` `u`"，"users AS u"变为"`users` AS `u`"
+//名字里的反引号转义为两个，任何名字都不会被当成SQL执行，表达式请用Expr
+func quoteIdent(name string) string {
+	parts := strings.Fields(name)
+	switch {
+	case len(parts) == 2:
+		return quoteName(parts[0]) + " " + quoteName(parts[1])
+	case len(parts) == 3 && strings.EqualFold(parts[1], "AS"):
+		return quoteName(parts[0]) + " AS " + quoteName(parts[2])
+	}
+	return quoteName(strings.TrimSpace(name))
+}
+
+//按"."分开后分别加反引号，最后的"*"除外，如"*"、"t.*"
+func quoteName(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		if p == "*" && i == len(parts)-1 {
+			continue
+		}
+		parts[i] = "`" + strings.Replace(p, "`", "``", -1) + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+//排序字段，如"id DESC"变为"`id` DESC"
+func quoteOrder(s string) string {
+	parts := strings.Fields(s)
+	if len(parts) == 2 {
+		dir := strings.ToUpper(parts[1])
+		if dir == "ASC" || dir == "DESC" {
+			return quoteName(parts[0]) + " " + dir
+		}
+	}
+	return quoteIdent(s)
+}
+
+const (
+	sqlSelect = iota
+	sqlInsert
+	sqlUpdate
+	sqlDelete
+	sqlReplace
+)
+
+//要设置的字段及值
+type sqlSet struct {
+	field string
+	val   interface{}
+}
+
+//连表
+type sqlJoin struct {
+	kind  string
+	table string
+	on    Cond
+}
+
+//SQL构造器，生成的SQL及参数的顺序只跟调用的顺序有关
+//
+//	sql, args, err := Select("id", "name").From("users").
+//		Where(Eq("status", 1), Or(Gt("age", 18), IsNull("age"))).
+//		OrderBy("id DESC").Limit(10).Build()
+type SQLBuilder struct {
+	kind      int
+	table     string
+	fields    []Cond
+	joins     []sqlJoin
+	where     []Cond
+	groupBy   []string
+	having    []Cond
+	orderBy   []string
+	limit     int
+	offset    int
+	sets      []sqlSet
+	columns   []string
+	rows      [][]interface{}
+	onDup     []sqlSet
+	ignore    bool
+	forUpdate bool
+}
+
+func newSQLBuilder(kind int, table string) *SQLBuilder {
+	return &SQLBuilder{kind: kind, table: table, limit: -1}
+}
+
+//SELECT语句，不指定字段时为"*"，字段名都会加反引号，表达式用SelectExpr
+func Select(fields ...string) *SQLBuilder {
+	b := newSQLBuilder(sqlSelect, "")
+	for _, f := range fields {
+		b.fields = append(b.fields, sqlExpr{sql: quoteIdent(f)})
+	}
+	return b
+}
+
+//追加要查询的表达式，如SelectExpr(Expr("COUNT(*) AS cnt"))
+func (b *SQLBuilder) SelectExpr(exprs ...Cond) *SQLBuilder {
+	b.fields = append(b.fields, exprs...)
+	return b
+}
+
+//INSERT语句
+func Insert(table string) *SQLBuilder {
+	return newSQLBuilder(sqlInsert, table)
+}
+
+//REPLACE语句，用法同Insert，不支持Ignore、OnDuplicate
+func Replace(table string) *SQLBuilder {
+	return newSQLBuilder(sqlReplace, table)
+}
+
+//UPDATE语句
+func Update(table string) *SQLBuilder {
+	return newSQLBuilder(sqlUpdate, table)
+}
+
+//DELETE语句
+func Delete(table string) *SQLBuilder {
+	return newSQLBuilder(sqlDelete, table)
+}
+
+//设置SELECT的表名
+func (b *SQLBuilder) From(table string) *SQLBuilder {
+	b.table = table
+	return b
+}
+
+//INNER JOIN，on里可以用问号
+func (b *SQLBuilder) Join(table string, on string, args ...interface{}) *SQLBuilder {
+	b.joins = append(b.joins, sqlJoin{kind: "INNER JOIN", table: table, on: Expr(on, args...)})
+	return b
+}
+
+//LEFT JOIN
+func (b *SQLBuilder) LeftJoin(table string, on string, args ...interface{}) *SQLBuilder {
+	b.joins = append(b.joins, sqlJoin{kind: "LEFT JOIN", table: table, on: Expr(on, args...)})
+	return b
+}
+
+//RIGHT JOIN
+func (b *SQLBuilder) RightJoin(table string, on string, args ...interface{}) *SQLBuilder {
+	b.joins = append(b.joins, sqlJoin{kind: "RIGHT JOIN", table: table, on: Expr(on, args...)})
+	return b
+}
+
+//添加WHERE条件，多次调用的条件之间为AND
+func (b *SQLBuilder) Where(conds ...Cond) *SQLBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+//GROUP BY
+func (b *SQLBuilder) GroupBy(fields ...string) *SQLBuilder {
+	b.groupBy = append(b.groupBy, fields...)
+	return b
+}
+
+//添加HAVING条件，多次调用的条件之间为AND
+func (b *SQLBuilder) Having(conds ...Cond) *SQLBuilder {
+	b.having = append(b.having, conds...)
+	return b
+}
+
+//ORDER BY，如OrderBy("id DESC", "name")
+func (b *SQLBuilder) OrderBy(fields ...string) *SQLBuilder {
+	b.orderBy = append(b.orderBy, fields...)
+	return b
+}
+
+//LIMIT，小于0表示不限制
+func (b *SQLBuilder) Limit(n int) *SQLBuilder {
+	b.limit = n
+	return b
+}
+
+//OFFSET
+func (b *SQLBuilder) Offset(n int) *SQLBuilder {
+	b.offset = n
+	return b
+}
+
+//SELECT ... FOR UPDATE
+func (b *SQLBuilder) ForUpdate() *SQLBuilder {
+	b.forUpdate = true
+	return b
+}
+
+//INSERT IGNORE
+func (b *SQLBuilder) Ignore() *SQLBuilder {
+	b.ignore = true
+	return b
+}
+
+//设置字段的值，用于INSERT、UPDATE，值可以是Expr
+func (b *SQLBuilder) Set(field string, val interface{}) *SQLBuilder {
+	b.sets = append(b.sets, sqlSet{field: field, val: val})
+	return b
+}
+
+//按字段名排序后依次Set
+func (b *SQLBuilder) SetMap(data map[string]interface{}) *SQLBuilder {
+	for _, k := range sortedKeys(data) {
+		b.Set(k, data[k])
+	}
+	return b
+}
+
+//批量INSERT的字段名
+func (b *SQLBuilder) Columns(fields ...string) *SQLBuilder {
+	b.columns = fields
+	return b
+}
+
+//批量INSERT的一行数据，与Columns的顺序一致
+func (b *SQLBuilder) Values(vals ...interface{}) *SQLBuilder {
+	b.rows = append(b.rows, vals)
+	return b
+}
+
+//INSERT ... ON DUPLICATE KEY UPDATE，值可以是Expr，如Expr("`num`+1")
+func (b *SQLBuilder) OnDuplicate(field string, val interface{}) *SQLBuilder {
+	b.onDup = append(b.onDup, sqlSet{field: field, val: val})
+	return b
+}
+
+//按字段名排序后依次OnDuplicate
+func (b *SQLBuilder) OnDuplicateMap(data map[string]interface{}) *SQLBuilder {
+	for _, k := range sortedKeys(data) {
+		b.OnDuplicate(k, data[k])
+	}
+	return b
+}
+
+//生成SQL及参数
+func (b *SQLBuilder) Build() (string, []interface{}, error) {
+	if len(b.table) <= 0 {
+		return "", nil, fmt.Errorf("empty table name")
+	}
+	var buf bytes.Buffer
+	var args []interface{}
+	var err error
+	switch b.kind {
+	case sqlSelect:
+		fields := "*"
+		if len(b.fields) > 0 {
+			tmp := make([]string, len(b.fields))
+			for i, f := range b.fields {
+				fs, a := f.ToSQL()
+				tmp[i] = fs
+				args = append(args, a...)
+			}
+			fields = strings.Join(tmp, ",")
+		}
+		buf.WriteString("SELECT " + fields + " FROM " + quoteIdent(b.table))
+		for _, j := range b.joins {
+			s, a := j.on.ToSQL()
+			buf.WriteString(" " + j.kind + " " + quoteIdent(j.table) + " ON " + s)
+			args = append(args, a...)
+		}
+		args = b.writeConds(&buf, " WHERE ", b.where, args)
+		if len(b.groupBy) > 0 {
+			buf.WriteString(" GROUP BY " + joinQuoted(b.groupBy, quoteIdent))
+		}
+		args = b.writeConds(&buf, " HAVING ", b.having, args)
+		b.writeOrderLimit(&buf, true)
+		if b.forUpdate {
+			buf.WriteString(" FOR UPDATE")
+		}
+	case sqlInsert, sqlReplace:
+		args, err = b.buildInsert(&buf)
+	case sqlUpdate:
+		if len(b.sets) <= 0 {
+			return "", nil, fmt.Errorf("empty update data")
+		}
+		buf.WriteString("UPDATE " + quoteIdent(b.table) + " SET ")
+		args = writeSets(&buf, b.sets, args)
+		args = b.writeConds(&buf, " WHERE ", b.where, args)
+		b.writeOrderLimit(&buf, false)
+	case sqlDelete:
+		buf.WriteString("DELETE FROM " + quoteIdent(b.table))
+		args = b.writeConds(&buf, " WHERE ", b.where, args)
+		b.writeOrderLimit(&buf, false)
+	default:
+		err = fmt.Errorf("unknown sql type %d", b.kind)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return buf.String(), args, nil
+}
+
+//生成INSERT、REPLACE语句，Set的和Columns/Values的不能混用
+func (b *SQLBuilder) buildInsert(buf *bytes.Buffer) ([]interface{}, error) {
+	columns, rows := b.columns, b.rows
+	if len(b.sets) > 0 {
+		if len(rows) > 0 {
+			return nil, fmt.Errorf("can not use both Set and Values in insert")
+		}
+		columns = make([]string, len(b.sets))
+		row := make([]interface{}, len(b.sets))
+		for i, s := range b.sets {
+			columns[i], row[i] = s.field, s.val
+		}
+		rows = [][]interface{}{row}
+	}
+	if len(columns) <= 0 || len(rows) <= 0 {
+		return nil, fmt.Errorf("empty insert data")
+	}
+	if b.kind == sqlReplace {
+		if b.ignore || len(b.onDup) > 0 {
+			return nil, fmt.Errorf("can not use Ignore or OnDuplicate in replace")
+		}
+		buf.WriteString("REPLACE ")
+	} else {
+		buf.WriteString("INSERT ")
+	}
+	if b.ignore {
+		buf.WriteString("IGNORE ")
+	}
+	buf.WriteString("INTO " + quoteIdent(b.table) + " (" + joinQuoted(columns, quoteIdent) + ") VALUES ")
+	var args []interface{}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("invalid insert data: row %d has %d values, want %d", i, len(row), len(columns))
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('(')
+		for j, v := range row {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			s, a := valueSQL(v)
+			buf.WriteString(s)
+			args = append(args, a...)
+		}
+		buf.WriteByte(')')
+	}
+	if len(b.onDup) > 0 {
+		buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+		args = writeSets(buf, b.onDup, args)
+	}
+	return args, nil
+}
+
+//写入用AND连起来的条件
+func (b *SQLBuilder) writeConds(buf *bytes.Buffer, prefix string, conds []Cond, args []interface{}) []interface{} {
+	s, a := And(conds...).ToSQL()
+	if len(s) > 0 {
+		buf.WriteString(prefix + s)
+		args = append(args, a...)
+	}
+	return args
+}
+
+//写入ORDER BY、LIMIT，UPDATE、DELETE不支持OFFSET
+func (b *SQLBuilder) writeOrderLimit(buf *bytes.Buffer, withOffset bool) {
+	if len(b.orderBy) > 0 {
+		buf.WriteString(" ORDER BY " + joinQuoted(b.orderBy, quoteOrder))
+	}
+	switch {
+	case b.limit >= 0:
+		buf.WriteString(" LIMIT " + strconv.Itoa(b.limit))
+	case withOffset && b.offset > 0:
+		//MySQL的OFFSET必须跟LIMIT一起用
+		buf.WriteString(" LIMIT 18446744073709551615")
+	}
+	if withOffset && b.offset > 0 {
+		buf.WriteString(" OFFSET " + strconv.Itoa(b.offset))
+	}
+}
+
+//写入"`a` = ?,`b` = ?"
+func writeSets(buf *bytes.Buffer, sets []sqlSet, args []interface{}) []interface{} {
+	for i, s := range sets {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		v, a := valueSQL(s.val)
+		buf.WriteString(quoteIdent(s.field) + " = " + v)
+		args = append(args, a...)
+	}
+	return args
+}
+
+//分别处理后用","连起来
+func joinQuoted(names []string, quote func(string) string) string {
+	tmp := make([]string, len(names))
+	for i, n := range names {
+		tmp[i] = quote(n)
+	}
+	return strings.Join(tmp, ",")
+}
+
+//排好序的key
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSQLBuilder(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	cases := []struct {
+		b    *SQLBuilder
+		sql  string
+		args []interface{}
+	}{
+		{
+			Select("u.id", "u.name").SelectExpr(Expr("COUNT(*) AS cnt")).From("users u").
+				LeftJoin("orders o", "o.uid = u.id AND o.status = ?", 1).
+				Where(Eq("u.status", 1), Or(Gte("u.age", 18), IsNull("u.age"), And(Like("u.name", "tom%"), Neq("u.id", 3)))).
+				Where(In("u.type", []int{1, 2})).
+				GroupBy("u.id").Having(Gt("cnt", 2)).
+				OrderBy("cnt DESC", "u.id").Limit(10).Offset(20),
+			"SELECT `u`.`id`,`u`.`name`,COUNT(*) AS cnt FROM `users` `u` LEFT JOIN `orders` `o` ON o.uid = u.id AND o.status = ?" +
+				" WHERE `u`.`status` = ? AND (`u`.`age` >= ? OR `u`.`age` IS NULL OR (`u`.`name` LIKE ? AND `u`.`id` != ?)) AND `u`.`type` IN (?,?)" +
+				" GROUP BY `u`.`id` HAVING `cnt` > ? ORDER BY `cnt` DESC,`u`.`id` LIMIT 10 OFFSET 20",
+			[]interface{}{1, 1, 18, "tom%", 3, 1, 2, 2},
+		},
+		{
+			Select().From("t").Where(Expr("a = ? OR b = ?", 1, 2), NotIn("c"), Not(Between("d", 1, 5)), FindInSet("tags", "x")).ForUpdate(),
+			"SELECT * FROM `t` WHERE (a = ? OR b = ?) AND 1=1 AND NOT (`d` BETWEEN ? AND ?) AND FIND_IN_SET(?,`tags`) FOR UPDATE",
+			[]interface{}{1, 2, 1, 5, "x"},
+		},
+		{
+			Select("id").From("t").Where(In("id"), Or()).Offset(5),
+			"SELECT `id` FROM `t` WHERE 1=0 LIMIT 18446744073709551615 OFFSET 5",
+			nil,
+		},
+		{
+			Insert("t").Ignore().SetMap(map[string]interface{}{"b": 2, "a": "x"}).
+				OnDuplicate("num", Expr("`num`+?", 1)).OnDuplicate("b", Expr("VALUES(`b`)")),
+			"INSERT IGNORE INTO `t` (`a`,`b`) VALUES (?,?) ON DUPLICATE KEY UPDATE `num` = `num`+?,`b` = VALUES(`b`)",
+			[]interface{}{"x", 2, 1},
+		},
+		{
+			Insert("t").Columns("a", "b").Values(1, 2).Values(3, Expr("NOW()")),
+			"INSERT INTO `t` (`a`,`b`) VALUES (?,?),(?,NOW())",
+			[]interface{}{1, 2, 3},
+		},
+		{
+			Replace("t").Columns("a", "b").Values(1, 2),
+			"REPLACE INTO `t` (`a`,`b`) VALUES (?,?)",
+			[]interface{}{1, 2},
+		},
+		{
+			Update("t").Set("a", 1).Set("n", Expr("`n`+1")).Where(Eq("id", 9)).OrderBy("id").Limit(1),
+			"UPDATE `t` SET `a` = ?,`n` = `n`+1 WHERE `id` = ? ORDER BY `id` LIMIT 1",
+			[]interface{}{1, 9},
+		},
+		{
+			Delete("db.t").Where(MapConds(map[string]interface{}{"id:in": "1,2", "name:rlike": "ab", "age:gte": 3})...),
+			"DELETE FROM `db`.`t` WHERE `age` >= ? AND `id` IN (?,?) AND `name` LIKE ?",
+			[]interface{}{3, "1", "2", "ab%"},
+		},
+	}
+	for i, c := range cases {
+		s, args, err := c.b.Build()
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		fmt.Println(s)
+		if s != c.sql {
+			t.Errorf("case %d:\n got %s\nwant %s", i, s, c.sql)
+		}
+		if !reflect.DeepEqual(args, c.args) {
+			t.Errorf("case %d: args %#v, want %#v", i, args, c.args)
+		}
+	}
+
+	for i, b := range []*SQLBuilder{
+		Select(),
+		Insert("t"),
+		Insert("t").Columns("a", "b").Values(1),
+		Insert("t").Set("a", 1).Columns("a").Values(1),
+		Update("t").Where(Eq("id", 1)),
+		Replace("t").Set("a", 1).OnDuplicate("a", 2),
+	} {
+		if _, _, err := b.Build(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestSQLBuilder_HostileNames(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	cases := []struct {
+		b   *SQLBuilder
+		sql string
+	}{
+		{
+			Delete("users").Where(MapConds(map[string]interface{}{"1=1 OR id": 5})...),
+			"DELETE FROM `users` WHERE `1=1 OR id` = ?",
+		},
+		{
+			Select().From("users; DROP TABLE x"),
+			"SELECT * FROM `users; DROP TABLE x`",
+		},
+		{
+			Select("a`b", "COUNT(*)", "t.*").From("t`; DROP TABLE x; --").OrderBy("RAND()", "id DESC"),
+			"SELECT `a``b`,`COUNT(*)`,`t`.* FROM `t``; DROP TABLE x; --` ORDER BY `RAND()`,`id` DESC",
+		},
+		{
+			Update("t").SetMap(map[string]interface{}{"a` = 1, `b": 2}).Where(MapConds(map[string]interface{}{"id:in": "1,2", "x`) OR (1:gt": 0})...),
+			"UPDATE `t` SET `a`` = 1, ``b` = ? WHERE `id` IN (?,?) AND `x``) OR (1` > ?",
+		},
+		{
+			Insert("t").SetMap(map[string]interface{}{"id) VALUES (1); --": 1}),
+			"INSERT INTO `t` (`id) VALUES (1); --`) VALUES (?)",
+		},
+	}
+	for i, c := range cases {
+		s, _, err := c.b.Build()
+		if err != nil || s != c.sql {
+			t.Errorf("case %d:\n got %s %v\nwant %s", i, s, err, c.sql)
+		}
+	}
+	if s, _ := FormatCond(map[string]interface{}{"id` = 1 OR `id": 2}, "and"); s != "`id`` = 1 OR ``id` = ?" {
+		t.Errorf("unexpected %s", s)
+	}
+}
@@ -0,0 +1,520 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//从库的负载均衡策略
+const (
+	BalanceRoundRobin = iota //轮询
+	BalanceLeastConn         //正在使用的连接数最少的
+)
+
+//一主多从的配置
+type ClusterConf struct {
+	Primary        MySQLConf     //主库
+	Replicas       []MySQLConf   //从库，为空时读写都走主库
+	Balance        int           //从库的负载均衡策略，默认轮询
+	HealthInterval time.Duration //健康检查的间隔，默认5秒
+	HealthTimeout  time.Duration //每次健康检查的超时时间，默认1秒
+	MaxReplicaLag  time.Duration //从库延迟超过这个值时摘掉，默认0不检查延迟
+	StickyDuration time.Duration //WithSession的上下文里写了之后多长时间内读主库，默认0表示一直读主库
+}
+
+//从库的状态
+type ReplicaStatus struct {
+	Addr    string        //地址
+	Healthy bool          //是否在轮询里
+	Lag     time.Duration //复制延迟
+	InUse   int           //正在使用的连接数
+	Err     string        //最近一次健康检查的错误
+}
+
+type ctxKey int
+
+const (
+	ctxKeyPrimary ctxKey = iota
+	ctxKeySession
+)
+
+//读写会话，记录最近一次写的时间
+type clusterSession struct {
+	lastWrite int64
+}
+
+//强制走主库，用于刚写完马上要读的场景
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyPrimary, true)
+}
+
+//开启一个读写会话，会话里写过之后的读都走主库，保证读到自己写的数据
+//一般在每个HTTP请求开始时调用
+func WithSession(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(ctxKeySession).(*clusterSession); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKeySession, &clusterSession{})
+}
+
+//从库
+type replica struct {
+	db      *DBase
+	healthy int32
+	lag     int64
+	mu      sync.Mutex
+	err     string
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+//一主多从的集群，写和事务走主库，读按负载均衡策略走健康的从库
+//没有健康的从库时读也走主库
+type DBCluster struct {
+	Conf      ClusterConf
+	primary   *DBase
+	replicas  []*replica
+	next      uint32
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func NewDBCluster(conf ClusterConf) *DBCluster {
+	if conf.HealthInterval <= 0 {
+		conf.HealthInterval = 5 * time.Second
+	}
+	if conf.HealthTimeout <= 0 {
+		conf.HealthTimeout = time.Second
+	}
+	return &DBCluster{Conf: conf, stopCh: make(chan struct{})}
+}
+
+//连接主库及所有从库，并开始定时健康检查
+func (c *DBCluster) Conn() (*DBCluster, error) {
+	primary, err := NewDBase(c.Conf.Primary).Conn()
+	if err != nil {
+		return nil, fmt.Errorf("connect primary failed: %v", err)
+	}
+	var replicas []*DBase
+	for i, rc := range c.Conf.Replicas {
+		r, err := NewDBase(rc).Conn()
+		if err != nil {
+			primary.Close()
+			for _, r := range replicas {
+				r.Close()
+			}
+			return nil, fmt.Errorf("connect replica %d failed: %v", i, err)
+		}
+		replicas = append(replicas, r)
+	}
+	c.init(primary, replicas)
+	return c, nil
+}
+
+//设置主从库，先检查一次健康状况后再开始定时检查
+func (c *DBCluster) init(primary *DBase, replicas []*DBase) {
+	c.primary = primary
+	c.replicas = nil
+	for _, db := range replicas {
+		c.replicas = append(c.replicas, &replica{db: db})
+	}
+	if len(c.replicas) <= 0 {
+		return
+	}
+	c.CheckHealth(context.Background())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.Conf.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			case <-ticker.C:
+				c.CheckHealth(context.Background())
+			}
+		}
+	}()
+}
+
+//检查所有从库，Ping不通或延迟太大的摘掉，恢复后再加回来
+func (c *DBCluster) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, c.Conf.HealthTimeout)
+			defer cancel()
+			lag, err := c.checkReplica(cctx, r.db)
+			healthy := int32(1)
+			if err != nil {
+				healthy = 0
+			}
+			atomic.StoreInt64(&r.lag, int64(lag))
+			atomic.StoreInt32(&r.healthy, healthy)
+			r.mu.Lock()
+			r.err = ""
+			if err != nil {
+				r.err = err.Error()
+			}
+			r.mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+}
+
+//检查单个从库，返回复制延迟
+func (c *DBCluster) checkReplica(ctx context.Context, db *DBase) (time.Duration, error) {
+	if err := db.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	if c.Conf.MaxReplicaLag <= 0 {
+		return 0, nil
+	}
+	lag, err := replicaLag(ctx, db)
+	if err != nil {
+		return lag, err
+	}
+	if lag > c.Conf.MaxReplicaLag {
+		return lag, fmt.Errorf("replica lag %v exceeds %v", lag, c.Conf.MaxReplicaLag)
+	}
+	return lag, nil
+}
+
+//从SHOW REPLICA STATUS里取复制延迟，老版本用SHOW SLAVE STATUS
+//不是从库时返回0，复制断了时返回错误
+func replicaLag(ctx context.Context, db *DBase) (time.Duration, error) {
+	rows, err := db.Db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		if rows, err = db.Db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	vals := make([]sql.RawBytes, len(cols))
+	scanArgs := make([]interface{}, len(vals))
+	for i := range vals {
+		scanArgs[i] = &vals[i]
+	}
+	if err = rows.Scan(scanArgs...); err != nil {
+		return 0, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+		if vals[i] == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		sec, err := strconv.ParseInt(string(vals[i]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", col, vals[i])
+		}
+		return time.Duration(sec) * time.Second, nil
+	}
+	return 0, nil
+}
+
+//所有从库的状态
+func (c *DBCluster) ReplicaStatus() []ReplicaStatus {
+	var ret []ReplicaStatus
+	for _, r := range c.replicas {
+		r.mu.Lock()
+		st := ReplicaStatus{
+			Addr:    r.db.Conf.Host + ":" + strconv.Itoa(int(r.db.Conf.Port)),
+			Healthy: r.isHealthy(),
+			Lag:     time.Duration(atomic.LoadInt64(&r.lag)),
+			Err:     r.err,
+		}
+		r.mu.Unlock()
+		if r.db.Conf.network() == "unix" {
+			st.Addr = r.db.Conf.Socket
+		}
+		if r.db.Db != nil {
+			st.InUse = r.db.Db.Stats().InUse
+		}
+		ret = append(ret, st)
+	}
+	return ret
+}
+
+//主库
+func (c *DBCluster) Primary() *DBase {
+	return c.primary
+}
+
+//读用的库，WithPrimary、会话里写过的走主库，否则按负载均衡策略选一个健康的从库
+func (c *DBCluster) Reader(ctx context.Context) *DBase {
+	if force, _ := ctx.Value(ctxKeyPrimary).(bool); force {
+		return c.primary
+	}
+	if s, ok := ctx.Value(ctxKeySession).(*clusterSession); ok {
+		if last := atomic.LoadInt64(&s.lastWrite); last > 0 {
+			if c.Conf.StickyDuration <= 0 || time.Since(time.Unix(0, last)) < c.Conf.StickyDuration {
+				return c.primary
+			}
+		}
+	}
+	var healthy []*replica
+	for _, r := range c.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) <= 0 {
+		return c.primary
+	}
+	if c.Conf.Balance == BalanceLeastConn {
+		best, bestInUse := healthy[0], -1
+		for _, r := range healthy {
+			inUse := r.db.Db.Stats().InUse
+			if bestInUse < 0 || inUse < bestInUse {
+				best, bestInUse = r, inUse
+			}
+		}
+		return best.db
+	}
+	n := atomic.AddUint32(&c.next, 1)
+	return healthy[int(n-1)%len(healthy)].db
+}
+
+//写用的库，会记下会话里写的时间
+func (c *DBCluster) Writer(ctx context.Context) *DBase {
+	if s, ok := ctx.Value(ctxKeySession).(*clusterSession); ok {
+		atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+	}
+	return c.primary
+}
+
+//设置是否打印SQL
+func (c *DBCluster) SetDebug(d bool) {
+	c.primary.SetDebug(d)
+	for _, r := range c.replicas {
+		r.db.SetDebug(d)
+	}
+}
+
+//给主库和所有从库添加钩子，要在Conn之后调用
+func (c *DBCluster) AddHook(hooks ...QueryHook) {
+	c.primary.AddHook(hooks...)
+	for _, r := range c.replicas {
+		r.db.AddHook(hooks...)
+	}
+}
+
+//停止健康检查，关闭所有连接
+func (c *DBCluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stopCh)
+	})
+	c.wg.Wait()
+	var errs []string
+	if c.primary != nil {
+		if err := c.primary.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, r := range c.replicas {
+		if err := r.db.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close cluster failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//提取单行的单个字段，走从库
+func (c *DBCluster) FetchOne(sql string, args ...interface{}) (gItem.ItemElem, error) {
+	return c.FetchOneContext(context.Background(), sql, args...)
+}
+
+//提取单行的单个字段，走从库，可以用ctx取消
+func (c *DBCluster) FetchOneContext(ctx context.Context, sql string, args ...interface{}) (gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchOneContext(ctx, sql, args...)
+}
+
+//提取所有行的第一个字段的列表，走从库
+func (c *DBCluster) FetchCols(sql string, args ...interface{}) ([]gItem.ItemElem, error) {
+	return c.FetchColsContext(context.Background(), sql, args...)
+}
+
+//提取所有行的第一个字段的列表，走从库，可以用ctx取消
+func (c *DBCluster) FetchColsContext(ctx context.Context, sql string, args ...interface{}) ([]gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchColsContext(ctx, sql, args...)
+}
+
+//提取一行数据，走从库
+func (c *DBCluster) FetchRow(sql string, args ...interface{}) (map[string]gItem.ItemElem, error) {
+	return c.FetchRowContext(context.Background(), sql, args...)
+}
+
+//提取一行数据，走从库，可以用ctx取消
+func (c *DBCluster) FetchRowContext(ctx context.Context, sql string, args ...interface{}) (map[string]gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchRowContext(ctx, sql, args...)
+}
+
+//提取多行数据，走从库
+func (c *DBCluster) FetchRows(sql string, args ...interface{}) ([]map[string]gItem.ItemElem, error) {
+	return c.FetchRowsContext(context.Background(), sql, args...)
+}
+
+//提取多行数据，走从库，可以用ctx取消
+func (c *DBCluster) FetchRowsContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchRowsContext(ctx, sql, args...)
+}
+
+//按条件提取多行数据，走从库
+func (c *DBCluster) FetchCondRows(table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error) {
+	return c.FetchCondRowsContext(context.Background(), table, cond, fields...)
+}
+
+//按条件提取多行数据，走从库，可以用ctx取消
+func (c *DBCluster) FetchCondRowsContext(ctx context.Context, table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchCondRowsContext(ctx, table, cond, fields...)
+}
+
+//执行SQLBuilder生成的查询语句，走从库
+func (c *DBCluster) FetchRowsBuilder(b *SQLBuilder) ([]map[string]gItem.ItemElem, error) {
+	return c.FetchRowsBuilderContext(context.Background(), b)
+}
+
+//执行SQLBuilder生成的查询语句，走从库，可以用ctx取消
+func (c *DBCluster) FetchRowsBuilderContext(ctx context.Context, b *SQLBuilder) ([]map[string]gItem.ItemElem, error) {
+	return c.Reader(ctx).FetchRowsBuilderContext(ctx, b)
+}
+
+//提取一行数据到结构体里，走从库
+func (c *DBCluster) FetchStruct(dest interface{}, sql string, args ...interface{}) error {
+	return c.FetchStructContext(context.Background(), dest, sql, args...)
+}
+
+//提取一行数据到结构体里，走从库，可以用ctx取消
+func (c *DBCluster) FetchStructContext(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
+	return c.Reader(ctx).FetchStructContext(ctx, dest, sql, args...)
+}
+
+//提取多行数据到结构体的slice里，走从库
+func (c *DBCluster) FetchStructs(dest interface{}, sql string, args ...interface{}) error {
+	return c.FetchStructsContext(context.Background(), dest, sql, args...)
+}
+
+//提取多行数据到结构体的slice里，走从库，可以用ctx取消
+func (c *DBCluster) FetchStructsContext(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
+	return c.Reader(ctx).FetchStructsContext(ctx, dest, sql, args...)
+}
+
+//执行select ... for update，走主库
+func (c *DBCluster) FetchForUpdate(table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error) {
+	return c.FetchForUpdateContext(context.Background(), table, cond)
+}
+
+//执行select ... for update，走主库，可以用ctx取消
+func (c *DBCluster) FetchForUpdateContext(ctx context.Context, table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error) {
+	return c.Writer(ctx).FetchForUpdateContext(ctx, table, cond)
+}
+
+//执行一条写语句，走主库
+func (c *DBCluster) Execute(sql string, args ...interface{}) (int64, bool, error) {
+	return c.ExecuteContext(context.Background(), sql, args...)
+}
+
+//执行一条写语句，走主库，可以用ctx取消
+func (c *DBCluster) ExecuteContext(ctx context.Context, sql string, args ...interface{}) (int64, bool, error) {
+	return c.Writer(ctx).ExecuteContext(ctx, sql, args...)
+}
+
+//执行SQLBuilder生成的写语句，走主库
+func (c *DBCluster) ExecuteBuilder(b *SQLBuilder) (int64, bool, error) {
+	return c.ExecuteBuilderContext(context.Background(), b)
+}
+
+//执行SQLBuilder生成的写语句，走主库，可以用ctx取消
+func (c *DBCluster) ExecuteBuilderContext(ctx context.Context, b *SQLBuilder) (int64, bool, error) {
+	return c.Writer(ctx).ExecuteBuilderContext(ctx, b)
+}
+
+//删除数据，走主库
+func (c *DBCluster) DeleteData(table string, cond map[string]interface{}) (int64, bool, error) {
+	return c.DeleteDataContext(context.Background(), table, cond)
+}
+
+//删除数据，走主库，可以用ctx取消
+func (c *DBCluster) DeleteDataContext(ctx context.Context, table string, cond map[string]interface{}) (int64, bool, error) {
+	return c.Writer(ctx).DeleteDataContext(ctx, table, cond)
+}
+
+//写入一条数据，走主库
+func (c *DBCluster) InsertData(table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
+	return c.InsertDataContext(context.Background(), table, data, isIgnore)
+}
+
+//写入一条数据，走主库，可以用ctx取消
+func (c *DBCluster) InsertDataContext(ctx context.Context, table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
+	return c.Writer(ctx).InsertDataContext(ctx, table, data, isIgnore)
+}
+
+//批量写入数据，走主库
+func (c *DBCluster) InsertBatchData(table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
+	return c.InsertBatchDataContext(context.Background(), table, fields, data, isIgnore)
+}
+
+//批量写入数据，走主库，可以用ctx取消
+func (c *DBCluster) InsertBatchDataContext(ctx context.Context, table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
+	return c.Writer(ctx).InsertBatchDataContext(ctx, table, fields, data, isIgnore)
+}
+
+//INSERT ... ON DUPLICATE KEY UPDATE，走主库
+func (c *DBCluster) InsertUpdateData(table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error) {
+	return c.InsertUpdateDataContext(context.Background(), table, insert, update)
+}
+
+//INSERT ... ON DUPLICATE KEY UPDATE，走主库，可以用ctx取消
+func (c *DBCluster) InsertUpdateDataContext(ctx context.Context, table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error) {
+	return c.Writer(ctx).InsertUpdateDataContext(ctx, table, insert, update)
+}
+
+//更新数据，走主库
+func (c *DBCluster) UpdateData(table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
+	return c.UpdateDataContext(context.Background(), table, data, cond)
+}
+
+//更新数据，走主库，可以用ctx取消
+func (c *DBCluster) UpdateDataContext(ctx context.Context, table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
+	return c.Writer(ctx).UpdateDataContext(ctx, table, data, cond)
+}
+
+//在主库上执行事务，见DBase.WithTx
+func (c *DBCluster) WithTx(ctx context.Context, fn func(tx *TxBase) error) error {
+	return c.Writer(ctx).WithTx(ctx, fn)
+}
+
+//在主库上执行事务，见DBase.WithTxOptions
+func (c *DBCluster) WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx *TxBase) error) error {
+	return c.Writer(ctx).WithTxOptions(ctx, opts, fn)
+}
@@ -0,0 +1,164 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+//一主两从的假集群
+func newFakeCluster(t *testing.T, conf ClusterConf) (*DBCluster, *fakeServer, []*fakeServer) {
+	primary, psrv := newFakeDBase(t)
+	var dbs []*DBase
+	var srvs []*fakeServer
+	for i := 0; i < 2; i++ {
+		db, srv := newFakeDBase(t)
+		db.Conf.Host = fmt.Sprintf("replica%d", i)
+		dbs = append(dbs, db)
+		srvs = append(srvs, srv)
+	}
+	for _, srv := range append([]*fakeServer{psrv}, srvs...) {
+		srv.set("SELECT 1", fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(1)}}})
+	}
+	c := NewDBCluster(conf)
+	c.init(primary, dbs)
+	return c, psrv, srvs
+}
+
+func TestDBCluster_Route(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	c, psrv, srvs := newFakeCluster(t, ClusterConf{})
+	defer c.Close()
+	ctx := context.Background()
+
+	//读轮询从库
+	for i := 0; i < 4; i++ {
+		if _, err := c.FetchOne("SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(psrv.executed()) != 0 || len(srvs[0].executed()) != 2 || len(srvs[1].executed()) != 2 {
+		t.Errorf("unexpected reads %q %q %q", psrv.executed(), srvs[0].executed(), srvs[1].executed())
+	}
+
+	//写、事务走主库
+	c.UpdateData("t", map[string]interface{}{"a": 1}, map[string]interface{}{"id": 1})
+	c.WithTx(ctx, func(tx *TxBase) error {
+		_, _, err := tx.Execute("DELETE FROM `t`")
+		return err
+	})
+	if got := psrv.executed(); len(got) != 4 || got[1] != "BEGIN" || got[3] != "COMMIT" {
+		t.Errorf("unexpected writes %q", got)
+	}
+
+	//强制走主库
+	psrv.reset()
+	c.FetchRowsContext(WithPrimary(ctx), "SELECT 1")
+	if len(psrv.executed()) != 1 {
+		t.Errorf("WithPrimary should read primary")
+	}
+
+	//会话里写过之后读主库，其他请求不受影响
+	psrv.reset()
+	sctx := WithSession(ctx)
+	c.FetchOneContext(sctx, "SELECT 1")
+	c.ExecuteContext(sctx, "DELETE FROM `t`")
+	c.FetchOneContext(sctx, "SELECT 1")
+	c.FetchOneContext(ctx, "SELECT 1")
+	if got := psrv.executed(); len(got) != 2 || got[1] != "SELECT 1" {
+		t.Errorf("unexpected primary queries %q", got)
+	}
+
+	//超过StickyDuration后又读从库
+	c.Conf.StickyDuration = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if c.Reader(sctx) == c.Primary() {
+		t.Errorf("session should read replica after sticky duration")
+	}
+}
+
+func TestDBCluster_Health(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	c, psrv, srvs := newFakeCluster(t, ClusterConf{MaxReplicaLag: 5 * time.Second})
+	defer c.Close()
+	ctx := context.Background()
+	lag := func(v driver.Value) fakeResult {
+		return fakeResult{cols: []string{"Replica_IO_State", "Seconds_Behind_Source"}, rows: [][]driver.Value{{"", v}}}
+	}
+
+	//没有复制信息的当作延迟0
+	srvs[0].set("SHOW SLAVE STATUS", fakeResult{cols: []string{"Seconds_Behind_Master"}})
+	srvs[1].set("SHOW REPLICA STATUS", lag("1"))
+	c.CheckHealth(ctx)
+	for _, st := range c.ReplicaStatus() {
+		if !st.Healthy {
+			t.Errorf("replica should be healthy: %+v", st)
+		}
+	}
+
+	//宕机的摘掉
+	srvs[0].setDown(true)
+	c.CheckHealth(ctx)
+	srvs[1].reset()
+	for i := 0; i < 3; i++ {
+		c.FetchOne("SELECT 1")
+	}
+	if st := c.ReplicaStatus(); st[0].Healthy || st[0].Err == "" || st[0].Addr != "replica0:3306" || !st[1].Healthy || st[1].Lag != time.Second {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if n := len(srvs[1].executed()); n != 3 {
+		t.Errorf("reads should go to replica1, got %q", srvs[1].executed())
+	}
+
+	//延迟太大、复制断了的摘掉，都不健康时读主库
+	srvs[1].set("SHOW REPLICA STATUS", lag("10"))
+	c.CheckHealth(ctx)
+	if st := c.ReplicaStatus(); st[1].Healthy || st[1].Lag != 10*time.Second {
+		t.Errorf("lagging replica should be removed: %+v", st)
+	}
+	srvs[1].set("SHOW REPLICA STATUS", lag(nil))
+	c.CheckHealth(ctx)
+	if c.ReplicaStatus()[1].Healthy {
+		t.Errorf("stopped replica should be removed")
+	}
+	c.FetchOne("SELECT 1")
+	if len(psrv.executed()) != 1 {
+		t.Errorf("should fall back to primary")
+	}
+
+	//恢复后加回来
+	srvs[0].setDown(false)
+	srvs[1].set("SHOW REPLICA STATUS", lag("0"))
+	c.CheckHealth(ctx)
+	if st := c.ReplicaStatus(); !st[0].Healthy || !st[1].Healthy {
+		t.Errorf("replicas should be back: %+v", st)
+	}
+}
+
+func TestDBCluster_LeastConn(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	c, _, srvs := newFakeCluster(t, ClusterConf{Balance: BalanceLeastConn})
+	defer c.Close()
+	srvs[0].set("SELECT 2", fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}})
+
+	//replica0上有一个没读完的结果集，占着连接
+	rows, err := c.replicas[0].db.Db.Query("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for i := 0; i < 3; i++ {
+		if db := c.Reader(context.Background()); db != c.replicas[1].db {
+			t.Errorf("should pick the replica with fewer connections in use")
+		}
+	}
+}
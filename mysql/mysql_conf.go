@@ -6,8 +6,8 @@
 package mysql
 
 import (
-	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/liuyongshuai/goutils/elem"
 	"strconv"
@@ -16,29 +16,62 @@ import (
 )
 
 //存储MySQL的连接账号信息
+//除连接池的设置外，其余的字段与go-sql-driver/mysql的DSN参数一一对应，可以用ParseDSN、FormatDSN互相转换
 type MySQLConf struct {
 	Host            string        //连接地址
 	Port            uint16        //端口号，默认3306
 	User            string        //用户名
 	Passwd          string        //密码
 	DbName          string        //DB名称
-	Charset         string        //设置的字符编码，默认utf8
-	Timeout         time.Duration //连接超时时间，单位秒，默认5秒
+	Charset         string        //设置的字符编码，默认utf8，多个用","分隔表示依次尝试
+	Timeout         time.Duration //连接超时时间，默认5秒，为兼容以前的用法，小于1毫秒的按秒算
 	AutoCommit      bool          //是否自动提交，默认为true
 	MaxIdleConns    int           //允许最大空闲连接数，默认为2
 	MaxOpenConns    int           //最多允许打开多少个连接，默认0不限制
-	ConnMaxLiftTime time.Duration //连接的最大生存时间，默认0不限制，单位秒
+	ConnMaxLiftTime time.Duration //连接的最大生存时间，默认0不限制
+	StmtCacheSize   int           //缓存多少条预编译的语句，默认100，0表示不缓存
+	TxMaxRetries    int           //WithTx遇到死锁、锁等待超时时最多重试几次，默认3
+	BitAsBool       bool          //查询结果里1个字节的BIT值转为bool，驱动不提供字段长度，区分不了BIT(1)和BIT(2)~BIT(8)，所以默认为uint64
+
+	Net                      string            //连接方式，"tcp"、"tcp6"或"unix"，默认tcp
+	Socket                   string            //unix socket的路径，Net为unix时用
+	Collation                string            //连接用的排序规则，如utf8mb4_general_ci
+	Loc                      string            //解析时间用的时区，如"Local"、"Asia/Shanghai"，默认UTC
+	ParseTime                bool              //DATE、DATETIME是否解析成time.Time
+	ReadTimeout              time.Duration     //读超时，默认0不限制
+	WriteTimeout             time.Duration     //写超时，默认0不限制
+	TLS                      string            //"true"、"false"、"skip-verify"、"preferred"或用mysql.RegisterTLSConfig注册的名称
+	ServerPubKey             string            //用mysql.RegisterServerPubKey注册的服务端公钥名称
+	MaxAllowedPacket         int               //最大的包大小，默认0表示用驱动默认的64MB，-1表示用服务端的设置
+	Compress                 bool              //是否启用压缩
+	TimeTruncate             time.Duration     //time.Time类型的参数截断到的精度，默认0不截断
+	ConnectionAttributes     string            //连接属性，如"program_name:app,env:prod"
+	AllowAllFiles            bool              //LOAD DATA LOCAL INFILE是否允许所有的文件
+	AllowCleartextPasswords  bool              //是否允许明文密码认证
+	AllowFallbackToPlaintext bool              //服务端不支持TLS时是否允许不加密
+	DisableNativePasswords   bool              //是否禁用mysql_native_password认证
+	AllowOldPasswords        bool              //是否允许旧的不安全的密码认证
+	DisableConnLiveness      bool              //使用连接前是否不检查连接是否可用
+	ClientFoundRows          bool              //影响行数返回匹配的行数而不是修改的行数
+	ColumnsWithAlias         bool              //字段名前加上表的别名，如"t.id"
+	InterpolateParams        bool              //在客户端替换占位符，减少一次预编译的交互
+	MultiStatements          bool              //是否允许一次执行多条语句
+	RejectReadOnly           bool              //连到只读的实例时报错，用于主从切换
+	Params                   map[string]string //其他的会话变量，如sql_mode、time_zone
 }
 
 func MakeMySQLConf() MySQLConf {
 	return MySQLConf{
 		Port:            3306,
 		Charset:         "utf8",
-		Timeout:         5,
+		Timeout:         5 * time.Second,
 		AutoCommit:      true,
 		MaxIdleConns:    2,
 		MaxOpenConns:    0,
 		ConnMaxLiftTime: 0,
+		StmtCacheSize:   100,
+		TxMaxRetries:    3,
+		Net:             "tcp",
 	}
 }
 
@@ -108,6 +141,84 @@ func (mc MySQLConf) SetConnMaxLiftTime(t time.Duration) MySQLConf {
 	return mc
 }
 
+//设置缓存多少条预编译的语句，0表示不缓存
+func (mc MySQLConf) SetStmtCacheSize(n int) MySQLConf {
+	mc.StmtCacheSize = n
+	return mc
+}
+
+//设置WithTx遇到死锁时最多重试几次，0表示不重试
+func (mc MySQLConf) SetTxMaxRetries(n int) MySQLConf {
+	mc.TxMaxRetries = n
+	return mc
+}
+
+//通过unix socket连接
+func (mc MySQLConf) SetSocket(path string) MySQLConf {
+	mc.Net = "unix"
+	mc.Socket = path
+	return mc
+}
+
+//设置排序规则
+func (mc MySQLConf) SetCollation(c string) MySQLConf {
+	mc.Collation = c
+	return mc
+}
+
+//设置解析时间用的时区
+func (mc MySQLConf) SetLoc(loc string) MySQLConf {
+	mc.Loc = loc
+	return mc
+}
+
+//设置DATE、DATETIME是否解析成time.Time
+func (mc MySQLConf) SetParseTime(b bool) MySQLConf {
+	mc.ParseTime = b
+	return mc
+}
+
+//设置读超时
+func (mc MySQLConf) SetReadTimeout(t time.Duration) MySQLConf {
+	mc.ReadTimeout = t
+	return mc
+}
+
+//设置写超时
+func (mc MySQLConf) SetWriteTimeout(t time.Duration) MySQLConf {
+	mc.WriteTimeout = t
+	return mc
+}
+
+//设置TLS，"true"、"skip-verify"、"preferred"或注册的名称
+func (mc MySQLConf) SetTLS(t string) MySQLConf {
+	mc.TLS = t
+	return mc
+}
+
+//设置是否在客户端替换占位符
+func (mc MySQLConf) SetInterpolateParams(b bool) MySQLConf {
+	mc.InterpolateParams = b
+	return mc
+}
+
+//设置是否允许一次执行多条语句
+func (mc MySQLConf) SetMultiStatements(b bool) MySQLConf {
+	mc.MultiStatements = b
+	return mc
+}
+
+//设置会话变量，如SetParam("sql_mode", "'TRADITIONAL'")
+func (mc MySQLConf) SetParam(k, v string) MySQLConf {
+	params := make(map[string]string, len(mc.Params)+1)
+	for pk, pv := range mc.Params {
+		params[pk] = pv
+	}
+	params[k] = v
+	mc.Params = params
+	return mc
+}
+
 var sqlTokenMap = make(map[string]string)
 var delimiter = []string{"AND", "and", "OR", "or", ","}
 
@@ -150,6 +261,7 @@ func checkSQLToken(t string) bool {
 /**
  * 仅用在预编译的查询语句中
  * 格式化要查询的条件语句，只支持简单语句
+ * 条件按字段名排序，同样的cond生成的SQL总是一样的，复杂的查询请用SQLBuilder
  */
 func FormatCond(cond map[string]interface{}, delim string) (sqlCond string, param []interface{}) {
 	if !checkDelimiter(delim) {
@@ -157,106 +269,101 @@ func FormatCond(cond map[string]interface{}, delim string) (sqlCond string, para
 	}
 	delim = strings.ToUpper(delim)
 	var tmpCond []string
-	var condItem = make(map[string]elem.ItemElem)
-	for k, v := range cond {
-		condItem[k] = elem.MakeItemElem(v)
+	for _, c := range MapConds(cond) {
+		cd, args := c.ToSQL()
+		tmpCond = append(tmpCond, cd)
+		param = append(param, args...)
 	}
+	sqlCond = strings.Join(tmpCond, " "+delim+" ")
+	return sqlCond, param
+}
 
-	//遍历所有的查询条件,k为字段名，v为相应的值
-	for k, v := range condItem {
-		tmpToken := "="
-		tmpSym := "="
-		key := k
-		//如果字段名里包含":"
-		if strings.Index(k, ":") > 0 {
-			tmpS := strings.Split(k, ":")
-			tmpSym = tmpS[1]
-			key = tmpS[0]
-			if checkSQLToken(tmpSym) {
-				tmpToken = sqlTokenMap[tmpSym]
-			}
+//将FormatCond格式的条件转为Cond，按字段名排序，不合法的条件会被忽略
+//字段名里可以用":"带上操作符，如"id:in"、"name:like"、"age:gte"
+func MapConds(cond map[string]interface{}) []Cond {
+	var ret []Cond
+	for _, k := range sortedKeys(cond) {
+		if c := mapCond(k, elem.MakeItemElem(cond[k])); c != nil {
+			ret = append(ret, c)
 		}
-		//如果字段符号是in/notin,允许的值有string/slice/map
-		vlen, verr := v.Len()
-		if tmpSym == "in" || tmpSym == "notin" {
-			var vslice []elem.ItemElem
-			if v.IsString() && vlen > 0 { //如果是字符串则用"，"切成slice
-				tmp := strings.Split(v.ToString(), ",")
-				for _, t := range tmp {
-					if len(t) <= 0 {
-						continue
-					}
-					vslice = append(vslice, elem.MakeItemElem(t))
-				}
+	}
+	return ret
+}
 
-			} else if v.IsSimpleType() { //其他的简单类型，直接填上去即可
-				vslice = append(vslice, v)
-			} else { //否则，对于复杂类型，只要可以转成slice就可以
-				tos, toerr := v.ToSlice()
-				if toerr == nil {
-					vslice = append(vslice, tos...)
+//单个条件，k为字段名，v为相应的值
+func mapCond(k string, v elem.ItemElem) Cond {
+	tmpToken := "="
+	tmpSym := "="
+	key := k
+	//如果字段名里包含":"
+	if strings.Index(k, ":") > 0 {
+		tmpS := strings.Split(k, ":")
+		tmpSym = tmpS[1]
+		key = tmpS[0]
+		if checkSQLToken(tmpSym) {
+			tmpToken = sqlTokenMap[tmpSym]
+		}
+	}
+	//如果字段符号是in/notin,允许的值有string/slice/map
+	vlen, verr := v.Len()
+	if tmpSym == "in" || tmpSym == "notin" {
+		var vslice []elem.ItemElem
+		if v.IsString() && vlen > 0 { //如果是字符串则用"，"切成slice
+			tmp := strings.Split(v.ToString(), ",")
+			for _, t := range tmp {
+				if len(t) <= 0 {
+					continue
 				}
+				vslice = append(vslice, elem.MakeItemElem(t))
 			}
-			vsliceLen := len(vslice)
-			if vsliceLen <= 0 {
-				continue
-			}
-			param = append(param, ConvertArgs(vslice)...)
-			//用问号填充查询字段的值
-			var tmpQ []string
-			for i := 0; i < vsliceLen; i++ {
-				tmpQ = append(tmpQ, "?")
+
+		} else if v.IsSimpleType() { //其他的简单类型，直接填上去即可
+			vslice = append(vslice, v)
+		} else { //否则，对于复杂类型，只要可以转成slice就可以
+			tos, toerr := v.ToSlice()
+			if toerr == nil {
+				vslice = append(vslice, tos...)
 			}
-			//扔到最终的SQL条件里
-			cd := fmt.Sprintf("`%s` %s (%s)", key, tmpToken, strings.Join(tmpQ, ","))
-			tmpCond = append(tmpCond, cd)
-			continue
 		}
-		//只允许简单类型
-		if tmpToken == "LIKE" && v.IsSimpleType() {
-			likeV := v.ToString()
-			if len(likeV) <= 0 { //like的值不能为空，且要转为字符串
-				continue
-			}
-			switch tmpSym {
-			case "rlike":
-				likeV += "%" //右like，加后面
-			case "llike":
-				likeV = "%" + likeV //左like，加前面
-			case "like":
-				likeV = "%" + likeV + "%" //双边like，前后都加
-			}
-			param = append(param, likeV)
-			cd := fmt.Sprintf("`%s` %s ?", key, tmpToken)
-			tmpCond = append(tmpCond, cd)
-			continue
+		if len(vslice) <= 0 {
+			return nil
 		}
-		if tmpSym == "is" && vlen > 0 && verr == nil {
-			cd := fmt.Sprintf("`%s` %s ?", key, tmpToken)
-			tmpCond = append(tmpCond, cd)
-			param = append(param, v.RawData())
-			continue
+		if tmpSym == "in" {
+			return In(key, ConvertArgs(vslice))
 		}
-		//对于find_in_set来说，只允许简单类型
-		if tmpSym == "find" && v.IsSimpleType() {
-			if len(v.ToString()) <= 0 {
-				continue
-			}
-			cd := fmt.Sprintf("%s(?,`%s`)", tmpToken, key)
-			tmpCond = append(tmpCond, cd)
-			param = append(param, v.RawData())
-			continue
+		return NotIn(key, ConvertArgs(vslice))
+	}
+	//只允许简单类型
+	if tmpToken == "LIKE" && v.IsSimpleType() {
+		likeV := v.ToString()
+		if len(likeV) <= 0 { //like的值不能为空，且要转为字符串
+			return nil
 		}
-		//其余的全部要求只能是简单类型
-		if v.IsSimpleType() {
-			cd := fmt.Sprintf("`%s` %s ?", key, tmpToken)
-			tmpCond = append(tmpCond, cd)
-			param = append(param, v.RawData())
-			continue
+		switch tmpSym {
+		case "rlike":
+			likeV += "%" //右like，加后面
+		case "llike":
+			likeV = "%" + likeV //左like，加前面
+		case "like":
+			likeV = "%" + likeV + "%" //双边like，前后都加
 		}
+		return Like(key, likeV)
 	}
-	sqlCond = strings.Join(tmpCond, " "+delim+" ")
-	return sqlCond, param
+	if tmpSym == "is" && vlen > 0 && verr == nil {
+		return compare(key, tmpToken, v.RawData())
+	}
+	//对于find_in_set来说，只允许简单类型
+	if tmpSym == "find" && v.IsSimpleType() {
+		if len(v.ToString()) <= 0 {
+			return nil
+		}
+		return FindInSet(key, v.RawData())
+	}
+	//其余的全部要求只能是简单类型
+	if v.IsSimpleType() {
+		return compare(key, tmpToken, v.RawData())
+	}
+	return nil
 }
 
 //转换查询SQL用的参数
@@ -268,64 +375,133 @@ func ConvertArgs(param []elem.ItemElem) []interface{} {
 	return args
 }
 
-//将[]byte根据MySQL的字段类型转为相应的值
-func convertMySQLType(b []byte, t *sql.ColumnType) interface{} {
-	buf := bytes.NewBuffer(b)
-	tstr := buf.String()
-	scankind := t.ScanType().Kind().String()
-	dbType := t.DatabaseTypeName()
+//DECIMAL类型的值，保留原始的字符串，不丢精度
+type Decimal string
+
+func (d Decimal) String() string {
+	return string(d)
+}
+
+//转为float64，可能会丢精度
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(string(d), 64)
+}
 
-	//根据接收类型做判断
-	switch scankind {
-	case "uint", "uint8", "uint16", "uint32", "uint64": //uint系列
-		ret, err := strconv.ParseUint(tstr, 10, 64)
-		if err != nil {
-			return tstr
+//把驱动返回的值根据MySQL的字段类型转为相应的值：
+//NULL为nil，整数为int64/uint64，浮点数为float64，DECIMAL为Decimal，
+//DATE/DATETIME/TIMESTAMP为loc时区的time.Time（"0000-00-00"为零值），JSON为json.RawMessage，
+//BIT按大端转成uint64，bitBool为true时1个字节的BIT转成bool，二进制类型为[]byte，其余的为string
+func convertMySQLType(v interface{}, t *sql.ColumnType, loc *time.Location, bitBool bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	b, isBytes := v.([]byte)
+	dbType := t.DatabaseTypeName()
+	switch dbType {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if isBytes {
+			if ret, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+				return ret
+			}
+			return string(b)
 		}
-		return ret
-	case "int", "int8", "int16", "int32", "int64": //int系列
-		ret, err := strconv.ParseInt(tstr, 10, 64)
-		if err != nil {
-			return tstr
+		return toInt64(v)
+	case "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+		if isBytes {
+			if ret, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+				return ret
+			}
+			return string(b)
 		}
-		return ret
-	case "float32", "float64": //float系列
-		ret, err := strconv.ParseFloat(tstr, 64)
-		if err != nil {
-			return tstr
+		return toUint64(v)
+	case "FLOAT", "DOUBLE":
+		switch f := v.(type) {
+		case float32:
+			//直接转float64会带出float32的误差，如0.1变成0.10000000149011612
+			if ret, err := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64); err == nil {
+				return ret
+			}
+			return float64(f)
+		case float64:
+			return f
 		}
-		return ret
+		if isBytes {
+			if ret, err := strconv.ParseFloat(string(b), 64); err == nil {
+				return ret
+			}
+			return string(b)
+		}
+	case "DECIMAL":
+		if isBytes {
+			return Decimal(b)
+		}
+		return Decimal(fmt.Sprint(v))
+	case "DATE", "DATETIME", "TIMESTAMP":
+		if tm, ok := v.(time.Time); ok {
+			return tm.In(loc)
+		}
+		if isBytes {
+			if tm, err := parseMySQLTime(string(b), loc); err == nil {
+				return tm
+			}
+			return string(b)
+		}
+	case "JSON":
+		if isBytes {
+			return json.RawMessage(b)
+		}
+	case "BIT":
+		if isBytes {
+			if bitBool && len(b) == 1 {
+				return b[0] != 0
+			}
+			var n uint64
+			for _, c := range b {
+				n = n<<8 | uint64(c)
+			}
+			return n
+		}
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY", "VECTOR":
+		return v
 	}
-
-	//如果是varchar类型，可以返回字符串，对于text/blob/binary系列不好处理
-	if dbType == "varchar" || dbType == "text" {
-		return tstr
+	if isBytes {
+		return string(b)
 	}
-	if scankind == "sql.RawBytes" {
-		return b
+	return v
+}
+
+//有符号整数
+func toInt64(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int:
+		return int64(n)
 	}
-	return tstr
-}
-
-//提取字段
-func filterTableFields(fields ...string) string {
-	f := "*"
-	if len(fields) > 0 {
-		var tmp []string
-		for _, tf := range fields {
-			replace := strings.NewReplacer(
-				"`", "",
-				"'", "",
-				"\"", "",
-				",", "",
-				"\\", "",
-				"/", "",
-			)
-			tf = replace.Replace(tf)
-			tmp = append(tmp, tf)
+	return v
+}
+
+//无符号整数
+func toUint64(v interface{}) interface{} {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case uint32:
+		return uint64(n)
+	case uint16:
+		return uint64(n)
+	case uint8:
+		return uint64(n)
+	case int64:
+		if n >= 0 {
+			return uint64(n)
 		}
-		f = strings.Join(tmp, "`,`")
-		f = "`" + f + "`"
 	}
-	return f
+	return v
 }
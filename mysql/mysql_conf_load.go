@@ -0,0 +1,243 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DSN里的驱动参数，按不区分大小写、忽略"_"、"-"的名称查找
+var dsnParamNames = []string{
+	"allowAllFiles", "allowCleartextPasswords", "allowFallbackToPlaintext", "allowNativePasswords",
+	"allowOldPasswords", "checkConnLiveness", "clientFoundRows", "collation", "columnsWithAlias",
+	"connectionAttributes", "compress", "interpolateParams", "loc", "multiStatements", "parseTime",
+	"timeTruncate", "readTimeout", "rejectReadOnly", "serverPubKey", "tls", "writeTimeout",
+}
+
+//时间类型的配置项，纯数字的按秒算
+var durationConfKeys = map[string]bool{
+	"timeout": true, "readtimeout": true, "writetimeout": true, "timetruncate": true,
+	"connmaxlifetime": true, "connmaxlifttime": true,
+}
+
+//统一配置项的名称：转小写，去掉"_"、"-"
+func normalizeConfKey(k string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(k))
+}
+
+//从环境变量里加载配置，变量名为前缀加上大写下划线格式的字段名，如：
+//MYSQL_HOST、MYSQL_PORT、MYSQL_USER、MYSQL_PASSWORD、MYSQL_DATABASE、MYSQL_READ_TIMEOUT
+//先读"前缀+DSN"，再用单独的变量覆盖；会话变量放在"前缀+PARAMS"里，格式同URL的query
+//不认识的变量会被忽略，时间类型的值可以是"5s"，也可以是秒数
+func LoadMySQLConfFromEnv(prefix string) (MySQLConf, error) {
+	mc := MakeMySQLConf()
+	if dsn, ok := os.LookupEnv(prefix + "DSN"); ok && len(dsn) > 0 {
+		var err error
+		if mc, err = ParseDSN(dsn); err != nil {
+			return mc, fmt.Errorf("%sDSN: %v", prefix, err)
+		}
+	}
+	var keys []string
+	vals := make(map[string]string)
+	for _, kv := range os.Environ() {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 || !strings.HasPrefix(kv[:idx], prefix) {
+			continue
+		}
+		k := kv[len(prefix):idx]
+		if k == "DSN" {
+			continue
+		}
+		keys = append(keys, k)
+		vals[k] = kv[idx+1:]
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := vals[k]
+		if normalizeConfKey(k) == "params" {
+			q, err := url.ParseQuery(v)
+			if err != nil {
+				return mc, fmt.Errorf("%s%s: %v", prefix, k, err)
+			}
+			for pk := range q {
+				mc = mc.SetParam(pk, q.Get(pk))
+			}
+			continue
+		}
+		if _, err := mc.setConfValue(k, v); err != nil {
+			return mc, fmt.Errorf("%s%s: %v", prefix, k, err)
+		}
+	}
+	return mc, nil
+}
+
+//从配置文件里加载配置，根据扩展名支持.json、.yaml、.yml
+//字段名不区分大小写，可以用"db_name"、"dbName"、"dbname"等格式，也可以只写一个dsn
+//会话变量放在params里，不认识的字段会报错
+func LoadMySQLConfFile(path string) (MySQLConf, error) {
+	mc := MakeMySQLConf()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mc, err
+	}
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return mc, fmt.Errorf("unsupported mysql conf file: %s", path)
+	}
+	if err != nil {
+		return mc, fmt.Errorf("parse %s failed: %v", path, err)
+	}
+	return makeConfFromMap(raw)
+}
+
+//用map里的配置生成MySQLConf
+func makeConfFromMap(raw map[string]interface{}) (MySQLConf, error) {
+	mc := MakeMySQLConf()
+	var keys []string
+	for k, v := range raw {
+		switch normalizeConfKey(k) {
+		case "dsn":
+			dsn, ok := v.(string)
+			if !ok {
+				return mc, fmt.Errorf("dsn: must be a string")
+			}
+			var err error
+			if mc, err = ParseDSN(dsn); err != nil {
+				return mc, fmt.Errorf("dsn: %v", err)
+			}
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := raw[k]
+		if normalizeConfKey(k) == "params" {
+			params, ok := v.(map[string]interface{})
+			if !ok {
+				return mc, fmt.Errorf("%s: must be a map", k)
+			}
+			for pk, pv := range params {
+				mc = mc.SetParam(pk, fmt.Sprint(pv))
+			}
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return mc, fmt.Errorf("%s: must be a scalar value", k)
+		}
+		known, err := mc.setConfValue(k, fmt.Sprint(v))
+		if err != nil {
+			return mc, fmt.Errorf("%s: %v", k, err)
+		}
+		if !known {
+			return mc, fmt.Errorf("unknown mysql conf field: %s", k)
+		}
+	}
+	return mc, nil
+}
+
+//按名称设置单个配置项，名称不认识时返回false
+func (mc *MySQLConf) setConfValue(key, val string) (bool, error) {
+	nk := normalizeConfKey(key)
+	val = strings.TrimSpace(val)
+	if durationConfKeys[nk] {
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			val += "s"
+		}
+	}
+	switch nk {
+	case "host":
+		mc.Host = val
+	case "port":
+		p, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			return true, fmt.Errorf("invalid port %q", val)
+		}
+		mc.Port = uint16(p)
+	case "user", "username":
+		mc.User = val
+	case "passwd", "password":
+		mc.Passwd = val
+	case "dbname", "database", "db":
+		mc.DbName = val
+	case "charset":
+		mc.Charset = val
+	case "net":
+		mc.Net = val
+	case "socket":
+		*mc = mc.SetSocket(val)
+	case "autocommit", "bitasbool":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return true, fmt.Errorf("invalid bool value %q", val)
+		}
+		if nk == "autocommit" {
+			mc.AutoCommit = b
+		} else {
+			mc.BitAsBool = b
+		}
+	case "maxidleconns", "maxopenconns", "stmtcachesize", "txmaxretries":
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return true, fmt.Errorf("invalid int value %q", val)
+		}
+		switch nk {
+		case "maxidleconns":
+			mc.MaxIdleConns = n
+		case "maxopenconns":
+			mc.MaxOpenConns = n
+		case "stmtcachesize":
+			mc.StmtCacheSize = n
+		default:
+			mc.TxMaxRetries = n
+		}
+	case "connmaxlifetime", "connmaxlifttime":
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return true, fmt.Errorf("invalid duration value %q", val)
+		}
+		mc.ConnMaxLiftTime = d
+	case "timeout":
+		return true, mc.setDSNParam("timeout", url.QueryEscape(val))
+	case "maxallowedpacket":
+		//配置文件里写的就是实际的大小，0表示用服务端的设置
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return true, fmt.Errorf("invalid int value %q", val)
+		}
+		mc.MaxAllowedPacket = n
+		if n == 0 {
+			mc.MaxAllowedPacket = -1
+		}
+	default:
+		for _, name := range dsnParamNames {
+			if strings.ToLower(name) == nk {
+				return true, mc.setDSNParam(name, url.QueryEscape(val))
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
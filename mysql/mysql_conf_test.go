@@ -1,10 +1,15 @@
 package mysql
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"github.com/kr/pretty"
+	"reflect"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestFormatCond(t *testing.T) {
@@ -23,10 +28,93 @@ func TestFormatCond(t *testing.T) {
 	cond["cnum"] = "99999"
 	cond["praiseNum"] = []interface{}{"aaaa", 999} //非法
 	sqlCond, param := FormatCond(cond, delim)
-	/**
-	sqlCond "`id` IN (?,?,?) AND `tid` < ? AND FIND_IN_SET(?,`tags`) AND `cnum` = ? AND `uid` IN (?) AND `name` LIKE ?"
-	param {}{"444","666","888",int(400),"google","99999",int(444),"%liuyongshuai%",}
-	*/
 	fmt.Printf("sqlCond %# v\n", pretty.Formatter(sqlCond))
 	fmt.Printf("param %# v\n", pretty.Formatter(param))
+	//按字段名排序，每次都一样
+	expect := "`cnum` = ? AND `id` IN (?,?,?) AND `name` LIKE ? AND FIND_IN_SET(?,`tags`) AND `tid` < ? AND `uid` IN (?)"
+	if sqlCond != expect {
+		t.Errorf("unexpected cond %s", sqlCond)
+	}
+	if fmt.Sprint(param) != fmt.Sprint([]interface{}{"99999", "444", "666", "888", "%liuyongshuai%", "google", 400, 444}) {
+		t.Errorf("unexpected param %v", param)
+	}
+}
+
+func TestConvertMySQLType(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	db.Conf.Loc = "Asia/Shanghai"
+	srv.set("SELECT * FROM t", fakeResult{
+		cols:  []string{"id", "uid", "price", "created", "day", "ext", "flag", "mask", "bit", "score", "name", "memo", "raw"},
+		types: []string{"INT", "UNSIGNED BIGINT", "DECIMAL", "DATETIME", "DATE", "JSON", "BIT", "BIT", "BIT", "FLOAT", "VARCHAR", "VARCHAR", "BLOB"},
+		rows: [][]driver.Value{
+			{[]byte("-3"), []byte("18446744073709551615"), []byte("0.10"), []byte("2018-01-25 19:19:00.5"), []byte("2018-01-25"),
+				[]byte(`{"a":1}`), []byte{1}, []byte{1, 2}, []byte{1}, float32(0.1), []byte("abc"), []byte(""), []byte{0, 1}},
+			{nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+		},
+	})
+	rows, err := db.FetchRows("SELECT * FROM t")
+	if err != nil || len(rows) != 2 {
+		t.Fatal(rows, err)
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	want := map[string]interface{}{
+		"id":      int64(-3),
+		"uid":     uint64(18446744073709551615),
+		"price":   Decimal("0.10"),
+		"created": time.Date(2018, 1, 25, 19, 19, 0, 5e8, loc),
+		"day":     time.Date(2018, 1, 25, 0, 0, 0, 0, loc),
+		"ext":     json.RawMessage(`{"a":1}`),
+		"flag":    uint64(1),
+		"mask":    uint64(258),
+		"bit":     uint64(1),
+		"score":   0.1,
+		"name":    "abc",
+		"memo":    "",
+		"raw":     []byte{0, 1},
+	}
+	for k, v := range want {
+		got := rows[0][k].RawData()
+		if tm, ok := v.(time.Time); ok {
+			if gt, ok := got.(time.Time); !ok || !gt.Equal(tm) || gt.Location().String() != loc.String() {
+				t.Errorf("%s: got %#v want %v", k, got, tm)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("%s: got %#v want %#v", k, got, v)
+		}
+	}
+	//NULL与空字符串要能区分开
+	for k := range want {
+		if !rows[1][k].IsNull() || rows[1][k].ToString() != "" {
+			t.Errorf("%s should be NULL, got %#v", k, rows[1][k].RawData())
+		}
+	}
+	if rows[0]["memo"].IsNull() {
+		t.Errorf("empty string is not NULL")
+	}
+	//驱动不提供字段长度，设置BitAsBool后1个字节的BIT才转为bool
+	db.Conf.BitAsBool = true
+	rows, err = db.FetchRows("SELECT * FROM t")
+	if err != nil || rows[0]["flag"].RawData() != true || rows[0]["bit"].RawData() != true || rows[0]["mask"].RawData() != uint64(258) {
+		t.Errorf("BitAsBool: %v %v", rows, err)
+	}
+	it, err := db.Iterate(context.Background(), "SELECT * FROM t")
+	if err != nil || !it.Next() {
+		t.Fatal(err)
+	}
+	if row, err := it.Row(); err != nil || row["flag"].RawData() != true {
+		t.Errorf("BitAsBool iterate: %v %v", row, err)
+	}
+	it.Close()
+	if s := rows[0]["created"].ToString(); s != "2018-01-25 19:19:00.5" {
+		t.Errorf("unexpected %s", s)
+	}
+	if v, err := rows[0]["price"].RawData().(Decimal).Float64(); err != nil || v != 0.1 {
+		t.Errorf("unexpected %v %v", v, err)
+	}
 }
@@ -0,0 +1,67 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	gItem "github.com/liuyongshuai/goutils/elem"
+)
+
+//DBase、TxBase、DBCluster共有的读写方法
+//业务代码依赖这个接口，单元测试时可以换成mysqltest.New()返回的不连MySQL的DBase
+type DB interface {
+	FetchOne(sql string, args ...interface{}) (gItem.ItemElem, error)
+	FetchOneContext(ctx context.Context, sql string, args ...interface{}) (gItem.ItemElem, error)
+	FetchCols(sql string, args ...interface{}) ([]gItem.ItemElem, error)
+	FetchColsContext(ctx context.Context, sql string, args ...interface{}) ([]gItem.ItemElem, error)
+	FetchRow(sql string, args ...interface{}) (map[string]gItem.ItemElem, error)
+	FetchRowContext(ctx context.Context, sql string, args ...interface{}) (map[string]gItem.ItemElem, error)
+	FetchRows(sql string, args ...interface{}) ([]map[string]gItem.ItemElem, error)
+	FetchRowsContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]gItem.ItemElem, error)
+	FetchCondRows(table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error)
+	FetchCondRowsContext(ctx context.Context, table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error)
+	FetchRowsBuilder(b *SQLBuilder) ([]map[string]gItem.ItemElem, error)
+	FetchRowsBuilderContext(ctx context.Context, b *SQLBuilder) ([]map[string]gItem.ItemElem, error)
+	FetchStruct(dest interface{}, sql string, args ...interface{}) error
+	FetchStructContext(ctx context.Context, dest interface{}, sql string, args ...interface{}) error
+	FetchStructs(dest interface{}, sql string, args ...interface{}) error
+	FetchStructsContext(ctx context.Context, dest interface{}, sql string, args ...interface{}) error
+	FetchForUpdate(table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error)
+	FetchForUpdateContext(ctx context.Context, table string, cond map[string]interface{}) (map[string]gItem.ItemElem, error)
+	Execute(sql string, args ...interface{}) (int64, bool, error)
+	ExecuteContext(ctx context.Context, sql string, args ...interface{}) (int64, bool, error)
+	ExecuteBuilder(b *SQLBuilder) (int64, bool, error)
+	ExecuteBuilderContext(ctx context.Context, b *SQLBuilder) (int64, bool, error)
+	DeleteData(table string, cond map[string]interface{}) (int64, bool, error)
+	DeleteDataContext(ctx context.Context, table string, cond map[string]interface{}) (int64, bool, error)
+	InsertData(table string, data map[string]interface{}, isIgnore bool) (int64, bool, error)
+	InsertDataContext(ctx context.Context, table string, data map[string]interface{}, isIgnore bool) (int64, bool, error)
+	InsertBatchData(table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error)
+	InsertBatchDataContext(ctx context.Context, table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error)
+	InsertUpdateData(table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error)
+	InsertUpdateDataContext(ctx context.Context, table string, insert map[string]interface{}, update map[string]interface{}) (int64, bool, error)
+	UpdateData(table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error)
+	UpdateDataContext(ctx context.Context, table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error)
+	WithTx(ctx context.Context, fn func(tx *TxBase) error) error
+	WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx *TxBase) error) error
+}
+
+var (
+	_ DB = (*DBase)(nil)
+	_ DB = (*TxBase)(nil)
+	_ DB = (*DBCluster)(nil)
+)
+
+//用已经打开的sql.DB创建DBase，如别的驱动、mysqltest里的假驱动，conf里只有连接之后的配置有效，如StmtCacheSize、Loc
+func WrapDB(db *sql.DB, conf MySQLConf) *DBase {
+	my := NewDBase(conf)
+	my.Db = db
+	if conf.StmtCacheSize > 0 {
+		my.stmts = newStmtCache(db, conf.StmtCacheSize)
+	}
+	return my
+}
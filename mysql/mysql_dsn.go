@@ -0,0 +1,435 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//驱动默认的最大包大小
+const defaultMaxAllowedPacket = 64 << 20
+
+//配置校验出错的字段
+type ConfFieldError struct {
+	Field string //字段名
+	Msg   string //出错原因
+}
+
+func (e ConfFieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+//配置校验的错误，包含所有出错的字段
+type ConfError []ConfFieldError
+
+func (e ConfError) Error() string {
+	var tmp []string
+	for _, fe := range e {
+		tmp = append(tmp, fe.Error())
+	}
+	return "invalid mysql conf: " + strings.Join(tmp, "; ")
+}
+
+//是否有某个字段出错
+func (e ConfError) Has(field string) bool {
+	for _, fe := range e {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+//校验配置，有错误时返回ConfError
+func (mc MySQLConf) Validate() error {
+	var errs ConfError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ConfFieldError{Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+	switch mc.network() {
+	case "tcp", "tcp4", "tcp6":
+		if len(mc.Host) <= 0 {
+			add("Host", "empty host")
+		}
+		if mc.Port == 0 {
+			add("Port", "empty port")
+		}
+	case "unix":
+		if len(mc.Socket) <= 0 {
+			add("Socket", "empty socket path")
+		}
+	default:
+		add("Net", "unsupported network %q", mc.Net)
+	}
+	if len(mc.User) <= 0 {
+		add("User", "empty user")
+	}
+	if len(mc.DbName) <= 0 {
+		add("DbName", "empty database")
+	}
+	durations := []struct {
+		field string
+		d     time.Duration
+	}{
+		{"Timeout", mc.Timeout},
+		{"ReadTimeout", mc.ReadTimeout},
+		{"WriteTimeout", mc.WriteTimeout},
+		{"ConnMaxLiftTime", mc.ConnMaxLiftTime},
+		{"TimeTruncate", mc.TimeTruncate},
+	}
+	for _, fd := range durations {
+		if fd.d < 0 {
+			add(fd.field, "negative duration %v", fd.d)
+		}
+	}
+	if mc.MaxIdleConns < 0 {
+		add("MaxIdleConns", "negative value %d", mc.MaxIdleConns)
+	}
+	if mc.MaxOpenConns < 0 {
+		add("MaxOpenConns", "negative value %d", mc.MaxOpenConns)
+	}
+	if mc.StmtCacheSize < 0 {
+		add("StmtCacheSize", "negative value %d", mc.StmtCacheSize)
+	}
+	if mc.TxMaxRetries < 0 {
+		add("TxMaxRetries", "negative value %d", mc.TxMaxRetries)
+	}
+	if mc.MaxAllowedPacket < -1 {
+		add("MaxAllowedPacket", "invalid value %d", mc.MaxAllowedPacket)
+	}
+	if len(mc.Loc) > 0 {
+		if _, err := time.LoadLocation(mc.Loc); err != nil {
+			add("Loc", "%v", err)
+		}
+	}
+	//这几个要用mysql.RegisterTLSConfig注册才能用，这里只检查明显不对的
+	if strings.ContainsAny(mc.TLS, "&=?") {
+		add("TLS", "invalid tls config name %q", mc.TLS)
+	}
+	var keys []string
+	for k := range mc.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(k) <= 0 || strings.ContainsAny(k, "&=?") {
+			add("Params", "invalid param name %q", k)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//连接方式，默认tcp
+func (mc MySQLConf) network() string {
+	if len(mc.Net) <= 0 {
+		return "tcp"
+	}
+	return mc.Net
+}
+
+//连接超时时间，小于1毫秒的当成秒数，兼容以前SetTimeout(5)的用法
+func (mc MySQLConf) connTimeout() time.Duration {
+	if mc.Timeout > 0 && mc.Timeout < time.Millisecond {
+		return mc.Timeout * time.Second
+	}
+	return mc.Timeout
+}
+
+//生成go-sql-driver/mysql用的DSN，格式为：
+//[user[:password]@][net[(addr)]]/dbname[?param1=value1&paramN=valueN]
+//参数的顺序与驱动的FormatDSN一致，同样的配置生成的DSN总是一样的
+func (mc MySQLConf) FormatDSN() string {
+	var buf bytes.Buffer
+	if len(mc.User) > 0 {
+		buf.WriteString(mc.User)
+		if len(mc.Passwd) > 0 {
+			buf.WriteByte(':')
+			buf.WriteString(mc.Passwd)
+		}
+		buf.WriteByte('@')
+	}
+	netw := mc.network()
+	buf.WriteString(netw)
+	if netw == "unix" {
+		if len(mc.Socket) > 0 {
+			buf.WriteString("(" + mc.Socket + ")")
+		}
+	} else if len(mc.Host) > 0 {
+		port := mc.Port
+		if port == 0 {
+			port = 3306
+		}
+		buf.WriteString("(" + net.JoinHostPort(mc.Host, strconv.FormatUint(uint64(port), 10)) + ")")
+	}
+	buf.WriteByte('/')
+	buf.WriteString(url.PathEscape(mc.DbName))
+
+	var params []string
+	add := func(k, v string) {
+		params = append(params, k+"="+v)
+	}
+	addBool := func(k string, b, def bool) {
+		if b != def {
+			add(k, strconv.FormatBool(b))
+		}
+	}
+	addDuration := func(k string, d time.Duration) {
+		if d > 0 {
+			add(k, d.String())
+		}
+	}
+	addBool("allowAllFiles", mc.AllowAllFiles, false)
+	addBool("allowCleartextPasswords", mc.AllowCleartextPasswords, false)
+	addBool("allowFallbackToPlaintext", mc.AllowFallbackToPlaintext, false)
+	addBool("allowNativePasswords", !mc.DisableNativePasswords, true)
+	addBool("allowOldPasswords", mc.AllowOldPasswords, false)
+	addBool("checkConnLiveness", !mc.DisableConnLiveness, true)
+	addBool("clientFoundRows", mc.ClientFoundRows, false)
+	if len(mc.Charset) > 0 {
+		add("charset", url.QueryEscape(mc.Charset))
+	}
+	if len(mc.Collation) > 0 {
+		add("collation", url.QueryEscape(mc.Collation))
+	}
+	addBool("columnsWithAlias", mc.ColumnsWithAlias, false)
+	if len(mc.ConnectionAttributes) > 0 {
+		add("connectionAttributes", url.QueryEscape(mc.ConnectionAttributes))
+	}
+	addBool("compress", mc.Compress, false)
+	addBool("interpolateParams", mc.InterpolateParams, false)
+	if len(mc.Loc) > 0 && mc.Loc != "UTC" {
+		add("loc", url.QueryEscape(mc.Loc))
+	}
+	addBool("multiStatements", mc.MultiStatements, false)
+	addBool("parseTime", mc.ParseTime, false)
+	addDuration("timeTruncate", mc.TimeTruncate)
+	addDuration("readTimeout", mc.ReadTimeout)
+	addBool("rejectReadOnly", mc.RejectReadOnly, false)
+	if len(mc.ServerPubKey) > 0 {
+		add("serverPubKey", url.QueryEscape(mc.ServerPubKey))
+	}
+	addDuration("timeout", mc.connTimeout())
+	if len(mc.TLS) > 0 {
+		add("tls", url.QueryEscape(mc.TLS))
+	}
+	addDuration("writeTimeout", mc.WriteTimeout)
+	switch {
+	case mc.MaxAllowedPacket < 0:
+		add("maxAllowedPacket", "0")
+	case mc.MaxAllowedPacket > 0 && mc.MaxAllowedPacket != defaultMaxAllowedPacket:
+		add("maxAllowedPacket", strconv.Itoa(mc.MaxAllowedPacket))
+	}
+	//会话变量，按名称排序
+	sp := make(map[string]string, len(mc.Params)+1)
+	for k, v := range mc.Params {
+		sp[k] = v
+	}
+	if !mc.AutoCommit {
+		sp["autocommit"] = "0"
+	}
+	keys := make([]string, 0, len(sp))
+	for k := range sp {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, url.QueryEscape(sp[k]))
+	}
+	if len(params) > 0 {
+		buf.WriteByte('?')
+		buf.WriteString(strings.Join(params, "&"))
+	}
+	return buf.String()
+}
+
+//解析go-sql-driver/mysql的DSN，规则与驱动的ParseDSN一致
+//DSN里没有的驱动选项用驱动的默认值，连接池的设置用MakeMySQLConf的默认值
+func ParseDSN(dsn string) (MySQLConf, error) {
+	mc := MakeMySQLConf()
+	mc.Charset = ""
+	mc.Timeout = 0
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return mc, fmt.Errorf("invalid DSN: missing the slash separating the database name")
+	}
+	//[user[:password]@][net[(addr)]]
+	left := dsn[:slash]
+	if at := strings.LastIndex(left, "@"); at >= 0 {
+		userPass := left[:at]
+		if idx := strings.Index(userPass, ":"); idx >= 0 {
+			mc.User, mc.Passwd = userPass[:idx], userPass[idx+1:]
+		} else {
+			mc.User = userPass
+		}
+		left = left[at+1:]
+	}
+	addr := ""
+	if idx := strings.Index(left, "("); idx >= 0 {
+		if !strings.HasSuffix(left, ")") {
+			return mc, fmt.Errorf("invalid DSN: network address not terminated (missing closing brace)")
+		}
+		addr = left[idx+1 : len(left)-1]
+		left = left[:idx]
+	}
+	if len(left) > 0 {
+		mc.Net = left
+	}
+	if mc.Net == "unix" {
+		mc.Socket = addr
+		mc.Host = ""
+	} else if len(addr) > 0 {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			//没有端口的用默认端口
+			host, port = strings.Trim(addr, "[]"), "3306"
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return mc, fmt.Errorf("invalid DSN: invalid port %q", port)
+		}
+		mc.Host, mc.Port = host, uint16(p)
+	} else {
+		mc.Host = "127.0.0.1"
+	}
+
+	//dbname[?param1=value1&...&paramN=valueN]
+	right := dsn[slash+1:]
+	query := ""
+	if idx := strings.Index(right, "?"); idx >= 0 {
+		right, query = right[:idx], right[idx+1:]
+	}
+	var err error
+	if mc.DbName, err = url.PathUnescape(right); err != nil {
+		return mc, fmt.Errorf("invalid dbname %q: %v", right, err)
+	}
+	if len(query) <= 0 {
+		return mc, nil
+	}
+	for _, kv := range strings.Split(query, "&") {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			continue
+		}
+		if err = mc.setDSNParam(kv[:idx], kv[idx+1:]); err != nil {
+			return mc, err
+		}
+	}
+	return mc, nil
+}
+
+//设置DSN里的单个参数，value是转义过的
+func (mc *MySQLConf) setDSNParam(key, value string) error {
+	val, err := url.QueryUnescape(value)
+	if err != nil {
+		return fmt.Errorf("invalid DSN param %s: %v", key, err)
+	}
+	readBool := func(p *bool) error {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid bool value: %s=%s", key, val)
+		}
+		*p = b
+		return nil
+	}
+	readDuration := func(p *time.Duration) error {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid duration value: %s=%s", key, val)
+		}
+		*p = d
+		return nil
+	}
+	switch key {
+	case "allowAllFiles":
+		return readBool(&mc.AllowAllFiles)
+	case "allowCleartextPasswords":
+		return readBool(&mc.AllowCleartextPasswords)
+	case "allowFallbackToPlaintext":
+		return readBool(&mc.AllowFallbackToPlaintext)
+	case "allowNativePasswords":
+		var b bool
+		err := readBool(&b)
+		mc.DisableNativePasswords = !b
+		return err
+	case "allowOldPasswords":
+		return readBool(&mc.AllowOldPasswords)
+	case "checkConnLiveness":
+		var b bool
+		err := readBool(&b)
+		mc.DisableConnLiveness = !b
+		return err
+	case "clientFoundRows":
+		return readBool(&mc.ClientFoundRows)
+	case "charset":
+		mc.Charset = val
+	case "collation":
+		mc.Collation = val
+	case "columnsWithAlias":
+		return readBool(&mc.ColumnsWithAlias)
+	case "connectionAttributes":
+		mc.ConnectionAttributes = val
+	case "compress":
+		return readBool(&mc.Compress)
+	case "interpolateParams":
+		return readBool(&mc.InterpolateParams)
+	case "loc":
+		mc.Loc = val
+	case "multiStatements":
+		return readBool(&mc.MultiStatements)
+	case "parseTime":
+		return readBool(&mc.ParseTime)
+	case "timeTruncate":
+		return readDuration(&mc.TimeTruncate)
+	case "readTimeout":
+		return readDuration(&mc.ReadTimeout)
+	case "rejectReadOnly":
+		return readBool(&mc.RejectReadOnly)
+	case "serverPubKey":
+		mc.ServerPubKey = val
+	case "timeout":
+		return readDuration(&mc.Timeout)
+	case "tls":
+		//驱动把布尔值统一成"true"、"false"
+		if b, err := strconv.ParseBool(val); err == nil {
+			val = strconv.FormatBool(b)
+		}
+		mc.TLS = val
+	case "writeTimeout":
+		return readDuration(&mc.WriteTimeout)
+	case "maxAllowedPacket":
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid int value: %s=%s", key, val)
+		}
+		switch n {
+		case 0:
+			mc.MaxAllowedPacket = -1
+		case defaultMaxAllowedPacket:
+			mc.MaxAllowedPacket = 0
+		default:
+			mc.MaxAllowedPacket = n
+		}
+	case "autocommit":
+		mc.AutoCommit = val == "1" || strings.EqualFold(val, "true") || strings.EqualFold(val, "on")
+	default:
+		//其余的都是会话变量
+		if mc.Params == nil {
+			mc.Params = make(map[string]string)
+		}
+		mc.Params[key] = val
+	}
+	return nil
+}
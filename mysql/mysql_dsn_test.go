@@ -0,0 +1,180 @@
+package mysql
+
+import (
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMySQLConf_FormatDSN(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	conf := MakeMySQLConf().SetHost("db.local").SetUser("root").SetPasswd("p@ss:w/rd?&").
+		SetDbName("db_wendao").SetTimeout(3).SetReadTimeout(2*time.Second).
+		SetLoc("Asia/Shanghai").SetParseTime(true).SetCollation("utf8mb4_general_ci").
+		SetParam("sql_mode", "'TRADITIONAL'").SetAutoCommit(false)
+	conf.MaxAllowedPacket = 1 << 20
+	conf.DisableConnLiveness = true
+	conf.ConnectionAttributes = "program_name:test"
+	dsn := conf.FormatDSN()
+	fmt.Println(dsn)
+
+	//驱动能解析，且各项都对得上
+	dc, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Passwd != conf.Passwd || dc.Addr != "db.local:3306" || dc.Timeout != 3*time.Second ||
+		dc.ReadTimeout != 2*time.Second || dc.Loc.String() != "Asia/Shanghai" || !dc.ParseTime ||
+		dc.MaxAllowedPacket != 1<<20 || dc.CheckConnLiveness || dc.Params["autocommit"] != "0" ||
+		dc.Params["sql_mode"] != "'TRADITIONAL'" || dc.Collation != "utf8mb4_general_ci" {
+		t.Errorf("driver parsed %+v", dc)
+	}
+	//驱动再生成的DSN与自己生成的一样
+	if s := dc.FormatDSN(); s != dsn {
+		t.Errorf("driver format mismatch:\n%s\n%s", s, dsn)
+	}
+
+	//解析回来是同样的配置
+	conf.Timeout = 3 * time.Second
+	pc2, err := ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pc2, conf) {
+		t.Errorf("round trip mismatch:\n%+v\n%+v", pc2, conf)
+	}
+
+	//unix socket
+	conf = MakeMySQLConf().SetSocket("/tmp/mysql.sock").SetUser("u").SetDbName("d")
+	dsn = conf.FormatDSN()
+	if dsn != "u@unix(/tmp/mysql.sock)/d?charset=utf8&timeout=5s" {
+		t.Errorf("unexpected dsn %s", dsn)
+	}
+	if pc2, _ = ParseDSN(dsn); !reflect.DeepEqual(pc2, conf) {
+		t.Errorf("round trip mismatch:\n%+v\n%+v", pc2, conf)
+	}
+
+	//没有地址的用默认值
+	pc2, err = ParseDSN("u:p@/d?tls=1&foo=bar%20baz&maxAllowedPacket=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc2.Host != "127.0.0.1" || pc2.Port != 3306 || pc2.TLS != "true" ||
+		pc2.Params["foo"] != "bar baz" || pc2.MaxAllowedPacket != -1 {
+		t.Errorf("unexpected %+v", pc2)
+	}
+	for _, dsn := range []string{"u@tcp(a:b)/d", "u@tcp(a/d", "nodb", "u@/d?parseTime=yes"} {
+		if _, err = ParseDSN(dsn); err == nil {
+			t.Errorf("expected error for %s", dsn)
+		}
+	}
+}
+
+func TestMySQLConf_Validate(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	conf := MakeMySQLConf().SetHost("127.0.0.1").SetUser("root").SetDbName("d")
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Host = ""
+	conf.ReadTimeout = -1
+	conf.Loc = "Nowhere/City"
+	conf = conf.SetParam("a=b", "1")
+	err := conf.Validate()
+	fmt.Println(err)
+	ce, ok := err.(ConfError)
+	if !ok || len(ce) != 4 {
+		t.Fatalf("unexpected %v", err)
+	}
+	for _, field := range []string{"Host", "ReadTimeout", "Loc", "Params"} {
+		if !ce.Has(field) {
+			t.Errorf("missing %s", field)
+		}
+	}
+	if ce.Has("User") {
+		t.Errorf("unexpected User error")
+	}
+	if _, err = NewDBase(conf).Conn(); err == nil {
+		t.Errorf("Conn should validate conf")
+	}
+}
+
+func TestLoadMySQLConf(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	want := MakeMySQLConf().SetHost("db.local").SetPort(3307).SetUser("root").SetPasswd("123456").
+		SetDbName("db_wendao").SetReadTimeout(2*time.Second).SetParseTime(true).
+		SetParam("sql_mode", "TRADITIONAL").SetConnMaxLiftTime(time.Minute)
+
+	//环境变量，单独的变量覆盖DSN里的
+	env := map[string]string{
+		"TEST_MYSQL_DSN":               "root:123456@tcp(db.local:3306)/db_wendao?parseTime=true",
+		"TEST_MYSQL_PORT":              "3307",
+		"TEST_MYSQL_READ_TIMEOUT":      "2",
+		"TEST_MYSQL_CONN_MAX_LIFETIME": "1m",
+		"TEST_MYSQL_PARAMS":            "sql_mode=TRADITIONAL",
+		"TEST_MYSQL_ROOT_PASSWORD":     "ignored",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	conf, err := LoadMySQLConfFromEnv("TEST_MYSQL_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Charset, want.Timeout = "", 0
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("env mismatch:\n%+v\n%+v", conf, want)
+	}
+	os.Setenv("TEST_MYSQL_PORT", "abc")
+	if _, err = LoadMySQLConfFromEnv("TEST_MYSQL_"); err == nil {
+		t.Errorf("expected error for invalid port")
+	}
+
+	//配置文件
+	want.Charset, want.Timeout, want.BitAsBool = "utf8mb4", 5*time.Second, true
+	dir, _ := ioutil.TempDir("", "mysqlconf")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.json": `{"host":"db.local","port":3307,"user":"root","password":"123456","db_name":"db_wendao",
+			"charset":"utf8mb4","readTimeout":"2s","parse_time":true,"conn_max_lifetime":60,"bit_as_bool":true,
+			"params":{"sql_mode":"TRADITIONAL"}}`,
+		"a.yaml": "host: db.local\nport: 3307\nuser: root\npasswd: \"123456\"\ndatabase: db_wendao\n" +
+			"charset: utf8mb4\nread-timeout: 2\nparseTime: true\nconnMaxLifeTime: 1m\ntimeout: 5\nbitAsBool: true\n" +
+			"params:\n  sql_mode: TRADITIONAL\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		conf, err = LoadMySQLConfFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(conf, want) {
+			t.Errorf("%s mismatch:\n%+v\n%+v", name, conf, want)
+		}
+	}
+	for name, content := range map[string]string{
+		"b.json": `{"hots":"db.local"}`,
+		"c.yaml": "port: [1]",
+		"d.toml": "host = 1",
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err = LoadMySQLConfFile(path); err == nil {
+			t.Errorf("expected error for %s", name)
+		}
+	}
+}
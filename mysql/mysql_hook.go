@@ -0,0 +1,403 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//语句的类型
+const (
+	OpQuery = "query" //查询
+	OpExec  = "exec"  //写
+	OpLoad  = "load"  //LOAD DATA
+)
+
+//一次查询或写操作的信息
+//BeforeQuery时只有Op、SQL、Args、InTx、Start，AfterQuery时才有其他的
+type QueryEvent struct {
+	Op       string        //OpQuery、OpExec、OpLoad
+	SQL      string        //执行的SQL
+	Args     []interface{} //参数
+	InTx     bool          //是否在事务里
+	Start    time.Time     //开始时间
+	Duration time.Duration //耗时，查询的包括读完所有行的时间
+	Rows     int64         //查询为读到的行数，写为影响行数
+	Err      error         //错误
+}
+
+//每条SQL执行前后调用的钩子，用于慢日志、统计、链路追踪等
+//BeforeQuery返回的ctx会用来执行SQL并传给AfterQuery，可以在里面放span之类的
+type QueryHook interface {
+	BeforeQuery(ctx context.Context, e *QueryEvent) context.Context
+	AfterQuery(ctx context.Context, e *QueryEvent)
+}
+
+//添加钩子，按添加的顺序调用BeforeQuery，倒序调用AfterQuery
+//要在开始查询之前添加，事务里的语句也会调用
+func (my *DBase) AddHook(hooks ...QueryHook) {
+	my.hooks = append(my.hooks, hooks...)
+}
+
+//执行前调用钩子，没有钩子时返回nil
+func (my *DBase) beforeQuery(ctx context.Context, op string, query string, args []interface{}) (context.Context, *QueryEvent) {
+	if len(my.hooks) <= 0 {
+		return ctx, nil
+	}
+	e := &QueryEvent{Op: op, SQL: query, Args: args, InTx: my.tx != nil, Start: time.Now()}
+	for _, h := range my.hooks {
+		ctx = h.BeforeQuery(ctx, e)
+	}
+	return ctx, e
+}
+
+//执行后调用钩子，e为nil时什么也不做
+func (my *DBase) afterQuery(ctx context.Context, e *QueryEvent, rows int64, err error) {
+	if e == nil {
+		return
+	}
+	e.Duration = time.Since(e.Start)
+	e.Rows = rows
+	e.Err = err
+	for i := len(my.hooks) - 1; i >= 0; i-- {
+		my.hooks[i].AfterQuery(ctx, e)
+	}
+}
+
+//慢查询日志，耗时超过Threshold的打到Logger里
+type SlowLogHook struct {
+	Threshold time.Duration //超过这个耗时的才记，默认1秒
+	Logger    *log.Logger   //为nil时用log包默认的
+}
+
+func NewSlowLogHook(threshold time.Duration, logger *log.Logger) *SlowLogHook {
+	return &SlowLogHook{Threshold: threshold, Logger: logger}
+}
+
+func (h *SlowLogHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *SlowLogHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	threshold := h.Threshold
+	if threshold <= 0 {
+		threshold = time.Second
+	}
+	if e.Duration < threshold {
+		return
+	}
+	printf := log.Printf
+	if h.Logger != nil {
+		printf = h.Logger.Printf
+	}
+	printf("[slow %s] %s rows:%d err:%v SQL:%s args:%v", e.Op, e.Duration, e.Rows, e.Err, e.SQL, e.Args)
+}
+
+//结构化日志，每条SQL一条，出错的为Warn级别，其他的为Debug级别
+type SlogHook struct {
+	Logger   *slog.Logger //为nil时用slog.Default()
+	WithArgs bool         //是否记参数，参数里可能有敏感信息，默认不记
+}
+
+func NewSlogHook(logger *slog.Logger) *SlogHook {
+	return &SlogHook{Logger: logger}
+}
+
+func (h *SlogHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *SlogHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	logger := h.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := slog.LevelDebug
+	if e.Err != nil {
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("op", e.Op),
+		slog.String("sql", e.SQL),
+		slog.String("fingerprint", Fingerprint(e.SQL)),
+		slog.Duration("duration", e.Duration),
+		slog.Int64("rows", e.Rows),
+		slog.Bool("in_tx", e.InTx),
+	}
+	if h.WithArgs {
+		attrs = append(attrs, slog.Any("args", e.Args))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	logger.LogAttrs(ctx, level, "mysql", attrs...)
+}
+
+//默认的耗时分桶
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+//超过MaxFingerprints后新的语句都归到这里
+const otherFingerprint = "other"
+
+//一类SQL的耗时统计
+type FingerprintStats struct {
+	Fingerprint string          //归一化后的SQL
+	Count       int64           //执行次数
+	Errors      int64           //出错次数
+	Rows        int64           //总行数
+	Total       time.Duration   //总耗时
+	Max         time.Duration   //最大耗时
+	Buckets     []time.Duration //分桶的上限，同QueryStatsHook.Buckets
+	Counts      []int64         //每个桶的次数，比Buckets多一个，最后一个为超过所有上限的
+}
+
+//平均耗时
+func (s FingerprintStats) Avg() time.Duration {
+	if s.Count <= 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+//按分桶估算的分位数耗时，q为0到1，如0.99，落在最后一个桶里时返回Max
+func (s FingerprintStats) Quantile(q float64) time.Duration {
+	if s.Count <= 0 {
+		return 0
+	}
+	rank := int64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range s.Counts {
+		n += c
+		if n >= rank {
+			if i < len(s.Buckets) {
+				return s.Buckets[i]
+			}
+			break
+		}
+	}
+	return s.Max
+}
+
+//按SQL指纹分组统计耗时分布
+type QueryStatsHook struct {
+	Buckets         []time.Duration //耗时分桶的上限，从小到大，默认DefaultLatencyBuckets
+	MaxFingerprints int             //最多统计多少类SQL，超过的归到"other"，默认1000
+	mu              sync.Mutex
+	stats           map[string]*FingerprintStats
+}
+
+func NewQueryStatsHook() *QueryStatsHook {
+	return &QueryStatsHook{
+		Buckets:         DefaultLatencyBuckets,
+		MaxFingerprints: 1000,
+		stats:           make(map[string]*FingerprintStats),
+	}
+}
+
+func (h *QueryStatsHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *QueryStatsHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	fp := Fingerprint(e.SQL)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stats == nil {
+		h.stats = make(map[string]*FingerprintStats)
+	}
+	s, ok := h.stats[fp]
+	if !ok {
+		if h.MaxFingerprints > 0 && len(h.stats) >= h.MaxFingerprints {
+			fp = otherFingerprint
+			s, ok = h.stats[fp]
+		}
+		if !ok {
+			s = &FingerprintStats{Fingerprint: fp, Buckets: h.Buckets, Counts: make([]int64, len(h.Buckets)+1)}
+			h.stats[fp] = s
+		}
+	}
+	s.Count++
+	if e.Err != nil {
+		s.Errors++
+	}
+	s.Rows += e.Rows
+	s.Total += e.Duration
+	if e.Duration > s.Max {
+		s.Max = e.Duration
+	}
+	i := sort.Search(len(s.Buckets), func(i int) bool { return e.Duration <= s.Buckets[i] })
+	s.Counts[i]++
+}
+
+//当前的统计，按总耗时从大到小排
+func (h *QueryStatsHook) Snapshot() []FingerprintStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]FingerprintStats, 0, len(h.stats))
+	for _, s := range h.stats {
+		c := *s
+		c.Counts = append([]int64(nil), s.Counts...)
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Total != ret[j].Total {
+			return ret[i].Total > ret[j].Total
+		}
+		return ret[i].Fingerprint < ret[j].Fingerprint
+	})
+	return ret
+}
+
+//清空统计
+func (h *QueryStatsHook) Reset() {
+	h.mu.Lock()
+	h.stats = make(map[string]*FingerprintStats)
+	h.mu.Unlock()
+}
+
+var (
+	fpValueList = regexp.MustCompile(`\(\?(?: ?, ?\?)*\)`)
+	fpMultiRows = regexp.MustCompile(`\(\?\+\)(?: ?, ?\(\?\+\))+`)
+)
+
+//SQL指纹：去掉注释，字符串、数字换成?，IN列表、多行VALUES合成一个，空白合成一个空格，转小写
+//反引号里的名字保持原样，用于把同一类的SQL归到一起
+//
+//	SELECT * FROM `t` WHERE id IN (1, 2, 3) AND name = 'abc'
+//	=> select * from `t` where id in (?+) and name = ?
+func Fingerprint(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query))
+	space := false
+	n := len(query)
+	for i := 0; i < n; i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c == '#' || (c == '-' && isLineComment(query[i:])):
+			for i < n && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			sb.WriteByte('?')
+		case c == '`':
+			j := i + 1
+			for j < n && query[j] != '`' {
+				j++
+			}
+			if j >= n {
+				j = n - 1
+			}
+			sb.WriteString(query[i : j+1])
+			i = j
+		case c >= '0' && c <= '9' && !isIdentByte(lastByte(&sb)):
+			i = skipNumber(query, i)
+			sb.WriteByte('?')
+		case c >= 'A' && c <= 'Z':
+			sb.WriteByte(c + 'a' - 'A')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	s := fpValueList.ReplaceAllString(sb.String(), "(?+)")
+	return fpMultiRows.ReplaceAllString(s, "(?+)")
+}
+
+//跳过引号里的字符串，返回最后一个引号的位置，支持\转义及两个引号的转义
+func skipQuoted(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case q:
+			if j+1 < len(s) && s[j+1] == q {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(s) - 1
+}
+
+//跳过数字，如123、1.5、1e-3、0x1F，返回最后一个字符的位置
+func skipNumber(s string, i int) int {
+	j := i
+	if s[j] == '0' && j+1 < len(s) && (s[j+1] == 'x' || s[j+1] == 'X') {
+		j += 2
+		for j < len(s) && isHexByte(s[j]) {
+			j++
+		}
+		return j - 1
+	}
+	for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+		j++
+	}
+	if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+		k := j + 1
+		if k < len(s) && (s[k] == '+' || s[k] == '-') {
+			k++
+		}
+		if k < len(s) && s[k] >= '0' && s[k] <= '9' {
+			for j = k; j < len(s) && s[j] >= '0' && s[j] <= '9'; j++ {
+			}
+		}
+	}
+	return j - 1
+}
+
+func lastByte(sb *strings.Builder) byte {
+	s := sb.String()
+	if len(s) <= 0 {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isHexByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
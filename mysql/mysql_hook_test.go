@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	cases := map[string]string{
+		"SELECT * FROM `User` WHERE id IN (1, 2 ,3) AND name = 'a''b\\'c'": "select * from `User` where id in (?+) and name = ?",
+		"select *\n\tfrom t where id=? -- 注释\n  limit 10":                  "select * from t where id=? limit ?",
+		"INSERT INTO t (a,b) VALUES (1,\"x\"),(2,'y'), (3, 'z')":           "insert into t (a,b) values (?+)",
+		"/* trace:abc */ UPDATE t2 SET v=v+1.5e-3, h=0x1F # x":             "update t2 set v=v+?, h=?",
+		"SELECT a1, b_2 FROM t_10 WHERE c IS NULL":                         "select a1, b_2 from t_10 where c is null",
+	}
+	for in, want := range cases {
+		if got := Fingerprint(in); got != want {
+			t.Errorf("Fingerprint(%q)\n got %q\nwant %q", in, got, want)
+		}
+	}
+}
+
+//记录所有的事件
+type recordHook struct {
+	name   string
+	order  *[]string
+	events []QueryEvent
+}
+
+type hookCtxKey struct{}
+
+func (h *recordHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	*h.order = append(*h.order, "before:"+h.name)
+	return context.WithValue(ctx, hookCtxKey{}, h.name)
+}
+
+func (h *recordHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	*h.order = append(*h.order, "after:"+h.name+":"+fmt.Sprint(ctx.Value(hookCtxKey{})))
+	h.events = append(h.events, *e)
+}
+
+func TestDBase_AddHook(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+	var order []string
+	h1, h2 := &recordHook{name: "h1", order: &order}, &recordHook{name: "h2", order: &order}
+	db.AddHook(h1, h2)
+	srv.set("SELECT * FROM t WHERE a = ?", fakeResult{cols: []string{"a"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}})
+	srv.set("UPDATE t SET a = 1", fakeResult{affected: 3})
+	srv.set("SELECT bad", fakeResult{err: errors.New("boom")})
+
+	if _, err := db.FetchRowsContext(ctx, "SELECT * FROM t WHERE a = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := "before:h1 before:h2 after:h2:h2 after:h1:h2"; strings.Join(order, " ") != want {
+		t.Errorf("unexpected order %v", order)
+	}
+	db.ExecuteContext(ctx, "UPDATE t SET a = 1")
+	db.FetchRowsContext(ctx, "SELECT bad")
+	it, err := db.Iterate(ctx, "SELECT * FROM t WHERE a = ?", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+	}
+	db.WithTx(ctx, func(tx *TxBase) error {
+		_, _, err := tx.ExecuteContext(ctx, "UPDATE t SET a = 1")
+		return err
+	})
+
+	ev := h1.events
+	if len(ev) != 5 || len(h2.events) != 5 {
+		t.Fatalf("unexpected events %+v", ev)
+	}
+	if ev[0].Op != OpQuery || ev[0].Rows != 2 || ev[0].Args[0] != 1 || ev[0].Err != nil || ev[0].Start.IsZero() {
+		t.Errorf("unexpected query event %+v", ev[0])
+	}
+	if ev[1].Op != OpExec || ev[1].Rows != 3 || ev[1].InTx {
+		t.Errorf("unexpected exec event %+v", ev[1])
+	}
+	if ev[2].Err == nil || !strings.Contains(ev[2].Err.Error(), "boom") {
+		t.Errorf("unexpected error event %+v", ev[2])
+	}
+	if ev[3].Op != OpQuery || ev[3].Rows != 2 || ev[3].Args[0] != 2 {
+		t.Errorf("unexpected iterate event %+v", ev[3])
+	}
+	if !ev[4].InTx || ev[4].Rows != 3 {
+		t.Errorf("unexpected tx event %+v", ev[4])
+	}
+}
+
+func TestBuiltinHooks(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	ctx := context.Background()
+	events := []*QueryEvent{
+		{Op: OpQuery, SQL: "SELECT * FROM t WHERE id = 1", Duration: 2 * time.Millisecond, Rows: 1},
+		{Op: OpQuery, SQL: "SELECT * FROM t WHERE id = 2", Duration: 20 * time.Millisecond, Rows: 1},
+		{Op: OpQuery, SQL: "select * from t where id = 3", Duration: 2 * time.Second, Err: errors.New("timeout")},
+		{Op: OpExec, SQL: "DELETE FROM t", Duration: time.Millisecond, Rows: 5},
+	}
+
+	//慢查询
+	var buf bytes.Buffer
+	slow := NewSlowLogHook(10*time.Millisecond, log.New(&buf, "", 0))
+	for _, e := range events {
+		slow.AfterQuery(ctx, e)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "err:timeout") {
+		t.Errorf("unexpected slow log %q", buf.String())
+	}
+
+	//按指纹统计
+	stats := NewQueryStatsHook()
+	for _, e := range events {
+		stats.AfterQuery(ctx, e)
+	}
+	snap := stats.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("unexpected stats %+v", snap)
+	}
+	s := snap[0]
+	if s.Fingerprint != "select * from t where id = ?" || s.Count != 3 || s.Errors != 1 || s.Rows != 2 || s.Max != 2*time.Second {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.Counts[1] != 1 || s.Counts[3] != 1 || s.Counts[7] != 1 {
+		t.Errorf("unexpected buckets %v", s.Counts)
+	}
+	if s.Quantile(0.5) != 50*time.Millisecond || s.Quantile(0.99) != 5*time.Second || s.Avg() != (2022*time.Millisecond)/3 {
+		t.Errorf("unexpected quantile %v %v %v", s.Quantile(0.5), s.Quantile(0.99), s.Avg())
+	}
+	stats.MaxFingerprints = 2
+	stats.AfterQuery(ctx, &QueryEvent{SQL: "SELECT 1"})
+	stats.AfterQuery(ctx, &QueryEvent{SQL: "SELECT 2 FROM x"})
+	if snap = stats.Snapshot(); len(snap) != 3 || snap[2].Fingerprint != otherFingerprint || snap[2].Count != 2 {
+		t.Errorf("unexpected stats %+v", snap)
+	}
+	stats.Reset()
+	if len(stats.Snapshot()) != 0 {
+		t.Errorf("reset failed")
+	}
+
+	//结构化日志
+	buf.Reset()
+	sh := NewSlogHook(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	for _, e := range events {
+		sh.AfterQuery(ctx, e)
+	}
+	out := buf.String()
+	if strings.Count(out, "\n") != 1 || !strings.Contains(out, "level=WARN") || !strings.Contains(out, `error=timeout`) ||
+		!strings.Contains(out, `fingerprint="select * from t where id = ?"`) || strings.Contains(out, "args=") {
+		t.Errorf("unexpected slog output %q", out)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"reflect"
+	"time"
+)
+
+//把一行数据转成map，按列的类型转换
+type rowMapper struct {
+	cols     []string
+	colTypes []*sql.ColumnType
+	vals     []interface{}
+	scanArgs []interface{}
+	loc      *time.Location
+	bitBool  bool
+}
+
+func newRowMapper(rows *sql.Rows, loc *time.Location, bitBool bool) (*rowMapper, error) {
+	//所有的列名
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	//存储每一行值的slice，每次传地址给scan方法，NULL为nil
+	m := &rowMapper{cols: cols, colTypes: colTypes, vals: make([]interface{}, len(cols)), loc: loc, bitBool: bitBool}
+	m.scanArgs = make([]interface{}, len(m.vals))
+	for i := range m.vals {
+		m.scanArgs[i] = &m.vals[i]
+	}
+	return m, nil
+}
+
+//扫描当前行，每次返回新的map
+func (m *rowMapper) scan(rows *sql.Rows) (map[string]gItem.ItemElem, error) {
+	if err := rows.Scan(m.scanArgs...); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]gItem.ItemElem, len(m.cols))
+	//根据不同的类型转换一下
+	for i, val := range m.vals {
+		ret[m.cols[i]] = gItem.MakeItemElem(convertMySQLType(val, m.colTypes[i], m.loc, m.bitBool))
+	}
+	return ret, nil
+}
+
+//逐行读取查询结果，不会把所有的行都放到内存里，用法同sql.Rows
+//读完或出错时自动关闭，中途退出时要调用Close
+//
+//	it, err := db.Iterate(ctx, "SELECT * FROM `users`")
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		row := it.Row()
+//	}
+//	return it.Err()
+type RowIter struct {
+	ctx     context.Context
+	rows    *sql.Rows
+	release func()
+	mapper  *rowMapper
+	loc     *time.Location
+	err     error
+	closed  bool
+	db      *DBase
+	event   *QueryEvent //钩子的信息，Close时调用AfterQuery
+	n       int64       //已经读到的行数
+}
+
+//执行查询，返回逐行读取的迭代器
+func (my *DBase) Iterate(ctx context.Context, sql string, args ...interface{}) (*RowIter, error) {
+	if my.Db == nil {
+		return nil, fmt.Errorf("not connect MySQL")
+	}
+	if my.IsDebug {
+		fmt.Printf("Iterate:\n")
+		fmt.Printf("\tSQL:%s\n", sql)
+		fmt.Println("args:\t", args)
+	}
+	ctx, e := my.beforeQuery(ctx, OpQuery, sql, args)
+	stmt, release, err := my.prepare(ctx, sql)
+	if err != nil {
+		my.afterQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		release()
+		my.afterQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	return &RowIter{ctx: ctx, rows: rows, release: release, loc: my.timeLocation(), db: my, event: e}, nil
+}
+
+//执行SQLBuilder生成的查询语句，返回逐行读取的迭代器
+func (my *DBase) IterateBuilder(ctx context.Context, b *SQLBuilder) (*RowIter, error) {
+	fsql, args, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return my.Iterate(ctx, fsql, args...)
+}
+
+//移到下一行，没有了或出错时返回false并关闭
+func (it *RowIter) Next() bool {
+	if it.closed {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	if it.rows.Next() {
+		it.n++
+		return true
+	}
+	it.err = it.rows.Err()
+	it.Close()
+	return false
+}
+
+//所有的列名
+func (it *RowIter) Columns() ([]string, error) {
+	return it.rows.Columns()
+}
+
+//当前行，每次返回新的map
+func (it *RowIter) Row() (map[string]gItem.ItemElem, error) {
+	if it.mapper == nil {
+		m, err := newRowMapper(it.rows, it.loc, it.db.Conf.BitAsBool)
+		if err != nil {
+			return nil, err
+		}
+		it.mapper = m
+	}
+	return it.mapper.scan(it.rows)
+}
+
+//把当前行扫描到结构体里，dest为结构体指针，字段的对应同FetchStruct
+func (it *RowIter) Scan(dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a non-nil pointer to struct, got %T", dest)
+	}
+	cols, err := it.rows.Columns()
+	if err != nil {
+		return err
+	}
+	return scanStruct(it.rows, cols, getStructMeta(dv.Elem().Type()), dv.Elem(), it.loc)
+}
+
+//遍历过程中的错误，ctx取消时为ctx.Err()
+func (it *RowIter) Err() error {
+	return it.err
+}
+
+//关闭结果集，可以多次调用
+func (it *RowIter) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	err := it.rows.Close()
+	it.release()
+	it.db.afterQuery(it.ctx, it.event, it.n, it.err)
+	return err
+}
+
+//逐行处理查询结果，fn返回错误时停止并返回这个错误
+func (my *DBase) EachRow(ctx context.Context, sql string, args []interface{}, fn func(row map[string]gItem.ItemElem) error) error {
+	it, err := my.Iterate(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		row, err := it.Row()
+		if err != nil {
+			return err
+		}
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+//按主键分批遍历的参数
+type KeysetOptions struct {
+	Key       string                 //有序、唯一且不为NULL的字段，默认"id"
+	Fields    []string               //要查的字段，默认"*"，不包含Key时会自动加上
+	Cond      map[string]interface{} //其他条件，同FormatCond
+	BatchSize int                    //每批的条数，默认1000
+	After     interface{}            //从这个值之后开始（不包含），默认从头开始，用于断点续传
+	Desc      bool                   //从大到小遍历
+}
+
+//按主键顺序分批遍历整张表，每批用"Key > 上一批最后的值"查询，不用OFFSET，越往后也不会变慢
+//fn返回错误时停止并返回这个错误
+func (my *DBase) WalkTable(ctx context.Context, table string, opts KeysetOptions, fn func(rows []map[string]gItem.ItemElem) error) error {
+	if len(opts.Key) <= 0 {
+		opts.Key = "id"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	fields := opts.Fields
+	if len(fields) > 0 {
+		found := false
+		for _, f := range fields {
+			if f == opts.Key || f == "*" {
+				found = true
+			}
+		}
+		if !found {
+			fields = append([]string{opts.Key}, fields...)
+		}
+	}
+	order, next := opts.Key+" ASC", Gt
+	if opts.Desc {
+		order, next = opts.Key+" DESC", Lt
+	}
+	last := opts.After
+	for {
+		b := Select(fields...).From(table).Where(MapConds(opts.Cond)...).OrderBy(order).Limit(opts.BatchSize)
+		if last != nil {
+			b.Where(next(opts.Key, last))
+		}
+		rows, err := my.FetchRowsBuilderContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		if len(rows) <= 0 {
+			return nil
+		}
+		key, ok := rows[len(rows)-1][opts.Key]
+		if !ok {
+			return fmt.Errorf("key %s not in result", opts.Key)
+		}
+		//"Key > NULL"查不到下一批，为nil时又会从头开始，一直循环下去
+		if key.IsNull() {
+			return fmt.Errorf("key %s is NULL, can not walk by it", opts.Key)
+		}
+		if err = fn(rows); err != nil {
+			return err
+		}
+		if len(rows) < opts.BatchSize {
+			return nil
+		}
+		last = key.RawData()
+	}
+}
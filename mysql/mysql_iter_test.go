@@ -0,0 +1,175 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"runtime"
+	"testing"
+)
+
+func TestDBase_Iterate(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+	var rows [][]driver.Value
+	for i := 1; i <= 5; i++ {
+		rows = append(rows, []driver.Value{int64(i), fmt.Sprintf("name%d", i)})
+	}
+	srv.set("SELECT * FROM `users`", fakeResult{cols: []string{"id", "name"}, rows: rows})
+
+	//逐行读取map
+	it, err := db.Iterate(ctx, "SELECT * FROM `users`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		row, err := it.Row()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if row["name"].ToString() != fmt.Sprintf("name%d", n) {
+			t.Errorf("unexpected row %v", row)
+		}
+	}
+	if it.Err() != nil || n != 5 || srv.closes != srv.prepares {
+		t.Errorf("unexpected %d %v, prepares %d, closes %d", n, it.Err(), srv.prepares, srv.closes)
+	}
+
+	//扫描到结构体，中途退出
+	type user struct {
+		ID   int64
+		Name string
+	}
+	it, err = db.IterateBuilder(ctx, Select().From("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	for it.Next() {
+		if err = it.Scan(&u); err != nil {
+			t.Fatal(err)
+		}
+		if u.ID == 2 {
+			break
+		}
+	}
+	it.Close()
+	it.Close()
+	if u.Name != "name2" || it.Next() || srv.closes != srv.prepares {
+		t.Errorf("unexpected %+v, prepares %d, closes %d", u, srv.prepares, srv.closes)
+	}
+	if err = it.Scan(u); err == nil {
+		t.Errorf("scan into non-pointer should fail")
+	}
+
+	//ctx取消后停止
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	it, err = db.Iterate(cctx, "SELECT * FROM `users`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = 0
+	for it.Next() {
+		if n++; n == 2 {
+			cancel()
+		}
+	}
+	if n != 2 || it.Err() != context.Canceled {
+		t.Errorf("unexpected %d %v", n, it.Err())
+	}
+
+	//fn返回错误时停止
+	n = 0
+	stop := fmt.Errorf("stop")
+	err = db.EachRow(ctx, "SELECT * FROM `users`", nil, func(row map[string]gItem.ItemElem) error {
+		if n++; n == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 3 {
+		t.Errorf("unexpected %d %v", n, err)
+	}
+}
+
+func TestDBase_WalkTable(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	//id为1..7的表，按参数返回下一批
+	srv.handler = func(query string, args []driver.Value) (fakeResult, bool) {
+		after := int64(0)
+		switch query {
+		case "SELECT `id`,`name` FROM `t` WHERE `status` = ? ORDER BY `id` ASC LIMIT 3":
+		case "SELECT `id`,`name` FROM `t` WHERE `status` = ? AND `id` > ? ORDER BY `id` ASC LIMIT 3":
+			after, _ = gItem.MakeItemElem(args[1]).ToInt64()
+		default:
+			return fakeResult{}, false
+		}
+		r := fakeResult{cols: []string{"id", "name"}}
+		for id := after + 1; id <= 7 && len(r.rows) < 3; id++ {
+			r.rows = append(r.rows, []driver.Value{id, "x"})
+		}
+		return r, true
+	}
+	var ids []string
+	batches := 0
+	err := db.WalkTable(ctx, "t", KeysetOptions{Fields: []string{"name"}, Cond: map[string]interface{}{"status": 1}, BatchSize: 3}, func(rows []map[string]gItem.ItemElem) error {
+		batches++
+		for _, r := range rows {
+			ids = append(ids, r["id"].ToString())
+		}
+		return nil
+	})
+	if err != nil || batches != 3 || fmt.Sprint(ids) != "[1 2 3 4 5 6 7]" {
+		t.Errorf("unexpected %d %v %v", batches, ids, err)
+	}
+	if q := srv.executed(); len(q) != 3 {
+		t.Errorf("unexpected queries %q", q)
+	}
+
+	//断点续传，刚好整批时多查一次空的
+	srv.reset()
+	ids = nil
+	db.WalkTable(ctx, "t", KeysetOptions{Fields: []string{"id", "name"}, Cond: map[string]interface{}{"status": 1}, BatchSize: 3, After: int64(1)}, func(rows []map[string]gItem.ItemElem) error {
+		for _, r := range rows {
+			ids = append(ids, r["id"].ToString())
+		}
+		return nil
+	})
+	if fmt.Sprint(ids) != "[2 3 4 5 6 7]" || len(srv.executed()) != 3 {
+		t.Errorf("unexpected %v %q", ids, srv.executed())
+	}
+
+	//倒序
+	srv.set("SELECT * FROM `t` ORDER BY `id` DESC LIMIT 1000", fakeResult{cols: []string{"id"}, rows: [][]driver.Value{{int64(2)}, {int64(1)}}})
+	batches = 0
+	if err = db.WalkTable(ctx, "t", KeysetOptions{Desc: true}, func(rows []map[string]gItem.ItemElem) error {
+		batches++
+		return nil
+	}); err != nil || batches != 1 {
+		t.Errorf("unexpected %d %v", batches, err)
+	}
+
+	//Key为NULL时报错，不会一直循环
+	srv.set("SELECT * FROM `t` ORDER BY `pid` ASC LIMIT 2", fakeResult{cols: []string{"pid"}, rows: [][]driver.Value{{nil}, {nil}}})
+	batches = 0
+	if err = db.WalkTable(ctx, "t", KeysetOptions{Key: "pid", BatchSize: 2}, func(rows []map[string]gItem.ItemElem) error {
+		batches++
+		return nil
+	}); err == nil || batches != 0 {
+		t.Errorf("unexpected %d %v", batches, err)
+	}
+}
@@ -0,0 +1,419 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//一个版本的变更，SQL和Go函数二选一，Go函数优先
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string //可以有多条语句，用";"分隔，存储过程、触发器等用DELIMITER换分隔符
+	Down     string
+	UpFunc   func(ctx context.Context, db *DBase) error
+	DownFunc func(ctx context.Context, db *DBase) error
+}
+
+func (m Migration) hasDown() bool {
+	return m.DownFunc != nil || len(strings.TrimSpace(m.Down)) > 0
+}
+
+//每个版本的状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool //已执行过，但找不到对应的变更文件
+}
+
+//数据库结构的版本管理，已执行的版本记在Table表里
+//执行时用GET_LOCK加锁，多个实例同时部署时只有一个在执行
+//注意MySQL的DDL会隐式提交，一个版本执行到一半失败时不会记录，要手动处理后再执行
+//
+//	m := NewMigrator(db)
+//	if err := m.LoadDir("./migrations"); err != nil {
+//		return err
+//	}
+//	applied, err := m.Up(ctx)
+type Migrator struct {
+	db          *DBase
+	Table       string        //记录版本的表，默认schema_migrations
+	LockTimeout time.Duration //等锁的时间，默认10秒
+	migrations  map[int64]Migration
+}
+
+func NewMigrator(db *DBase) *Migrator {
+	return &Migrator{
+		db:          db,
+		Table:       "schema_migrations",
+		LockTimeout: 10 * time.Second,
+		migrations:  make(map[int64]Migration),
+	}
+}
+
+//添加一个版本，版本号重复时报错
+func (m *Migrator) Add(mg Migration) error {
+	if mg.Version <= 0 {
+		return fmt.Errorf("invalid migration version %d", mg.Version)
+	}
+	if mg.UpFunc == nil && len(strings.TrimSpace(mg.Up)) <= 0 {
+		return fmt.Errorf("migration %d: empty up migration", mg.Version)
+	}
+	if _, ok := m.migrations[mg.Version]; ok {
+		return fmt.Errorf("duplicate migration version %d", mg.Version)
+	}
+	m.migrations[mg.Version] = mg
+	return nil
+}
+
+//变更文件的格式：<版本号>_<名称>.up.sql、<版本号>_<名称>.down.sql，如0001_create_users.up.sql
+var migrationFileReg = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+//从目录里读取变更文件
+func (m *Migrator) LoadDir(dir string) error {
+	return m.LoadFS(os.DirFS(dir), ".")
+}
+
+//从fs.FS里读取变更文件，可以用embed.FS把变更文件打包到程序里
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := migrationFileReg.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration file %s: %v", e.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		mg, ok := loaded[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			loaded[version] = mg
+		} else if mg.Name != match[2] {
+			return fmt.Errorf("migration %d has different names: %s, %s", version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.Up = string(content)
+		} else {
+			mg.Down = string(content)
+		}
+	}
+	for _, mg := range loaded {
+		if err = m.Add(*mg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//按版本号排序的所有变更
+func (m *Migrator) sorted() []Migration {
+	ret := make([]Migration, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		ret = append(ret, mg)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret
+}
+
+//执行所有未执行的版本，返回执行了的版本号
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	return m.To(ctx, -1)
+}
+
+//回滚最近执行的steps个版本，返回回滚了的版本号
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int64, error) {
+	if steps <= 0 {
+		return nil, nil
+	}
+	var done []int64
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]MigrationStatus) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if len(versions) > steps {
+			versions = versions[:steps]
+		}
+		for _, v := range versions {
+			if err := m.down(ctx, conn, v); err != nil {
+				return err
+			}
+			done = append(done, v)
+		}
+		return nil
+	})
+	return done, err
+}
+
+//执行或回滚到指定的版本：执行所有版本号<=version的，回滚所有>version的
+//version为-1时执行所有的，为0时全部回滚
+//返回执行或回滚了的版本号
+func (m *Migrator) To(ctx context.Context, version int64) ([]int64, error) {
+	var done []int64
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]MigrationStatus) error {
+		var down []int64
+		for v := range applied {
+			if version >= 0 && v > version {
+				down = append(down, v)
+			}
+		}
+		sort.Slice(down, func(i, j int) bool { return down[i] > down[j] })
+		for _, v := range down {
+			if err := m.down(ctx, conn, v); err != nil {
+				return err
+			}
+			done = append(done, v)
+		}
+		for _, mg := range m.sorted() {
+			if _, ok := applied[mg.Version]; ok || (version >= 0 && mg.Version > version) {
+				continue
+			}
+			if err := m.up(ctx, conn, mg); err != nil {
+				return err
+			}
+			done = append(done, mg.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+//所有版本的状态，按版本号排序
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var ret []MigrationStatus
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]MigrationStatus) error {
+		for _, mg := range m.sorted() {
+			st, ok := applied[mg.Version]
+			if !ok {
+				st = MigrationStatus{Version: mg.Version}
+			}
+			st.Name = mg.Name
+			ret = append(ret, st)
+		}
+		for v, st := range applied {
+			if _, ok := m.migrations[v]; !ok {
+				st.Missing = true
+				ret = append(ret, st)
+			}
+		}
+		return nil
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret, err
+}
+
+//加锁后执行fn，所有的语句都在加锁的连接上执行
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]MigrationStatus) error) (err error) {
+	if m.db.Db == nil {
+		return fmt.Errorf("not connect MySQL")
+	}
+	conn, err := m.db.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	lockName := "migrate:" + m.db.Conf.DbName + "." + m.Table
+	var got sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int64(m.LockTimeout/time.Second)).Scan(&got); err != nil {
+		return fmt.Errorf("get migration lock failed: %v", err)
+	}
+	if got.Int64 != 1 {
+		return fmt.Errorf("get migration lock failed: another migration is running")
+	}
+	defer func() {
+		//ctx取消了也要释放锁
+		var released sql.NullInt64
+		if rerr := conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName).Scan(&released); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+	if _, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteIdent(m.Table)+" ("+
+		"`version` BIGINT NOT NULL PRIMARY KEY,"+
+		"`name` VARCHAR(255) NOT NULL DEFAULT '',"+
+		"`applied_at` DATETIME NOT NULL"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"); err != nil {
+		return err
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+//已执行的版本
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT `version`,`name`,`applied_at` FROM "+quoteIdent(m.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loc := m.db.timeLocation()
+	ret := make(map[int64]MigrationStatus)
+	for rows.Next() {
+		var st MigrationStatus
+		//ParseTime为true时驱动返回的是time.Time，否则是字符串
+		at := timeScanner{dest: reflect.ValueOf(&st.AppliedAt).Elem(), loc: loc}
+		if err = rows.Scan(&st.Version, &st.Name, at); err != nil {
+			return nil, err
+		}
+		st.Applied = true
+		ret[st.Version] = st
+	}
+	return ret, rows.Err()
+}
+
+//执行一个版本并记录
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, mg Migration) error {
+	if m.db.IsDebug {
+		fmt.Printf("migrate up %d_%s\n", mg.Version, mg.Name)
+	}
+	if err := m.run(ctx, conn, mg.Up, mg.UpFunc); err != nil {
+		return fmt.Errorf("migrate up %d_%s failed: %v", mg.Version, mg.Name, err)
+	}
+	now := time.Now().In(m.db.timeLocation()).Format("2006-01-02 15:04:05")
+	_, err := conn.ExecContext(ctx, "INSERT INTO "+quoteIdent(m.Table)+" (`version`,`name`,`applied_at`) VALUES (?,?,?)", mg.Version, mg.Name, now)
+	return err
+}
+
+//回滚一个版本并删除记录
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, version int64) error {
+	mg, ok := m.migrations[version]
+	if !ok {
+		return fmt.Errorf("migrate down %d failed: migration not found", version)
+	}
+	if !mg.hasDown() {
+		return fmt.Errorf("migrate down %d_%s failed: no down migration", version, mg.Name)
+	}
+	if m.db.IsDebug {
+		fmt.Printf("migrate down %d_%s\n", mg.Version, mg.Name)
+	}
+	if err := m.run(ctx, conn, mg.Down, mg.DownFunc); err != nil {
+		return fmt.Errorf("migrate down %d_%s failed: %v", mg.Version, mg.Name, err)
+	}
+	_, err := conn.ExecContext(ctx, "DELETE FROM "+quoteIdent(m.Table)+" WHERE `version` = ?", version)
+	return err
+}
+
+//执行Go函数或SQL
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, s string, fn func(ctx context.Context, db *DBase) error) error {
+	if fn != nil {
+		return fn(ctx, m.db)
+	}
+	for _, stmt := range SplitSQL(s) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%v, SQL: %s", err, stmt)
+		}
+	}
+	return nil
+}
+
+//"--"后面要跟空白字符才是注释
+func isLineComment(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return len(s) == 2 || s[2] == ' ' || s[2] == '\t' || s[2] == '\n' || s[2] == '\r'
+}
+
+//把多条SQL按";"切开，忽略引号、注释里的";"，去掉注释和空语句
+//"/*!50001 ... */"这样的条件注释原样保留
+//同mysql客户端，语句开头单独一行的"DELIMITER $$"把分隔符换成"$$"，用于存储过程、触发器里有";"的语句
+func SplitSQL(s string) []string {
+	var ret []string
+	var buf strings.Builder
+	delim := ";"
+	flush := func() {
+		if stmt := strings.TrimSpace(buf.String()); len(stmt) > 0 {
+			ret = append(ret, stmt)
+		}
+		buf.Reset()
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case (i == 0 || s[i-1] == '\n') && len(strings.TrimSpace(buf.String())) <= 0 && isDelimiterCmd(s[i:]):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			if f := strings.Fields(s[i : i+end]); len(f) >= 2 {
+				delim = f[1]
+			}
+			buf.Reset()
+			i += end
+		case c == '\'' || c == '"' || c == '`':
+			//引号里的原样保留，反斜杠转义下一个字符
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == '\\' && c != '`' {
+					j++
+				} else if s[j] == c {
+					break
+				}
+			}
+			if j >= len(s) {
+				j = len(s) - 1
+			}
+			buf.WriteString(s[i : j+1])
+			i = j
+		case strings.HasPrefix(s[i:], delim):
+			flush()
+			i += len(delim) - 1
+		case c == '#' || (c == '-' && isLineComment(s[i:])):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			buf.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(s[i:], "/*") && !strings.HasPrefix(s[i:], "/*!"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end + 3
+			}
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return ret
+}
+
+//是否为"DELIMITER xx"，前面可以有空白
+func isDelimiterCmd(s string) bool {
+	s = strings.TrimLeft(s, " \t")
+	return len(s) > 10 && strings.EqualFold(s[:9], "DELIMITER") && (s[9] == ' ' || s[9] == '\t')
+}
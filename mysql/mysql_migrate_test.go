@@ -0,0 +1,169 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplitSQL(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	s := "-- 建表\nCREATE TABLE `a;b` (`id` INT COMMENT 'x;\\'y');\n" +
+		"# 注释;\nINSERT INTO t VALUES (\"1;2\") /* c;d */ ;;\n" +
+		"/*!40101 SET NAMES utf8 */;\nSELECT 1--1"
+	want := []string{
+		"CREATE TABLE `a;b` (`id` INT COMMENT 'x;\\'y')",
+		"INSERT INTO t VALUES (\"1;2\")",
+		"/*!40101 SET NAMES utf8 */",
+		"SELECT 1--1",
+	}
+	if got := SplitSQL(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	//存储过程、触发器用DELIMITER换分隔符
+	s = "DROP TRIGGER IF EXISTS t_ai;\ndelimiter $$\nCREATE TRIGGER t_ai AFTER INSERT ON t FOR EACH ROW BEGIN\n" +
+		"  INSERT INTO log VALUES (NEW.id);\n  UPDATE cnt SET n = n + 1;\nEND$$\nDELIMITER ;\nCREATE TABLE t2 (\n  delimiter INT\n);"
+	want = []string{
+		"DROP TRIGGER IF EXISTS t_ai",
+		"CREATE TRIGGER t_ai AFTER INSERT ON t FOR EACH ROW BEGIN\n  INSERT INTO log VALUES (NEW.id);\n  UPDATE cnt SET n = n + 1;\nEND",
+		"CREATE TABLE t2 (\n  delimiter INT\n)",
+	}
+	if got := SplitSQL(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+//假的版本表
+func fakeMigrationTable(srv *fakeServer, applied map[int64]string) {
+	srv.handler = func(query string, args []driver.Value) (fakeResult, bool) {
+		switch {
+		case strings.HasPrefix(query, "SELECT GET_LOCK"), strings.HasPrefix(query, "SELECT RELEASE_LOCK"):
+			return fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(1)}}}, true
+		case query == "SELECT `version`,`name`,`applied_at` FROM `schema_migrations`":
+			r := fakeResult{cols: []string{"version", "name", "applied_at"}}
+			for v, name := range applied {
+				//同ParseTime为true时驱动返回的time.Time
+				r.rows = append(r.rows, []driver.Value{v, name, time.Date(2018, 1, 25, 19, 19, 0, 0, time.UTC)})
+			}
+			return r, true
+		case strings.HasPrefix(query, "INSERT INTO `schema_migrations`"):
+			v, _ := gItem.MakeItemElem(args[0]).ToInt64()
+			applied[v] = args[1].(string)
+		case strings.HasPrefix(query, "DELETE FROM `schema_migrations`"):
+			v, _ := gItem.MakeItemElem(args[0]).ToInt64()
+			delete(applied, v)
+		}
+		return fakeResult{}, false
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+	applied := map[int64]string{}
+	fakeMigrationTable(srv, applied)
+
+	m := NewMigrator(db)
+	err := m.LoadFS(fstest.MapFS{
+		"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE `users` (`id` INT);\nCREATE INDEX idx ON `users` (`id`);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE `users`;")},
+		"migrations/0003_add_name.up.sql":       {Data: []byte("ALTER TABLE `users` ADD `name` VARCHAR(32)")},
+		"migrations/README.md":                  {Data: []byte("ignored")},
+	}, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goCalled := 0
+	m.Add(Migration{Version: 2, Name: "seed", UpFunc: func(ctx context.Context, db *DBase) error {
+		goCalled++
+		return nil
+	}, DownFunc: func(ctx context.Context, db *DBase) error {
+		goCalled--
+		return nil
+	}})
+	if err = m.Add(Migration{Version: 2, Up: "SELECT 1"}); err == nil {
+		t.Errorf("duplicate version should fail")
+	}
+
+	//执行到版本2
+	done, err := m.To(ctx, 2)
+	if err != nil || !reflect.DeepEqual(done, []int64{1, 2}) || goCalled != 1 {
+		t.Fatalf("unexpected %v %v", done, err)
+	}
+	var ddl []string
+	for _, q := range srv.executed() {
+		if strings.HasPrefix(q, "CREATE INDEX") || strings.HasPrefix(q, "CREATE TABLE `users`") || strings.HasPrefix(q, "ALTER") {
+			ddl = append(ddl, q)
+		}
+	}
+	if len(ddl) != 2 {
+		t.Errorf("unexpected ddl %q", ddl)
+	}
+
+	//状态
+	st, err := m.Status(ctx)
+	if err != nil || len(st) != 3 || !st[0].Applied || st[0].AppliedAt.Year() != 2018 || st[1].Name != "seed" || st[2].Applied {
+		t.Errorf("unexpected status %+v %v", st, err)
+	}
+
+	//执行剩下的
+	if done, err = m.Up(ctx); err != nil || !reflect.DeepEqual(done, []int64{3}) {
+		t.Errorf("unexpected %v %v", done, err)
+	}
+	if done, err = m.Up(ctx); err != nil || len(done) != 0 {
+		t.Errorf("nothing to do: %v %v", done, err)
+	}
+
+	//版本3没有down
+	if done, err = m.Down(ctx, 1); err == nil || len(done) != 0 {
+		t.Errorf("down without down sql should fail: %v %v", done, err)
+	}
+	delete(applied, 3)
+	if done, err = m.To(ctx, 0); err != nil || !reflect.DeepEqual(done, []int64{2, 1}) || goCalled != 0 {
+		t.Errorf("unexpected %v %v", done, err)
+	}
+	if q := srv.executed(); q[len(q)-3] != "DROP TABLE `users`" {
+		t.Errorf("unexpected %q", q[len(q)-3:])
+	}
+
+	//找不到文件的版本
+	applied[9] = "gone"
+	st, _ = m.Status(ctx)
+	if last := st[len(st)-1]; last.Version != 9 || !last.Missing {
+		t.Errorf("unexpected %+v", last)
+	}
+	var versions []int64
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	if !reflect.DeepEqual(versions, []int64{9}) {
+		t.Errorf("unexpected applied %v", versions)
+	}
+
+	//锁被别人拿着
+	srv.handler = func(query string, args []driver.Value) (fakeResult, bool) {
+		if strings.HasPrefix(query, "SELECT GET_LOCK") {
+			return fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(0)}}}, true
+		}
+		return fakeResult{}, false
+	}
+	if _, err = m.Up(ctx); err == nil || !strings.Contains(err.Error(), "another migration") {
+		t.Errorf("expected lock error, got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"time"
+)
+
+//带版本号的更新没有更新到数据，即数据已经被别人改过了，或者不存在、已删除
+type ErrConcurrentModification struct {
+	Table   string
+	ID      interface{}
+	Version int64
+}
+
+func (e *ErrConcurrentModification) Error() string {
+	return fmt.Sprintf("concurrent modification: %s %v version %d was changed or deleted", e.Table, e.ID, e.Version)
+}
+
+//一张表的约定：主键、乐观锁的版本号、自动维护的创建/更新时间、软删除
+//查询时自动过滤掉软删除的数据，在事务里用WithDB(tx)
+//
+//	users := NewTableModel(db, "user").Versioned("version").Timestamps("created_at", "updated_at").SoftDelete("deleted_at")
+//	id, err := users.Insert(ctx, map[string]interface{}{"name": "wendao"})
+//	row, err := users.Get(ctx, id)
+//	ver, _ := row["version"].ToInt64()
+//	ver, err = users.UpdateWithVersion(ctx, id, ver, map[string]interface{}{"name": "abc"})
+//	var e *ErrConcurrentModification
+//	if errors.As(err, &e) {
+//		//被别人改过了，重新读出来再改
+//	}
+type TableModel struct {
+	db           DB
+	table        string
+	pk           string
+	versionField string
+	createdField string
+	updatedField string
+	deletedField string
+	unscoped     bool
+	now          func() time.Time
+}
+
+//默认主键为id，没有版本号、时间字段及软删除
+func NewTableModel(db DB, table string) *TableModel {
+	return &TableModel{db: db, table: table, pk: "id", now: time.Now}
+}
+
+func (m *TableModel) clone() *TableModel {
+	c := *m
+	return &c
+}
+
+//主键字段
+func (m *TableModel) PrimaryKey(field string) *TableModel {
+	c := m.clone()
+	c.pk = field
+	return c
+}
+
+//乐观锁的版本号字段，整数，写入时为1，每次更新加1
+func (m *TableModel) Versioned(field string) *TableModel {
+	c := m.clone()
+	c.versionField = field
+	return c
+}
+
+//写入时自动设置created、updated，更新时自动设置updated，为空的不设置
+func (m *TableModel) Timestamps(created, updated string) *TableModel {
+	c := m.clone()
+	c.createdField = created
+	c.updatedField = updated
+	return c
+}
+
+//软删除的字段，DATETIME且可以为NULL，为NULL表示没删除
+func (m *TableModel) SoftDelete(field string) *TableModel {
+	c := m.clone()
+	c.deletedField = field
+	return c
+}
+
+//在别的DB上执行，如事务里的tx
+func (m *TableModel) WithDB(db DB) *TableModel {
+	c := m.clone()
+	c.db = db
+	return c
+}
+
+//查询时包括软删除的数据
+func (m *TableModel) Unscoped() *TableModel {
+	c := m.clone()
+	c.unscoped = true
+	return c
+}
+
+//设置取当前时间的函数，测试用
+func (m *TableModel) SetNow(now func() time.Time) *TableModel {
+	c := m.clone()
+	c.now = now
+	return c
+}
+
+//表名
+func (m *TableModel) Table() string {
+	return m.table
+}
+
+//过滤掉软删除的条件
+func (m *TableModel) scope(conds ...Cond) []Cond {
+	if len(m.deletedField) > 0 && !m.unscoped {
+		conds = append(conds, IsNull(m.deletedField))
+	}
+	return conds
+}
+
+//查询语句，已经加上了过滤软删除的条件，可以再加条件、排序等
+func (m *TableModel) Select(fields ...string) *SQLBuilder {
+	return Select(fields...).From(m.table).Where(m.scope()...)
+}
+
+//按主键提取一行，没有时返回nil
+func (m *TableModel) Get(ctx context.Context, id interface{}, fields ...string) (map[string]gItem.ItemElem, error) {
+	rows, err := m.db.FetchRowsBuilderContext(ctx, m.Select(fields...).Where(Eq(m.pk, id)).Limit(1))
+	if err != nil || len(rows) <= 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+//按主键提取一行到结构体里，没有时返回sql.ErrNoRows
+func (m *TableModel) GetStruct(ctx context.Context, dest interface{}, id interface{}) error {
+	fsql, args, err := m.Select().Where(Eq(m.pk, id)).Limit(1).Build()
+	if err != nil {
+		return err
+	}
+	return m.db.FetchStructContext(ctx, dest, fsql, args...)
+}
+
+//按条件提取多行，cond同FormatCond
+func (m *TableModel) Find(ctx context.Context, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error) {
+	return m.db.FetchRowsBuilderContext(ctx, m.Select(fields...).Where(MapConds(cond)...))
+}
+
+//按条件计数
+func (m *TableModel) Count(ctx context.Context, cond map[string]interface{}) (int64, error) {
+	fsql, args, err := m.Select().SelectExpr(Expr("COUNT(*)")).Where(MapConds(cond)...).Build()
+	if err != nil {
+		return 0, err
+	}
+	r, err := m.db.FetchOneContext(ctx, fsql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return r.ToInt64()
+}
+
+//写入一条数据，返回lastInsertId，没有给出的版本号、创建/更新时间自动设置
+func (m *TableModel) Insert(ctx context.Context, data map[string]interface{}) (int64, error) {
+	row := make(map[string]interface{}, len(data)+3)
+	for k, v := range data {
+		row[k] = v
+	}
+	now := m.now()
+	for _, f := range []string{m.createdField, m.updatedField} {
+		if _, ok := row[f]; len(f) > 0 && !ok {
+			row[f] = now
+		}
+	}
+	if _, ok := row[m.versionField]; len(m.versionField) > 0 && !ok {
+		row[m.versionField] = 1
+	}
+	id, _, err := m.db.InsertDataContext(ctx, m.table, row, false)
+	return id, err
+}
+
+//更新用的builder，设置更新时间、版本号加1
+func (m *TableModel) updater(data map[string]interface{}) (*SQLBuilder, error) {
+	if len(data) <= 0 {
+		return nil, fmt.Errorf("empty update data")
+	}
+	b := Update(m.table)
+	for _, k := range sortedKeys(data) {
+		if k == m.pk || (len(m.versionField) > 0 && k == m.versionField) {
+			return nil, fmt.Errorf("can not update field %s", k)
+		}
+		b.Set(k, data[k])
+	}
+	if _, ok := data[m.updatedField]; len(m.updatedField) > 0 && !ok {
+		b.Set(m.updatedField, m.now())
+	}
+	if len(m.versionField) > 0 {
+		b.Set(m.versionField, Expr(quoteIdent(m.versionField)+" + 1"))
+	}
+	return b, nil
+}
+
+//按主键更新，不检查版本号，返回影响行数
+func (m *TableModel) Update(ctx context.Context, id interface{}, data map[string]interface{}) (int64, error) {
+	b, err := m.updater(data)
+	if err != nil {
+		return 0, err
+	}
+	n, _, err := m.db.ExecuteBuilderContext(ctx, b.Where(m.scope(Eq(m.pk, id))...))
+	return n, err
+}
+
+//按主键及版本号更新，返回新的版本号
+//版本号对不上（被别人改过了）、数据不存在或已删除时返回*ErrConcurrentModification
+func (m *TableModel) UpdateWithVersion(ctx context.Context, id interface{}, version int64, data map[string]interface{}) (int64, error) {
+	if len(m.versionField) <= 0 {
+		return 0, fmt.Errorf("table %s has no version field", m.table)
+	}
+	b, err := m.updater(data)
+	if err != nil {
+		return 0, err
+	}
+	n, _, err := m.db.ExecuteBuilderContext(ctx, b.Where(m.scope(Eq(m.pk, id), Eq(m.versionField, version))...))
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, &ErrConcurrentModification{Table: m.table, ID: id, Version: version}
+	}
+	return version + 1, nil
+}
+
+//按主键删除，有软删除字段时只设置删除时间，返回影响行数
+func (m *TableModel) Delete(ctx context.Context, id interface{}) (int64, error) {
+	if len(m.deletedField) <= 0 {
+		return m.ForceDelete(ctx, id)
+	}
+	now := m.now()
+	b := Update(m.table).Set(m.deletedField, now)
+	if len(m.updatedField) > 0 {
+		b.Set(m.updatedField, now)
+	}
+	if len(m.versionField) > 0 {
+		b.Set(m.versionField, Expr(quoteIdent(m.versionField)+" + 1"))
+	}
+	n, _, err := m.db.ExecuteBuilderContext(ctx, b.Where(Eq(m.pk, id), IsNull(m.deletedField)))
+	return n, err
+}
+
+//恢复软删除的数据，返回影响行数
+func (m *TableModel) Restore(ctx context.Context, id interface{}) (int64, error) {
+	if len(m.deletedField) <= 0 {
+		return 0, fmt.Errorf("table %s has no soft delete field", m.table)
+	}
+	b := Update(m.table).Set(m.deletedField, nil)
+	if len(m.updatedField) > 0 {
+		b.Set(m.updatedField, m.now())
+	}
+	if len(m.versionField) > 0 {
+		b.Set(m.versionField, Expr(quoteIdent(m.versionField)+" + 1"))
+	}
+	n, _, err := m.db.ExecuteBuilderContext(ctx, b.Where(Eq(m.pk, id), IsNotNull(m.deletedField)))
+	return n, err
+}
+
+//按主键真正删除，不管有没有软删除字段
+func (m *TableModel) ForceDelete(ctx context.Context, id interface{}) (int64, error) {
+	n, _, err := m.db.ExecuteBuilderContext(ctx, Delete(m.table).Where(Eq(m.pk, id)))
+	return n, err
+}
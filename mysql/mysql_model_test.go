@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTableModel(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	ctx := context.Background()
+	now := time.Date(2018, 1, 25, 19, 19, 0, 0, time.UTC)
+	users := NewTableModel(db, "user").Versioned("version").Timestamps("created_at", "updated_at").
+		SoftDelete("deleted_at").SetNow(func() time.Time { return now })
+
+	//版本号为3时才能更新成功
+	var lastArgs []driver.Value
+	srv.handler = func(query string, args []driver.Value) (fakeResult, bool) {
+		lastArgs = args
+		switch {
+		case strings.HasPrefix(query, "SELECT COUNT(*)"):
+			return fakeResult{cols: []string{"COUNT(*)"}, rows: [][]driver.Value{{int64(2)}}}, true
+		case strings.HasPrefix(query, "SELECT"):
+			return fakeResult{cols: []string{"id", "name", "version"}, rows: [][]driver.Value{{int64(1), "wendao", int64(3)}}}, true
+		case strings.HasPrefix(query, "INSERT"):
+			return fakeResult{affected: 1, lastID: 9}, true
+		case strings.Contains(query, "`version` = ?") && args[len(args)-1] != int64(3):
+			return fakeResult{}, true
+		}
+		return fakeResult{}, false
+	}
+
+	id, err := users.Insert(ctx, map[string]interface{}{"name": "wendao"})
+	if err != nil || id != 9 {
+		t.Fatal(id, err)
+	}
+	q := srv.executed()
+	if last := q[len(q)-1]; last != "INSERT INTO `user` (`created_at`,`name`,`updated_at`,`version`) VALUES (?,?,?,?)" ||
+		!reflect.DeepEqual(lastArgs, []driver.Value{now, "wendao", now, int64(1)}) {
+		t.Errorf("unexpected %s %v", last, lastArgs)
+	}
+
+	//查询都过滤掉软删除的
+	srv.reset()
+	row, err := users.Get(ctx, 1)
+	if err != nil || row["name"].ToString() != "wendao" {
+		t.Fatal(row, err)
+	}
+	users.Find(ctx, map[string]interface{}{"name": "wendao"}, "id", "name")
+	n, err := users.Count(ctx, map[string]interface{}{"version:gt": 1})
+	if err != nil || n != 2 {
+		t.Errorf("unexpected count %d %v", n, err)
+	}
+	users.Unscoped().Get(ctx, 1)
+	want := []string{
+		"SELECT * FROM `user` WHERE `deleted_at` IS NULL AND `id` = ? LIMIT 1",
+		"SELECT `id`,`name` FROM `user` WHERE `deleted_at` IS NULL AND `name` = ?",
+		"SELECT COUNT(*) FROM `user` WHERE `deleted_at` IS NULL AND `version` > ?",
+		"SELECT * FROM `user` WHERE `id` = ? LIMIT 1",
+	}
+	if got := srv.executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	//乐观锁
+	srv.reset()
+	ver, err := users.UpdateWithVersion(ctx, 1, 3, map[string]interface{}{"name": "abc"})
+	if err != nil || ver != 4 {
+		t.Fatal(ver, err)
+	}
+	if q := srv.executed(); q[0] != "UPDATE `user` SET `name` = ?,`updated_at` = ?,`version` = `version` + 1 WHERE `id` = ? AND `version` = ? AND `deleted_at` IS NULL" {
+		t.Errorf("unexpected %s", q[0])
+	}
+	_, err = users.UpdateWithVersion(ctx, 1, 2, map[string]interface{}{"name": "abc"})
+	var ce *ErrConcurrentModification
+	if !errors.As(err, &ce) || ce.Table != "user" || ce.ID != 1 || ce.Version != 2 {
+		t.Errorf("expected ErrConcurrentModification, got %v", err)
+	}
+	if _, err = users.Update(ctx, 1, map[string]interface{}{"version": 8}); err == nil {
+		t.Errorf("update version field should fail")
+	}
+	if _, err = NewTableModel(db, "log").UpdateWithVersion(ctx, 1, 1, map[string]interface{}{"a": 1}); err == nil {
+		t.Errorf("no version field should fail")
+	}
+
+	//软删除、恢复、真正删除
+	srv.reset()
+	users.Delete(ctx, 1)
+	users.Restore(ctx, 1)
+	users.ForceDelete(ctx, 1)
+	NewTableModel(db, "log").PrimaryKey("log_id").Delete(ctx, 5)
+	want = []string{
+		"UPDATE `user` SET `deleted_at` = ?,`updated_at` = ?,`version` = `version` + 1 WHERE `id` = ? AND `deleted_at` IS NULL",
+		"UPDATE `user` SET `deleted_at` = ?,`updated_at` = ?,`version` = `version` + 1 WHERE `id` = ? AND `deleted_at` IS NOT NULL",
+		"DELETE FROM `user` WHERE `id` = ?",
+		"DELETE FROM `log` WHERE `log_id` = ?",
+	}
+	if got := srv.executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	//在事务里
+	err = db.WithTx(ctx, func(tx *TxBase) error {
+		_, err := users.WithDB(tx).Update(ctx, 1, map[string]interface{}{"name": "x"})
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,511 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//分表策略，根据分表字段的值算出是第几张表，tables为分表的总数
+type ShardStrategy interface {
+	Locate(key gItem.ItemElem, tables int) (int, error)
+}
+
+//取模分表，整数按值取模，其他的按crc32取模
+type ModShard struct{}
+
+func (ModShard) Locate(key gItem.ItemElem, tables int) (int, error) {
+	if n, err := key.ToInt64(); err == nil {
+		if n < 0 {
+			n = -n
+		}
+		return int(n % int64(tables)), nil
+	}
+	return int(crc32.ChecksumIEEE([]byte(key.ToString())) % uint32(tables)), nil
+}
+
+//按范围分表，Bounds为每张表的上界（不包含），要从小到大
+//如Bounds为[1000000, 2000000]时，小于100万的在第0张表，100万到200万的在第1张表，其余的在第2张表
+type RangeShard struct {
+	Bounds []int64
+}
+
+func (r RangeShard) Locate(key gItem.ItemElem, tables int) (int, error) {
+	n, err := key.ToInt64()
+	if err != nil {
+		return 0, fmt.Errorf("range shard key must be integer: %v", err)
+	}
+	idx := sort.Search(len(r.Bounds), func(i int) bool { return n < r.Bounds[i] })
+	if idx >= tables {
+		return 0, fmt.Errorf("shard key %d out of range", n)
+	}
+	return idx, nil
+}
+
+//一致性hash分表，增减分表时只有少量数据要迁移
+type HashShard struct {
+	VirtualNodes int //每张表的虚拟节点数，默认160
+
+	rings sync.Map //分表数 => *hashRing
+}
+
+//hash环
+type hashRing struct {
+	points []uint32
+	owners map[uint32]int
+}
+
+func (h *HashShard) Locate(key gItem.ItemElem, tables int) (int, error) {
+	r, ok := h.rings.Load(tables)
+	if !ok {
+		r, _ = h.rings.LoadOrStore(tables, h.build(tables))
+	}
+	ring := r.(*hashRing)
+	sum := crc32.ChecksumIEEE([]byte(key.ToString()))
+	idx := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= sum })
+	if idx >= len(ring.points) {
+		idx = 0
+	}
+	return ring.owners[ring.points[idx]], nil
+}
+
+//生成hash环
+func (h *HashShard) build(tables int) *hashRing {
+	vnodes := h.VirtualNodes
+	if vnodes <= 0 {
+		vnodes = 160
+	}
+	ring := &hashRing{owners: make(map[uint32]int, tables*vnodes)}
+	for t := 0; t < tables; t++ {
+		for v := 0; v < vnodes; v++ {
+			sum := crc32.ChecksumIEEE([]byte(strconv.Itoa(t) + "#" + strconv.Itoa(v)))
+			if _, ok := ring.owners[sum]; ok {
+				continue
+			}
+			ring.owners[sum] = t
+			ring.points = append(ring.points, sum)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+//一张逻辑表的分表配置
+//分表按顺序平均分到DBs上，如64张表4个库时，每个库依次16张表
+type ShardTable struct {
+	Name     string        //逻辑表名，如user
+	Key      string        //分表字段，如uid
+	Tables   int           //分表数，如64
+	Format   string        //物理表名的格式，默认"%s_%02d"，生成user_00..user_63
+	Strategy ShardStrategy //分表策略，默认ModShard
+	DBs      []*DBase      //分表所在的库
+}
+
+//物理表名
+func (st *ShardTable) tableName(idx int) string {
+	return fmt.Sprintf(st.Format, st.Name, idx)
+}
+
+//物理表所在的库
+func (st *ShardTable) db(idx int) *DBase {
+	return st.DBs[idx*len(st.DBs)/st.Tables]
+}
+
+//分库分表，条件里有分表字段时只查对应的表，没有时查所有的分表再合并结果
+type DBShard struct {
+	tables map[string]*ShardTable
+}
+
+//一张物理表
+type shardTarget struct {
+	db    *DBase
+	table string
+	idx   int
+}
+
+func NewDBShard(tables ...*ShardTable) (*DBShard, error) {
+	s := &DBShard{tables: make(map[string]*ShardTable)}
+	for _, st := range tables {
+		if len(st.Name) <= 0 || len(st.Key) <= 0 {
+			return nil, fmt.Errorf("shard table name and key are required")
+		}
+		if st.Tables <= 0 {
+			return nil, fmt.Errorf("shard table %s: invalid table count %d", st.Name, st.Tables)
+		}
+		if len(st.DBs) <= 0 || len(st.DBs) > st.Tables {
+			return nil, fmt.Errorf("shard table %s: invalid db count %d", st.Name, len(st.DBs))
+		}
+		if _, ok := s.tables[st.Name]; ok {
+			return nil, fmt.Errorf("duplicate shard table %s", st.Name)
+		}
+		if len(st.Format) <= 0 {
+			st.Format = "%s_%02d"
+		}
+		if st.Strategy == nil {
+			st.Strategy = ModShard{}
+		}
+		s.tables[st.Name] = st
+	}
+	return s, nil
+}
+
+//分表配置
+func (s *DBShard) shardTable(table string) (*ShardTable, error) {
+	st, ok := s.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown shard table %s", table)
+	}
+	return st, nil
+}
+
+//根据分表字段的值找到对应的库和物理表名
+func (s *DBShard) Locate(table string, key interface{}) (*DBase, string, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := st.locate(gItem.MakeItemElem(key))
+	if err != nil {
+		return nil, "", err
+	}
+	return t.db, t.table, nil
+}
+
+func (st *ShardTable) locate(key gItem.ItemElem) (shardTarget, error) {
+	idx, err := st.Strategy.Locate(key, st.Tables)
+	if err != nil {
+		return shardTarget{}, err
+	}
+	if idx < 0 || idx >= st.Tables {
+		return shardTarget{}, fmt.Errorf("shard table %s: invalid shard %d", st.Name, idx)
+	}
+	return shardTarget{db: st.db(idx), table: st.tableName(idx), idx: idx}, nil
+}
+
+//所有的物理表
+func (st *ShardTable) all() []shardTarget {
+	ret := make([]shardTarget, st.Tables)
+	for i := range ret {
+		ret[i] = shardTarget{db: st.db(i), table: st.tableName(i), idx: i}
+	}
+	return ret
+}
+
+//条件里分表字段的值，支持"uid"、"uid:eq"、"uid:in"，没有时返回false
+func (st *ShardTable) keyValues(cond map[string]interface{}) ([]gItem.ItemElem, bool) {
+	for k, v := range cond {
+		field, op := k, "eq"
+		if i := strings.Index(k, ":"); i > 0 {
+			field, op = k[:i], k[i+1:]
+		}
+		if field != st.Key {
+			continue
+		}
+		ie := gItem.MakeItemElem(v)
+		switch op {
+		case "eq":
+			if ie.IsSimpleType() {
+				return []gItem.ItemElem{ie}, true
+			}
+		case "in":
+			var vals []gItem.ItemElem
+			if ie.IsString() {
+				for _, t := range strings.Split(ie.ToString(), ",") {
+					if len(t) > 0 {
+						vals = append(vals, gItem.MakeItemElem(t))
+					}
+				}
+			} else if ie.IsSimpleType() {
+				vals = append(vals, ie)
+			} else if tos, err := ie.ToSlice(); err == nil {
+				vals = tos
+			}
+			if len(vals) > 0 {
+				return vals, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//条件对应的物理表，没有分表字段时为所有的分表
+func (st *ShardTable) route(cond map[string]interface{}) ([]shardTarget, error) {
+	keys, ok := st.keyValues(cond)
+	if !ok {
+		return st.all(), nil
+	}
+	seen := make(map[int]bool)
+	var ret []shardTarget
+	for _, k := range keys {
+		t, err := st.locate(k)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t.idx] {
+			seen[t.idx] = true
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].idx < ret[j].idx })
+	return ret, nil
+}
+
+//写操作的物理表，必须指定唯一的分表字段
+func (st *ShardTable) routeOne(cond map[string]interface{}) (shardTarget, error) {
+	keys, ok := st.keyValues(cond)
+	if !ok || len(keys) != 1 {
+		return shardTarget{}, fmt.Errorf("shard table %s: a single value of shard key %s is required", st.Name, st.Key)
+	}
+	return st.locate(keys[0])
+}
+
+//分表查询
+type ShardQuery struct {
+	Fields  []string               //字段，默认"*"
+	Cond    map[string]interface{} //条件，同FormatCond
+	OrderBy []string               //排序，如"id DESC"，查多张表时字段要在结果里
+	Limit   int                    //条数，0为不限制
+	Offset  int                    //偏移量
+}
+
+//按条件提取多行数据，查多张表时按表的顺序合并
+func (s *DBShard) FetchCondRows(table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error) {
+	return s.FetchCondRowsContext(context.Background(), table, cond, fields...)
+}
+
+//按条件提取多行数据，可以用ctx取消
+func (s *DBShard) FetchCondRowsContext(ctx context.Context, table string, cond map[string]interface{}, fields ...string) ([]map[string]gItem.ItemElem, error) {
+	return s.Query(ctx, table, ShardQuery{Fields: fields, Cond: cond})
+}
+
+//分表查询，只有一张表时直接在这张表上排序分页
+//多张表时每张表取前Offset+Limit条，合并后再排序分页
+func (s *DBShard) Query(ctx context.Context, table string, q ShardQuery) ([]map[string]gItem.ItemElem, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := st.route(q.Cond)
+	if err != nil {
+		return nil, err
+	}
+	build := func(t shardTarget) *SQLBuilder {
+		return Select(q.Fields...).From(t.table).Where(MapConds(q.Cond)...).OrderBy(q.OrderBy...)
+	}
+	if len(targets) == 1 {
+		b := build(targets[0])
+		if q.Limit > 0 {
+			b.Limit(q.Limit)
+		}
+		if q.Offset > 0 {
+			b.Offset(q.Offset)
+		}
+		return targets[0].db.FetchRowsBuilderContext(ctx, b)
+	}
+	results := make([][]map[string]gItem.ItemElem, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t shardTarget) {
+			defer wg.Done()
+			b := build(t)
+			if q.Limit > 0 {
+				b.Limit(q.Offset + q.Limit)
+			}
+			results[i], errs[i] = t.db.FetchRowsBuilderContext(ctx, b)
+		}(i, t)
+	}
+	wg.Wait()
+	var ret []map[string]gItem.ItemElem
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("query %s failed: %v", targets[i].table, err)
+		}
+		ret = append(ret, results[i]...)
+	}
+	if len(q.OrderBy) > 0 {
+		orders := parseOrders(q.OrderBy)
+		sort.SliceStable(ret, func(i, j int) bool {
+			for _, o := range orders {
+				c := compareItem(ret[i][o.field], ret[j][o.field])
+				if c != 0 {
+					return (c < 0) != o.desc
+				}
+			}
+			return false
+		})
+	}
+	if q.Offset > 0 {
+		if q.Offset >= len(ret) {
+			return nil, nil
+		}
+		ret = ret[q.Offset:]
+	}
+	if q.Limit > 0 && len(ret) > q.Limit {
+		ret = ret[:q.Limit]
+	}
+	return ret, nil
+}
+
+type shardOrder struct {
+	field string
+	desc  bool
+}
+
+//解析"id DESC"、"t.id"这样的排序字段，合并结果时用
+func parseOrders(orderBy []string) []shardOrder {
+	var ret []shardOrder
+	for _, o := range orderBy {
+		fs := strings.Fields(o)
+		if len(fs) <= 0 {
+			continue
+		}
+		field := strings.Trim(fs[0], "`")
+		if i := strings.LastIndex(field, "."); i >= 0 {
+			field = strings.Trim(field[i+1:], "`")
+		}
+		ret = append(ret, shardOrder{field: field, desc: len(fs) > 1 && strings.EqualFold(fs[1], "DESC")})
+	}
+	return ret
+}
+
+//比较两个值，都是数字时按数字比较，否则按字符串比较，NULL最小
+func compareItem(a, b gItem.ItemElem) int {
+	an, bn := a.RawData() == nil, b.RawData() == nil
+	if an || bn {
+		switch {
+		case an && bn:
+			return 0
+		case an:
+			return -1
+		default:
+			return 1
+		}
+	}
+	af, aerr := a.ToFloat64()
+	bf, berr := b.ToFloat64()
+	if aerr == nil && berr == nil {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a.ToString(), b.ToString())
+}
+
+//写入一条数据，data里必须有分表字段
+func (s *DBShard) InsertData(table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
+	return s.InsertDataContext(context.Background(), table, data, isIgnore)
+}
+
+//写入一条数据，可以用ctx取消
+func (s *DBShard) InsertDataContext(ctx context.Context, table string, data map[string]interface{}, isIgnore bool) (int64, bool, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return 0, false, err
+	}
+	t, err := st.routeOne(map[string]interface{}{st.Key: data[st.Key]})
+	if err != nil {
+		return 0, false, err
+	}
+	return t.db.InsertDataContext(ctx, t.table, data, isIgnore)
+}
+
+//批量写入，按分表字段分组后写到各自的表里，返回总的影响行数
+func (s *DBShard) InsertBatchData(table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
+	return s.InsertBatchDataContext(context.Background(), table, fields, data, isIgnore)
+}
+
+//批量写入，可以用ctx取消
+func (s *DBShard) InsertBatchDataContext(ctx context.Context, table string, fields []string, data [][]interface{}, isIgnore bool) (int64, bool, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return 0, false, err
+	}
+	keyIdx := -1
+	for i, f := range fields {
+		if f == st.Key {
+			keyIdx = i
+		}
+	}
+	if keyIdx < 0 {
+		return 0, false, fmt.Errorf("shard table %s: shard key %s is required", st.Name, st.Key)
+	}
+	var order []shardTarget
+	groups := make(map[int][][]interface{})
+	for _, d := range data {
+		if len(d) != len(fields) {
+			return 0, false, fmt.Errorf("invalid data,count(fields) != count(data)")
+		}
+		t, err := st.locate(gItem.MakeItemElem(d[keyIdx]))
+		if err != nil {
+			return 0, false, err
+		}
+		if _, ok := groups[t.idx]; !ok {
+			order = append(order, t)
+		}
+		groups[t.idx] = append(groups[t.idx], d)
+	}
+	var total int64
+	for _, t := range order {
+		n, _, err := t.db.InsertBatchDataContext(ctx, t.table, fields, groups[t.idx], isIgnore)
+		total += n
+		if err != nil {
+			return total, false, fmt.Errorf("insert %s failed: %v", t.table, err)
+		}
+	}
+	return total, len(order) > 0, nil
+}
+
+//更新数据，cond里必须有分表字段，且不能更新分表字段
+func (s *DBShard) UpdateData(table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
+	return s.UpdateDataContext(context.Background(), table, data, cond)
+}
+
+//更新数据，可以用ctx取消
+func (s *DBShard) UpdateDataContext(ctx context.Context, table string, data map[string]interface{}, cond map[string]interface{}) (int64, bool, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return 0, false, err
+	}
+	if _, ok := data[st.Key]; ok {
+		return 0, false, fmt.Errorf("shard table %s: can not update shard key %s", st.Name, st.Key)
+	}
+	t, err := st.routeOne(cond)
+	if err != nil {
+		return 0, false, err
+	}
+	return t.db.UpdateDataContext(ctx, t.table, data, cond)
+}
+
+//删除数据，cond里必须有分表字段
+func (s *DBShard) DeleteData(table string, cond map[string]interface{}) (int64, bool, error) {
+	return s.DeleteDataContext(context.Background(), table, cond)
+}
+
+//删除数据，可以用ctx取消
+func (s *DBShard) DeleteDataContext(ctx context.Context, table string, cond map[string]interface{}) (int64, bool, error) {
+	st, err := s.shardTable(table)
+	if err != nil {
+		return 0, false, err
+	}
+	t, err := st.routeOne(cond)
+	if err != nil {
+		return 0, false, err
+	}
+	return t.db.DeleteDataContext(ctx, t.table, cond)
+}
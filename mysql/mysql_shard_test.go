@@ -0,0 +1,194 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	gItem "github.com/liuyongshuai/goutils/elem"
+	"runtime"
+	"testing"
+)
+
+func TestShardStrategy(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	for _, c := range []struct {
+		key  interface{}
+		want int
+	}{{int64(130), 2}, {-5, 5}, {"67", 3}} {
+		if idx, _ := (ModShard{}).Locate(gItem.MakeItemElem(c.key), 64); idx != c.want {
+			t.Errorf("mod %v: got %d, want %d", c.key, idx, c.want)
+		}
+	}
+	r := RangeShard{Bounds: []int64{100, 200}}
+	for key, want := range map[int]int{0: 0, 99: 0, 100: 1, 250: 2} {
+		if idx, err := r.Locate(gItem.MakeItemElem(key), 3); err != nil || idx != want {
+			t.Errorf("range %d: got %d %v, want %d", key, idx, err, want)
+		}
+	}
+	if _, err := r.Locate(gItem.MakeItemElem(250), 2); err == nil {
+		t.Errorf("out of range should fail")
+	}
+	if _, err := r.Locate(gItem.MakeItemElem("abc"), 3); err == nil {
+		t.Errorf("non-integer key should fail")
+	}
+
+	//一致性hash：分布均匀，加一张表时只有少量数据移动
+	h := &HashShard{}
+	counts := make([]int, 8)
+	moved := 0
+	for i := 0; i < 8000; i++ {
+		key := gItem.MakeItemElem(fmt.Sprintf("user%d", i))
+		a, _ := h.Locate(key, 8)
+		counts[a]++
+		b, _ := h.Locate(key, 9)
+		if a != b {
+			moved++
+		}
+		if c, _ := h.Locate(key, 8); c != a {
+			t.Fatalf("hash shard is not stable")
+		}
+	}
+	for i, n := range counts {
+		if n < 500 || n > 1500 {
+			t.Errorf("table %d has %d keys", i, n)
+		}
+	}
+	if moved > 8000/9*2 {
+		t.Errorf("too many keys moved: %d", moved)
+	}
+}
+
+func newFakeShard(t *testing.T) (*DBShard, []*fakeServer) {
+	db0, srv0 := newFakeDBase(t)
+	db1, srv1 := newFakeDBase(t)
+	s, err := NewDBShard(&ShardTable{Name: "user", Key: "uid", Tables: 4, DBs: []*DBase{db0, db1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, []*fakeServer{srv0, srv1}
+}
+
+func TestDBShard_Route(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	s, srvs := newFakeShard(t)
+	ctx := context.Background()
+
+	db, table, err := s.Locate("user", 6)
+	if err != nil || table != "user_02" || db != s.tables["user"].DBs[1] {
+		t.Errorf("unexpected %s %v", table, err)
+	}
+	if _, _, err = s.Locate("order", 1); err == nil {
+		t.Errorf("unknown table should fail")
+	}
+
+	//user_00、user_01在第一个库，user_02、user_03在第二个库
+	srvs[1].set("SELECT * FROM `user_03` WHERE `uid` = ? ORDER BY `id` DESC LIMIT 10 OFFSET 5", fakeResult{cols: []string{"uid"}, rows: [][]driver.Value{{int64(7)}}})
+	rows, err := s.Query(ctx, "user", ShardQuery{Cond: map[string]interface{}{"uid": 7}, OrderBy: []string{"id DESC"}, Limit: 10, Offset: 5})
+	if err != nil || len(rows) != 1 || len(srvs[0].executed()) != 0 {
+		t.Errorf("unexpected %v %v", rows, err)
+	}
+
+	//IN只查涉及的表
+	srvs[0].set("SELECT `uid` FROM `user_01` WHERE `uid` IN (?,?,?)", fakeResult{cols: []string{"uid"}, rows: [][]driver.Value{{int64(1)}, {int64(5)}}})
+	srvs[1].set("SELECT `uid` FROM `user_02` WHERE `uid` IN (?,?,?)", fakeResult{cols: []string{"uid"}, rows: [][]driver.Value{{int64(2)}}})
+	rows, err = s.FetchCondRows("user", map[string]interface{}{"uid:in": []int{1, 2, 5}}, "uid")
+	if err != nil || len(rows) != 3 || rows[2]["uid"].ToString() != "2" {
+		t.Errorf("unexpected %v %v", rows, err)
+	}
+
+	//写
+	srvs[0].reset()
+	srvs[1].reset()
+	s.InsertData("user", map[string]interface{}{"uid": 4, "name": "a"}, false)
+	s.UpdateData("user", map[string]interface{}{"name": "b"}, map[string]interface{}{"uid": 5})
+	s.DeleteData("user", map[string]interface{}{"uid:eq": 3})
+	n, ok, err := s.InsertBatchData("user", []string{"uid", "name"}, [][]interface{}{{1, "a"}, {2, "b"}, {5, "c"}}, true)
+	if n != 2 || !ok || err != nil {
+		t.Errorf("unexpected batch insert %d %v %v", n, ok, err)
+	}
+	want0 := []string{
+		"INSERT INTO `user_00` (`name`,`uid`) VALUES (?,?)",
+		"UPDATE `user_01` SET `name` = ? WHERE `uid` = ?",
+		"INSERT IGNORE INTO `user_01` (`uid`,`name`) VALUES (?,?),(?,?)",
+	}
+	want1 := []string{
+		"DELETE FROM `user_03` WHERE `uid` = ?",
+		"INSERT IGNORE INTO `user_02` (`uid`,`name`) VALUES (?,?)",
+	}
+	if got := fmt.Sprint(srvs[0].executed()); got != fmt.Sprint(want0) {
+		t.Errorf("unexpected queries %q", srvs[0].executed())
+	}
+	if got := fmt.Sprint(srvs[1].executed()); got != fmt.Sprint(want1) {
+		t.Errorf("unexpected queries %q", srvs[1].executed())
+	}
+
+	//写必须有唯一的分表字段，不能改分表字段
+	if _, _, err = s.UpdateData("user", map[string]interface{}{"name": "b"}, map[string]interface{}{"status": 1}); err == nil {
+		t.Errorf("update without shard key should fail")
+	}
+	if _, _, err = s.DeleteData("user", map[string]interface{}{"uid:in": []int{1, 2}}); err == nil {
+		t.Errorf("delete with multiple shard keys should fail")
+	}
+	if _, _, err = s.UpdateData("user", map[string]interface{}{"uid": 2}, map[string]interface{}{"uid": 1}); err == nil {
+		t.Errorf("update shard key should fail")
+	}
+	if _, _, err = s.InsertData("user", map[string]interface{}{"name": "a"}, false); err == nil {
+		t.Errorf("insert without shard key should fail")
+	}
+	if _, err = NewDBShard(&ShardTable{Name: "user", Key: "uid", Tables: 1, DBs: s.tables["user"].DBs}); err == nil {
+		t.Errorf("more dbs than tables should fail")
+	}
+}
+
+func TestDBShard_Scatter(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	s, srvs := newFakeShard(t)
+	data := map[int][]int64{0: {8, 4}, 1: {9, 1}, 2: {6}, 3: {7, 3}}
+	for i, ids := range data {
+		var rows [][]driver.Value
+		for _, id := range ids {
+			rows = append(rows, []driver.Value{id, fmt.Sprintf("n%d", id)})
+		}
+		q := fmt.Sprintf("SELECT `uid`,`name` FROM `user_%02d` WHERE `status` = ? ORDER BY `uid` DESC LIMIT 4", i)
+		srvs[i/2].set(q, fakeResult{cols: []string{"uid", "name"}, rows: rows})
+	}
+
+	//每张表取前offset+limit条，合并后再排序分页
+	rows, err := s.Query(context.Background(), "user", ShardQuery{
+		Fields:  []string{"uid", "name"},
+		Cond:    map[string]interface{}{"status": 1},
+		OrderBy: []string{"uid DESC"},
+		Limit:   3,
+		Offset:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r["uid"].ToString())
+	}
+	if fmt.Sprint(got) != "[8 7 6]" {
+		t.Errorf("unexpected %v", got)
+	}
+	if len(srvs[0].executed()) != 2 || len(srvs[1].executed()) != 2 {
+		t.Errorf("should query all tables")
+	}
+
+	//有一张表出错时整体出错
+	if _, err = s.FetchCondRows("user", map[string]interface{}{"status": 2}); err == nil {
+		t.Errorf("expected error")
+	}
+	if c := compareItem(gItem.MakeItemElem("10"), gItem.MakeItemElem(int64(9))); c != 1 {
+		t.Errorf("should compare as number")
+	}
+	if c := compareItem(gItem.MakeItemElem(nil), gItem.MakeItemElem("a")); c != -1 {
+		t.Errorf("NULL should be the smallest")
+	}
+}
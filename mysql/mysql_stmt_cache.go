@@ -0,0 +1,146 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+//超过这个长度的SQL不缓存，如拼了很多行的批量INSERT
+const maxCachedSQLLen = 8 << 10
+
+//预编译语句缓存的统计信息
+type StmtCacheStats struct {
+	Size      int   //当前缓存的语句数
+	MaxSize   int   //最多缓存多少条
+	Hits      int64 //命中次数
+	Misses    int64 //没命中的次数
+	Evictions int64 //被淘汰的次数
+}
+
+//缓存的语句，有引用计数，被淘汰后等用完了再关闭
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+//按SQL缓存预编译的语句，超过maxSize时淘汰最久没用的
+type stmtCache struct {
+	mu        sync.Mutex
+	db        *sql.DB
+	maxSize   int
+	ll        *list.List
+	items     map[string]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func newStmtCache(db *sql.DB, maxSize int) *stmtCache {
+	return &stmtCache{
+		db:      db,
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+//提取预编译的语句，用完后要调用返回的release
+func (sc *stmtCache) get(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	if len(query) > maxCachedSQLLen {
+		return prepareOnce(ctx, sc.db, query)
+	}
+	sc.mu.Lock()
+	if e, ok := sc.items[query]; ok {
+		sc.hits++
+		sc.ll.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		sc.mu.Unlock()
+		return cs.stmt, func() { sc.release(cs) }, nil
+	}
+	sc.misses++
+	sc.mu.Unlock()
+
+	//预编译时不加锁，同时编译了同一条SQL的用先放进去的
+	stmt, err := sc.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if e, ok := sc.items[query]; ok {
+		stmt.Close()
+		sc.ll.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		return cs.stmt, func() { sc.release(cs) }, nil
+	}
+	cs := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	sc.items[query] = sc.ll.PushFront(cs)
+	for sc.ll.Len() > sc.maxSize {
+		sc.evict(sc.ll.Back())
+	}
+	return stmt, func() { sc.release(cs) }, nil
+}
+
+//用完了，已被淘汰且没人用时关闭
+func (sc *stmtCache) release(cs *cachedStmt) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	cs.refs--
+	if cs.evicted && cs.refs <= 0 {
+		cs.stmt.Close()
+	}
+}
+
+//淘汰一条，调用方要加锁
+func (sc *stmtCache) evict(e *list.Element) {
+	cs := e.Value.(*cachedStmt)
+	sc.ll.Remove(e)
+	delete(sc.items, cs.query)
+	sc.evictions++
+	cs.evicted = true
+	if cs.refs <= 0 {
+		cs.stmt.Close()
+	}
+}
+
+//清空缓存，正在用的语句等用完了再关闭
+func (sc *stmtCache) clear() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for e := sc.ll.Back(); e != nil; e = sc.ll.Back() {
+		sc.evict(e)
+	}
+}
+
+//统计信息
+func (sc *stmtCache) stats() StmtCacheStats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return StmtCacheStats{
+		Size:      sc.ll.Len(),
+		MaxSize:   sc.maxSize,
+		Hits:      sc.hits,
+		Misses:    sc.misses,
+		Evictions: sc.evictions,
+	}
+}
+
+//不缓存，预编译一次用完就关闭
+func prepareOnce(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, func(), error) {
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stmt, func() { stmt.Close() }, nil
+}
@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDBase_StmtCache(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	db.stmts = newStmtCache(db.Db, 2)
+	for _, q := range []string{"SELECT 1", "SELECT 2", "SELECT 3"} {
+		srv.set(q, fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(1)}}})
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.FetchRows("SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Execute("UPDATE `t` SET `a` = ?", 1)
+	st := db.StmtCacheStats()
+	if st.Size != 2 || st.MaxSize != 2 || st.Hits != 2 || st.Misses != 2 || st.Evictions != 0 || srv.prepares != 2 {
+		t.Errorf("unexpected stats %+v, prepares %d", st, srv.prepares)
+	}
+	//SELECT 1最近用过，淘汰的是UPDATE
+	db.FetchRows("SELECT 1")
+	db.FetchRows("SELECT 2")
+	db.FetchRows("SELECT 1")
+	st = db.StmtCacheStats()
+	if st.Size != 2 || st.Evictions != 1 || st.Hits != 4 || srv.closes != 1 {
+		t.Errorf("unexpected stats %+v, closes %d", st, srv.closes)
+	}
+
+	//太长的SQL不缓存
+	long := "SELECT 1 /*" + strings.Repeat("x", maxCachedSQLLen) + "*/"
+	srv.set(long, fakeResult{cols: []string{"v"}})
+	db.FetchRows(long)
+	if st2 := db.StmtCacheStats(); st2.Misses != st.Misses || st2.Size != 2 || srv.closes != 2 {
+		t.Errorf("long sql should not be cached: %+v", st2)
+	}
+
+	//并发时被淘汰的语句等用完再关闭
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.FetchRows(fmt.Sprintf("SELECT %d", i%3+1)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if st = db.StmtCacheStats(); st.Size > 2 || st.Hits+st.Misses != 107 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	db.Close()
+	srv.mu.Lock()
+	if srv.closes != srv.prepares {
+		t.Errorf("prepares %d, closes %d", srv.prepares, srv.closes)
+	}
+	srv.mu.Unlock()
+}
+
+func TestDBase_Context(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	srv.set("SELECT * FROM `t` WHERE `id` = ?", fakeResult{cols: []string{"id"}, rows: [][]driver.Value{{int64(1)}}})
+
+	ctx := context.Background()
+	row, err := db.FetchRowContext(ctx, "SELECT * FROM `t` WHERE `id` = ?", 1)
+	if err != nil || row["id"].ToString() != "1" {
+		t.Errorf("unexpected %v %v", row, err)
+	}
+	if _, ok, err := db.UpdateDataContext(ctx, "t", map[string]interface{}{"a": 1}, map[string]interface{}{"id": 1}); !ok || err != nil {
+		t.Errorf("update failed: %v", err)
+	}
+
+	//取消了的不会执行
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	n := len(srv.executed())
+	if _, err = db.FetchRowsContext(cctx, "SELECT * FROM `t` WHERE `id` = ?", 1); err != context.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	if _, _, err = db.ExecuteContext(cctx, "DELETE FROM `t`"); err != context.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	var dest []struct{ ID int }
+	if err = db.FetchStructsContext(cctx, &dest, "SELECT * FROM `t` WHERE `id` = ?", 1); err != context.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	if _, err = db.BeginTransactionContext(cctx, nil); err != context.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	if len(srv.executed()) != n {
+		t.Errorf("canceled queries executed: %v", srv.executed()[n:])
+	}
+}
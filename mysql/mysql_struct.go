@@ -0,0 +1,332 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+//结构体字段的信息
+type structField struct {
+	index   []int //reflect的FieldByIndex用的下标
+	isTime  bool  //是否为time.Time或*time.Time，要兼容没开parseTime时返回的字符串
+	isBytes bool  //是否为json.RawMessage这样的[]byte类型，要支持NULL
+}
+
+//结构体的列名与字段的对应关系，按类型缓存
+type structMeta struct {
+	fields map[string]structField
+}
+
+//列名对应的字段，先精确匹配，再不区分大小写匹配
+func (sm *structMeta) field(col string) (structField, bool) {
+	if f, ok := sm.fields[col]; ok {
+		return f, true
+	}
+	f, ok := sm.fields[strings.ToLower(col)]
+	return f, ok
+}
+
+var structMetaCache sync.Map
+
+//提取结构体的字段信息，会缓存起来
+//列名用`db:"col"`指定，`db:"-"`表示忽略，没有tag的用字段名的下划线格式，如UserID为user_id
+//匿名嵌入的结构体会展开，外层的字段优先
+func getStructMeta(t reflect.Type) *structMeta {
+	if m, ok := structMetaCache.Load(t); ok {
+		return m.(*structMeta)
+	}
+	sm := &structMeta{fields: make(map[string]structField)}
+	depths := make(map[string]int)
+	collectStructFields(t, nil, sm, depths)
+	m, _ := structMetaCache.LoadOrStore(t, sm)
+	return m.(*structMeta)
+}
+
+//遍历结构体的字段，index为上层的下标
+func collectStructFields(t reflect.Type, index []int, sm *structMeta, depths map[string]int) {
+	depth := len(index)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			tag = tag[:idx]
+		}
+		fi := make([]int, depth+1)
+		copy(fi, index)
+		fi[depth] = i
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		//没有tag的匿名结构体展开
+		if f.Anonymous && len(tag) <= 0 && ft.Kind() == reflect.Struct && isPlainStruct(ft) {
+			//没导出的结构体指针没法分配
+			if f.Type.Kind() == reflect.Ptr && len(f.PkgPath) > 0 {
+				continue
+			}
+			collectStructFields(ft, fi, sm, depths)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		name := tag
+		if len(name) <= 0 {
+			name = snakeName(f.Name)
+		}
+		if d, ok := depths[name]; ok && d <= depth {
+			continue
+		}
+		depths[name] = depth
+		sf := structField{
+			index:   fi,
+			isTime:  ft == timeType,
+			isBytes: f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(f.Type).Implements(scannerType),
+		}
+		sm.fields[name] = sf
+		if lower := strings.ToLower(name); lower != name {
+			if _, ok := sm.fields[lower]; !ok {
+				sm.fields[lower] = sf
+			}
+		}
+	}
+}
+
+//普通的结构体，不是time.Time、sql.NullString之类的值类型
+func isPlainStruct(t reflect.Type) bool {
+	return t != timeType && !reflect.PtrTo(t).Implements(scannerType)
+}
+
+//字段名转为下划线格式，如UserID为user_id、CreateTime为create_time
+func snakeName(name string) string {
+	rs := []rune(name)
+	var buf strings.Builder
+	for i, c := range rs {
+		if unicode.IsUpper(c) {
+			//前一个是小写，或后一个是小写（如IDCard的C）时加下划线
+			if i > 0 && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) ||
+				(i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1]))) {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(unicode.ToLower(c))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+//按下标取字段，中间是nil指针的会分配
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+//time.Time、*time.Time字段的Scanner，没开parseTime时驱动返回的是字符串
+type timeScanner struct {
+	dest reflect.Value
+	loc  *time.Location
+}
+
+func (ts timeScanner) Scan(src interface{}) error {
+	var t time.Time
+	switch v := src.(type) {
+	case nil:
+		ts.dest.Set(reflect.Zero(ts.dest.Type()))
+		return nil
+	case time.Time:
+		t = v
+	case []byte:
+		return ts.Scan(string(v))
+	case string:
+		var err error
+		if t, err = parseMySQLTime(v, ts.loc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("can not convert %T to time.Time", src)
+	}
+	if ts.dest.Kind() == reflect.Ptr {
+		ts.dest.Set(reflect.ValueOf(&t))
+	} else {
+		ts.dest.Set(reflect.ValueOf(t))
+	}
+	return nil
+}
+
+//[]byte类型字段的Scanner，NULL时为nil，其余的复制一份
+type bytesScanner struct {
+	dest reflect.Value
+}
+
+func (bs bytesScanner) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		bs.dest.Set(reflect.Zero(bs.dest.Type()))
+		return nil
+	case []byte:
+		b = append([]byte{}, v...)
+	case string:
+		b = []byte(v)
+	default:
+		b = []byte(fmt.Sprint(v))
+	}
+	bs.dest.SetBytes(b)
+	return nil
+}
+
+//解析DATE、DATETIME、TIMESTAMP格式的字符串，"0000-00-00"当成零值
+func parseMySQLTime(s string, loc *time.Location) (time.Time, error) {
+	if len(s) <= 0 || strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	layout := "2006-01-02 15:04:05.999999"
+	if len(s) == 10 {
+		layout = "2006-01-02"
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return t, fmt.Errorf("invalid time value %q", s)
+	}
+	return t, nil
+}
+
+//结构体或结构体指针的slice的元素类型
+func structElemType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, t.Kind() == reflect.Struct
+}
+
+//把当前行扫描到v里，v为可寻址的结构体
+func scanStruct(rows *sql.Rows, cols []string, sm *structMeta, v reflect.Value, loc *time.Location) error {
+	targets := make([]interface{}, len(cols))
+	for i, col := range cols {
+		f, ok := sm.field(col)
+		if !ok {
+			targets[i] = new(sql.RawBytes)
+			continue
+		}
+		fv := fieldByIndexAlloc(v, f.index)
+		switch {
+		case f.isTime:
+			targets[i] = timeScanner{dest: fv, loc: loc}
+		case f.isBytes:
+			targets[i] = bytesScanner{dest: fv}
+		default:
+			targets[i] = fv.Addr().Interface()
+		}
+	}
+	return rows.Scan(targets...)
+}
+
+//解析时间字符串用的时区，与DSN的loc一致
+func (my *DBase) timeLocation() *time.Location {
+	if len(my.Conf.Loc) > 0 {
+		if loc, err := time.LoadLocation(my.Conf.Loc); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+//提取一行数据到结构体里，dest为结构体指针，没有数据时返回sql.ErrNoRows
+//字段用`db:"col"`对应列名，NULL值请用sql.NullString等类型或指针接收
+func (my *DBase) FetchStruct(dest interface{}, fsql string, args ...interface{}) error {
+	return my.FetchStructContext(context.Background(), dest, fsql, args...)
+}
+
+//提取一行数据到结构体里，可以用ctx取消
+func (my *DBase) FetchStructContext(ctx context.Context, dest interface{}, fsql string, args ...interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a non-nil pointer to struct, got %T", dest)
+	}
+	loc := my.timeLocation()
+	return my.doFetch(ctx, fsql, args, func(rows *sql.Rows) (int64, error) {
+		cols, err := rows.Columns()
+		if err != nil {
+			return 0, err
+		}
+		if !rows.Next() {
+			if err = rows.Err(); err != nil {
+				return 0, err
+			}
+			return 0, sql.ErrNoRows
+		}
+		return 1, scanStruct(rows, cols, getStructMeta(dv.Elem().Type()), dv.Elem(), loc)
+	})
+}
+
+//提取多行数据到结构体的slice里，dest为*[]T或*[]*T，T为结构体
+func (my *DBase) FetchStructs(dest interface{}, fsql string, args ...interface{}) error {
+	return my.FetchStructsContext(context.Background(), dest, fsql, args...)
+}
+
+//提取多行数据到结构体的slice里，可以用ctx取消
+func (my *DBase) FetchStructsContext(ctx context.Context, dest interface{}, fsql string, args ...interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a non-nil pointer to slice, got %T", dest)
+	}
+	sliceType := dv.Elem().Type()
+	elemType, ok := structElemType(sliceType.Elem())
+	if !ok {
+		return fmt.Errorf("dest must be a pointer to slice of struct, got %T", dest)
+	}
+	isPtr := sliceType.Elem().Kind() == reflect.Ptr
+	loc := my.timeLocation()
+	return my.doFetch(ctx, fsql, args, func(rows *sql.Rows) (int64, error) {
+		cols, err := rows.Columns()
+		if err != nil {
+			return 0, err
+		}
+		sm := getStructMeta(elemType)
+		ret := reflect.MakeSlice(sliceType, 0, 0)
+		for rows.Next() {
+			ev := reflect.New(elemType)
+			if err = scanStruct(rows, cols, sm, ev.Elem(), loc); err != nil {
+				return int64(ret.Len()), err
+			}
+			if isPtr {
+				ret = reflect.Append(ret, ev)
+			} else {
+				ret = reflect.Append(ret, ev.Elem())
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return int64(ret.Len()), err
+		}
+		dv.Elem().Set(ret)
+		return int64(ret.Len()), nil
+	})
+}
@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//自定义的Scanner，逗号分隔的标签
+type testTags []string
+
+func (tt *testTags) Scan(src interface{}) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("unexpected %T", src)
+	}
+	*tt = strings.Split(string(b), ",")
+	return nil
+}
+
+type testBase struct {
+	ID        int64     `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type ExtraInfo struct {
+	Score float64
+}
+
+type testUser struct {
+	testBase
+	*ExtraInfo
+	Name     string          `db:"name"`
+	Nick     sql.NullString  `db:"nick"`
+	Age      *int            `db:"age"`
+	Birthday *time.Time      `db:"birthday"`
+	Profile  json.RawMessage `db:"profile"`
+	Tags     testTags        `db:"tags"`
+	UserType int
+	Ignored  string `db:"-"`
+	ID2      int    `db:"id"` //外层的字段优先
+}
+
+func TestDBase_FetchStructs(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	cols := []string{"id", "name", "nick", "age", "birthday", "created_at", "profile", "tags", "user_type", "score", "unknown"}
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv.set("SELECT * FROM `users`", fakeResult{cols: cols, rows: [][]driver.Value{
+		{int64(1), []byte("tom"), []byte("t"), int64(18), []byte("2000-01-02"), ts, []byte(`{"a":1}`), []byte("a,b"), int64(2), 9.5, []byte("x")},
+		{int64(2), []byte("jerry"), nil, nil, nil, []byte("2020-01-02 03:04:05"), nil, []byte("c"), int64(0), 1.0, nil},
+	}})
+
+	var users []testUser
+	if err := db.FetchStructs(&users, "SELECT * FROM `users`"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("unexpected %d users", len(users))
+	}
+	u := users[0]
+	if u.ID2 != 1 || u.ID != 0 || u.Name != "tom" || !u.Nick.Valid || u.Nick.String != "t" || u.Age == nil || *u.Age != 18 ||
+		u.Birthday == nil || !u.Birthday.Equal(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)) || !u.CreatedAt.Equal(ts) ||
+		string(u.Profile) != `{"a":1}` || !reflect.DeepEqual(u.Tags, testTags{"a", "b"}) || u.UserType != 2 ||
+		u.ExtraInfo == nil || u.Score != 9.5 {
+		t.Errorf("unexpected %+v", u)
+	}
+	u = users[1]
+	if u.Nick.Valid || u.Age != nil || u.Birthday != nil || u.Profile != nil || !u.CreatedAt.Equal(ts) {
+		t.Errorf("unexpected %+v", u)
+	}
+
+	//指针的slice
+	var ptrs []*testUser
+	if err := db.FetchStructs(&ptrs, "SELECT * FROM `users`"); err != nil || len(ptrs) != 2 || ptrs[1].Name != "jerry" {
+		t.Errorf("unexpected %v %v", ptrs, err)
+	}
+
+	//单行
+	var one testUser
+	if err := db.FetchStruct(&one, "SELECT * FROM `users`"); err != nil || one.Name != "tom" {
+		t.Errorf("unexpected %+v %v", one, err)
+	}
+	srv.set("SELECT * FROM `users` WHERE `id` = ?", fakeResult{cols: cols})
+	if err := db.FetchStruct(&one, "SELECT * FROM `users` WHERE `id` = ?", 3); err != sql.ErrNoRows {
+		t.Errorf("expected ErrNoRows, got %v", err)
+	}
+
+	//NULL不能放到非指针的字段里
+	var bad []struct{ Age int }
+	srv.set("SELECT `age` FROM `users`", fakeResult{cols: []string{"age"}, rows: [][]driver.Value{{nil}}})
+	if err := db.FetchStructs(&bad, "SELECT `age` FROM `users`"); err == nil {
+		t.Errorf("expected error for NULL")
+	}
+	for _, dest := range []interface{}{users, &one, new([]int), nil} {
+		if err := db.FetchStructs(dest, "SELECT * FROM `users`"); err == nil {
+			t.Errorf("expected error for %T", dest)
+		}
+	}
+	if err := db.FetchStruct(users, "SELECT * FROM `users`"); err == nil {
+		t.Errorf("expected error for slice")
+	}
+
+	//字段信息只生成一次
+	if getStructMeta(reflect.TypeOf(testUser{})) != getStructMeta(reflect.TypeOf(testUser{})) {
+		t.Errorf("struct meta not cached")
+	}
+}
+
+func TestSnakeName(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	for name, want := range map[string]string{
+		"UserID":     "user_id",
+		"IDCard":     "id_card",
+		"CreateTime": "create_time",
+		"Field1Name": "field1_name",
+		"name":       "name",
+	} {
+		if got := snakeName(name); got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+}
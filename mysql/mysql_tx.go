@@ -0,0 +1,140 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysql
+ * @date        2018-01-25 19:19
+ */
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"time"
+)
+
+//可以重试的MySQL错误码
+const (
+	errLockWaitTimeout = 1205 //锁等待超时
+	errDeadlock        = 1213 //死锁
+)
+
+//事务，有和DBase一样的FetchRows、InsertData等方法，都在这个事务里执行
+//由WithTx创建，结束时自动提交或回滚，不要自己提交
+type TxBase struct {
+	*DBase
+	depth int //嵌套的层数，0为最外层
+}
+
+//原始的事务对象
+func (tx *TxBase) Tx() *sql.Tx {
+	return tx.tx
+}
+
+//嵌套的事务，用SAVEPOINT实现，fn返回错误或panic时只回滚到这个SAVEPOINT
+func (tx *TxBase) WithTx(ctx context.Context, fn func(tx *TxBase) error) (err error) {
+	sub := &TxBase{DBase: tx.DBase, depth: tx.depth + 1}
+	name := fmt.Sprintf("sp_%d", sub.depth)
+	if _, err = tx.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+	if err = fn(sub); err != nil {
+		if _, rerr := tx.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rerr)
+		}
+		return err
+	}
+	_, err = tx.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+//事务里不能关闭连接
+func (tx *TxBase) Close() error {
+	return fmt.Errorf("can not close connection in transaction")
+}
+
+//事务里不能再开启事务，请用WithTx
+func (tx *TxBase) BeginTransaction() (*sql.Tx, error) {
+	return nil, fmt.Errorf("already in transaction, use WithTx instead")
+}
+
+//事务里不能再开启事务，请用WithTx
+func (tx *TxBase) BeginTransactionContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return tx.BeginTransaction()
+}
+
+//在事务里执行fn，fn返回nil时提交，返回错误或panic时回滚
+//遇到死锁、锁等待超时时按Conf.TxMaxRetries重新执行整个fn，所以fn里不要有事务外的副作用
+//
+//	err := db.WithTx(ctx, func(tx *TxBase) error {
+//		if _, _, err := tx.UpdateData("account", ...); err != nil {
+//			return err
+//		}
+//		_, _, err := tx.InsertData("log", ...)
+//		return err
+//	})
+func (my *DBase) WithTx(ctx context.Context, fn func(tx *TxBase) error) error {
+	return my.WithTxOptions(ctx, nil, fn)
+}
+
+//同WithTx，opts可以设置隔离级别、只读
+func (my *DBase) WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx *TxBase) error) error {
+	if my.tx != nil {
+		return fmt.Errorf("already in transaction, use TxBase.WithTx instead")
+	}
+	for attempt := 0; ; attempt++ {
+		err := my.runTx(ctx, opts, fn)
+		if err == nil || !isTxRetryable(err) || attempt >= my.Conf.TxMaxRetries {
+			return err
+		}
+		if my.IsDebug {
+			fmt.Printf("\nretry transaction(%d): %v\n", attempt+1, err)
+		}
+		//稍等一会再重试，避免马上又撞上
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt+1) * 20 * time.Millisecond):
+		}
+	}
+}
+
+//执行一次事务
+func (my *DBase) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *TxBase) error) (err error) {
+	sqlTx, err := my.BeginTransactionContext(ctx, opts)
+	if err != nil {
+		return err
+	}
+	txDB := *my
+	txDB.tx = sqlTx
+	tx := &TxBase{DBase: &txDB}
+	defer func() {
+		if p := recover(); p != nil {
+			my.RollBackTransaction(sqlTx)
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		if rerr := my.RollBackTransaction(sqlTx); rerr != nil && rerr != sql.ErrTxDone {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+		}
+		return err
+	}
+	return my.CommitTransaction(sqlTx)
+}
+
+//是否为死锁、锁等待超时的错误
+func isTxRetryable(err error) bool {
+	var me *gomysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == errDeadlock || me.Number == errLockWaitTimeout
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDBase_WithTx(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, srv := newFakeDBase(t)
+	defer db.Close()
+	db.stmts = newStmtCache(db.Db, 10)
+	ctx := context.Background()
+	srv.set("SELECT * FROM `t` WHERE `id` = ? FOR UPDATE", fakeResult{cols: []string{"id", "num"}, rows: [][]driver.Value{{int64(1), int64(5)}}})
+
+	//提交，嵌套的回滚到SAVEPOINT
+	err := db.WithTx(ctx, func(tx *TxBase) error {
+		row, err := tx.FetchForUpdate("t", map[string]interface{}{"id": 1})
+		if err != nil {
+			return err
+		}
+		if _, _, err = tx.UpdateData("t", map[string]interface{}{"num": row["num"].ToString() + "1"}, map[string]interface{}{"id": 1}); err != nil {
+			return err
+		}
+		if err = tx.WithTx(ctx, func(sub *TxBase) error {
+			sub.Execute("DELETE FROM `t`")
+			return fmt.Errorf("undo delete")
+		}); err == nil {
+			return fmt.Errorf("expected error")
+		}
+		return tx.WithTx(ctx, func(sub *TxBase) error {
+			_, _, err := sub.InsertData("log", map[string]interface{}{"id": 1}, false)
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN",
+		"SELECT * FROM `t` WHERE `id` = ? FOR UPDATE",
+		"UPDATE `t` SET `num` = ? WHERE `id` = ?",
+		"SAVEPOINT sp_1",
+		"DELETE FROM `t`",
+		"ROLLBACK TO SAVEPOINT sp_1",
+		"SAVEPOINT sp_1",
+		"INSERT INTO `log` (`id`) VALUES (?)",
+		"RELEASE SAVEPOINT sp_1",
+		"COMMIT",
+	}
+	if got := srv.executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected queries:\n%q\nwant:\n%q", got, want)
+	}
+
+	//死锁时重试整个函数
+	srv.reset()
+	attempts := 0
+	err = db.WithTx(ctx, func(tx *TxBase) error {
+		attempts++
+		tx.Execute("UPDATE `t` SET `a` = 1")
+		if attempts < 3 {
+			return fmt.Errorf("update: %w", &gomysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"})
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("attempts %d, err %v", attempts, err)
+	}
+	if got := srv.executed(); len(got) != 9 || got[2] != "ROLLBACK" || got[8] != "COMMIT" {
+		t.Errorf("unexpected queries %q", got)
+	}
+
+	//超过重试次数
+	attempts = 0
+	db.Conf.TxMaxRetries = 1
+	err = db.WithTx(ctx, func(tx *TxBase) error {
+		attempts++
+		return &gomysql.MySQLError{Number: errLockWaitTimeout}
+	})
+	if err == nil || attempts != 2 {
+		t.Errorf("attempts %d, err %v", attempts, err)
+	}
+	//回滚到SAVEPOINT失败时保留原来的错误，死锁照样重试
+	attempts = 0
+	srv.set("ROLLBACK TO SAVEPOINT sp_1", fakeResult{err: &gomysql.MySQLError{Number: 1305, Message: "SAVEPOINT sp_1 does not exist"}})
+	err = db.WithTx(ctx, func(tx *TxBase) error {
+		attempts++
+		return tx.WithTx(ctx, func(*TxBase) error {
+			return &gomysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}
+		})
+	})
+	var me *gomysql.MySQLError
+	if attempts != 2 || !errors.As(err, &me) || me.Number != errDeadlock || !strings.Contains(err.Error(), "1305") {
+		t.Errorf("attempts %d, err %v", attempts, err)
+	}
+	srv.set("ROLLBACK TO SAVEPOINT sp_1", fakeResult{})
+	//其他错误不重试
+	attempts = 0
+	if err = db.WithTx(ctx, func(tx *TxBase) error {
+		attempts++
+		return fmt.Errorf("other")
+	}); err == nil || attempts != 1 {
+		t.Errorf("attempts %d, err %v", attempts, err)
+	}
+
+	//panic时回滚后继续panic
+	srv.reset()
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("unexpected panic %v", p)
+			}
+		}()
+		db.WithTx(ctx, func(tx *TxBase) error {
+			panic("boom")
+		})
+	}()
+	if got := srv.executed(); !reflect.DeepEqual(got, []string{"BEGIN", "ROLLBACK"}) {
+		t.Errorf("unexpected queries %q", got)
+	}
+
+	//事务里不能关闭、不能再Begin
+	db.WithTx(ctx, func(tx *TxBase) error {
+		if tx.Close() == nil {
+			t.Errorf("close in tx should fail")
+		}
+		if _, err := tx.BeginTransaction(); err == nil {
+			t.Errorf("begin in tx should fail")
+		}
+		if err := tx.WithTxOptions(ctx, nil, func(*TxBase) error { return nil }); err == nil {
+			t.Errorf("WithTxOptions in tx should fail")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,451 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     mysqltest
+ * @date        2018-01-25 19:19
+ */
+//不连MySQL的假DBase，用于单元测试
+//预先设置好会执行哪些SQL及返回什么，DBase的所有方法（包括WithTx、FetchStruct等）都走真实的代码，只是驱动换成了假的
+//
+//	db, mock := mysqltest.New()
+//	mock.ExpectQuery("SELECT * FROM `user` WHERE `id` = ?").WithArgs(1).
+//		WillReturnRows(mysqltest.NewRows("id", "name").AddRow(1, "wendao"))
+//	mock.ExpectBegin()
+//	mock.ExpectExec("UPDATE `user` SET").WillReturnResult(0, 1)
+//	mock.ExpectCommit()
+//	//调用要测试的代码，传入db
+//	if err := mock.ExpectationsWereMet(); err != nil {
+//		t.Error(err)
+//	}
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/liuyongshuai/goutils/mysql"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const driverName = "goutils_mysqltest"
+
+var (
+	mocks  sync.Map
+	mockID int64
+)
+
+func init() {
+	sql.Register(driverName, mockDriver{})
+}
+
+//任意参数，用在WithArgs里
+var AnyArg = anyArg{}
+
+type anyArg struct{}
+
+//预期的操作
+const (
+	kindQuery    = "query"
+	kindExec     = "exec"
+	kindBegin    = "begin"
+	kindCommit   = "commit"
+	kindRollback = "rollback"
+)
+
+//一条预期要执行的语句
+type Expectation struct {
+	kind      string
+	sql       string
+	args      []interface{}
+	checkArgs bool
+	rows      *Rows
+	lastID    int64
+	affected  int64
+	err       error
+	done      bool
+}
+
+//预期的参数，可以用AnyArg表示任意值，不调用时不检查参数
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.checkArgs = true
+	return e
+}
+
+//查询返回的数据
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+//写语句返回的lastInsertId及影响行数，默认都为0
+func (e *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	e.lastID = lastInsertID
+	e.affected = rowsAffected
+	return e
+}
+
+//返回错误，如&gomysql.MySQLError{Number: 1213}可以测试死锁重试
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) String() string {
+	if len(e.sql) <= 0 {
+		return strings.ToUpper(e.kind)
+	}
+	s := e.kind + " " + strconv.Quote(e.sql)
+	if e.checkArgs {
+		s += fmt.Sprintf(" args %v", e.args)
+	}
+	return s
+}
+
+//是否匹配，SQL包含预期的SQL即可，空白不用完全一样
+func (e *Expectation) match(kind, query string, args []driver.Value) error {
+	if e.kind != kind {
+		return fmt.Errorf("got %s, want %s", kind, e.kind)
+	}
+	if !strings.Contains(normalize(query), e.sql) {
+		return fmt.Errorf("SQL %q does not contain %q", query, e.sql)
+	}
+	if !e.checkArgs {
+		return nil
+	}
+	if len(args) != len(e.args) {
+		return fmt.Errorf("got %d args %v, want %d args %v", len(args), args, len(e.args), e.args)
+	}
+	for i, want := range e.args {
+		if !argEqual(args[i], want) {
+			return fmt.Errorf("arg %d: got %#v, want %#v", i, args[i], want)
+		}
+	}
+	return nil
+}
+
+//参数是否相等，预期的值按database/sql的规则转换后再比较
+func argEqual(got driver.Value, want interface{}) bool {
+	if _, ok := want.(anyArg); ok {
+		return true
+	}
+	w, err := driver.DefaultParameterConverter.ConvertValue(want)
+	if err != nil {
+		return false
+	}
+	if wt, ok := w.(time.Time); ok {
+		gt, ok := got.(time.Time)
+		return ok && gt.Equal(wt)
+	}
+	return reflect.DeepEqual(got, w)
+}
+
+//合并连续的空白
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+//查询返回的数据
+type Rows struct {
+	cols  []string
+	types []string
+	rows  [][]driver.Value
+	err   error
+}
+
+//指定列名
+func NewRows(cols ...string) *Rows {
+	return &Rows{cols: cols}
+}
+
+//指定列的类型，如"INT"、"UNSIGNED BIGINT"、"DECIMAL"、"DATETIME"，按MySQL驱动的规则转换查询结果
+func (r *Rows) ColumnTypes(types ...string) *Rows {
+	r.types = types
+	return r
+}
+
+//加一行数据，个数要和列数一样
+func (r *Rows) AddRow(vals ...interface{}) *Rows {
+	if len(vals) != len(r.cols) {
+		panic(fmt.Sprintf("mysqltest: row has %d values, want %d", len(vals), len(r.cols)))
+	}
+	row := make([]driver.Value, len(vals))
+	for i, v := range vals {
+		dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			panic(fmt.Sprintf("mysqltest: invalid value %#v: %v", v, err))
+		}
+		row[i] = dv
+	}
+	r.rows = append(r.rows, row)
+	return r
+}
+
+//读完所有行后返回的错误
+func (r *Rows) RowError(err error) *Rows {
+	r.err = err
+	return r
+}
+
+//预期的所有操作
+type Mock struct {
+	mu         sync.Mutex
+	expected   []*Expectation
+	unexpected []string
+	ordered    bool
+}
+
+//新建一个假的DBase，及设置预期的Mock
+func New() (*mysql.DBase, *Mock) {
+	return NewWithConf(mysql.MakeMySQLConf())
+}
+
+//同New，可以指定时区、预编译语句缓存等配置
+func NewWithConf(conf mysql.MySQLConf) (*mysql.DBase, *Mock) {
+	m := &Mock{ordered: true}
+	name := "mock_" + strconv.FormatInt(atomic.AddInt64(&mockID, 1), 10)
+	mocks.Store(name, m)
+	db, _ := sql.Open(driverName, name)
+	return mysql.WrapDB(db, conf), m
+}
+
+//是否要按顺序执行，默认true，为false时可以按任意顺序匹配
+func (m *Mock) MatchInOrder(b bool) {
+	m.mu.Lock()
+	m.ordered = b
+	m.mu.Unlock()
+}
+
+//预期执行一条查询，SQL包含sql即可
+func (m *Mock) ExpectQuery(sql string) *Expectation {
+	return m.expect(&Expectation{kind: kindQuery, sql: normalize(sql)})
+}
+
+//预期执行一条写语句，SQL包含sql即可
+func (m *Mock) ExpectExec(sql string) *Expectation {
+	return m.expect(&Expectation{kind: kindExec, sql: normalize(sql)})
+}
+
+//预期开启事务
+func (m *Mock) ExpectBegin() *Expectation {
+	return m.expect(&Expectation{kind: kindBegin})
+}
+
+//预期提交事务
+func (m *Mock) ExpectCommit() *Expectation {
+	return m.expect(&Expectation{kind: kindCommit})
+}
+
+//预期回滚事务
+func (m *Mock) ExpectRollback() *Expectation {
+	return m.expect(&Expectation{kind: kindRollback})
+}
+
+func (m *Mock) expect(e *Expectation) *Expectation {
+	m.mu.Lock()
+	m.expected = append(m.expected, e)
+	m.mu.Unlock()
+	return e
+}
+
+//所有预期的操作都执行了，且没有预期之外的操作
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []string
+	for _, u := range m.unexpected {
+		errs = append(errs, "unexpected "+u)
+	}
+	for _, e := range m.expected {
+		if !e.done {
+			errs = append(errs, "not executed: "+e.String())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("mysqltest: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//找到匹配的预期，找不到时返回错误并记下来
+func (m *Mock) next(kind, query string, args []driver.Value) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reason error
+	for _, e := range m.expected {
+		if e.done {
+			continue
+		}
+		err := e.match(kind, query, args)
+		if err == nil {
+			e.done = true
+			return e, nil
+		}
+		if reason == nil {
+			reason = err
+		}
+		if m.ordered {
+			break
+		}
+	}
+	call := kind
+	if len(query) > 0 {
+		call += fmt.Sprintf(" %q args %v", query, args)
+	}
+	m.unexpected = append(m.unexpected, call)
+	if reason == nil {
+		return nil, fmt.Errorf("mysqltest: unexpected %s, all expectations were already executed", call)
+	}
+	return nil, fmt.Errorf("mysqltest: unexpected %s: %v", call, reason)
+}
+
+type mockDriver struct{}
+
+func (mockDriver) Open(name string) (driver.Conn, error) {
+	m, ok := mocks.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("mysqltest: unknown mock %s", name)
+	}
+	return &mockConn{m: m.(*Mock)}, nil
+}
+
+type mockConn struct {
+	m *Mock
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockStmt{m: c.m, query: query}, nil
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *mockConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	e, err := c.m.next(kindBegin, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &mockTx{m: c.m}, nil
+}
+
+func (c *mockConn) Ping(ctx context.Context) error {
+	return nil
+}
+
+type mockTx struct {
+	m *Mock
+}
+
+func (tx *mockTx) Commit() error {
+	e, err := tx.m.next(kindCommit, "", nil)
+	if err != nil {
+		return err
+	}
+	return e.err
+}
+
+func (tx *mockTx) Rollback() error {
+	e, err := tx.m.next(kindRollback, "", nil)
+	if err != nil {
+		return err
+	}
+	return e.err
+}
+
+type mockStmt struct {
+	m     *Mock
+	query string
+}
+
+func (s *mockStmt) Close() error {
+	return nil
+}
+
+func (s *mockStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	e, err := s.m.next(kindExec, s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return mockResult{lastID: e.lastID, affected: e.affected}, nil
+}
+
+func (s *mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	e, err := s.m.next(kindQuery, s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.rows == nil {
+		return &mockRows{rows: &Rows{}}, nil
+	}
+	return &mockRows{rows: e.rows}, nil
+}
+
+type mockResult struct {
+	lastID   int64
+	affected int64
+}
+
+func (r mockResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r mockResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+//每次查询一个新的游标，同一个Rows可以用在多个预期里
+type mockRows struct {
+	rows *Rows
+	pos  int
+}
+
+func (r *mockRows) Columns() []string {
+	return r.rows.cols
+}
+
+func (r *mockRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.rows.types) {
+		return r.rows.types[i]
+	}
+	return ""
+}
+
+func (r *mockRows) Close() error {
+	return nil
+}
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows.rows) {
+		if r.rows.err != nil {
+			return r.rows.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.rows.rows[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,118 @@
+package mysqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/liuyongshuai/goutils/mysql"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+//要测试的业务代码，只依赖mysql.DB
+func rename(ctx context.Context, db mysql.DB, id int64, name string) error {
+	var u user
+	if err := db.FetchStructContext(ctx, &u, "SELECT * FROM `user` WHERE `id` = ?", id); err != nil {
+		return err
+	}
+	return db.WithTx(ctx, func(tx *mysql.TxBase) error {
+		if _, _, err := tx.UpdateDataContext(ctx, "user", map[string]interface{}{"name": name}, map[string]interface{}{"id": u.ID}); err != nil {
+			return err
+		}
+		_, _, err := tx.InsertDataContext(ctx, "user_log", map[string]interface{}{"uid": u.ID, "old": u.Name}, false)
+		return err
+	})
+}
+
+func TestMock(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	ctx := context.Background()
+	db, mock := New()
+	defer db.Close()
+
+	users := NewRows("id", "name").AddRow(1, "wendao")
+	mock.ExpectQuery("SELECT * FROM `user` WHERE `id` = ?").WithArgs(1).WillReturnRows(users)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `user` SET `name` = ?").WithArgs("abc", 1).WillReturnResult(0, 1)
+	mock.ExpectExec("INSERT INTO `user_log`").WithArgs("wendao", AnyArg).WillReturnResult(9, 1)
+	mock.ExpectCommit()
+	if err := rename(ctx, db, 1, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	//写失败时回滚
+	mock.ExpectQuery("FROM `user`").WillReturnRows(users)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `user`").WillReturnError(errors.New("read only"))
+	mock.ExpectRollback()
+	if err := rename(ctx, db, 1, "abc"); err == nil || err.Error() != "read only" {
+		t.Errorf("unexpected %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	//死锁时重试整个事务
+	mock.ExpectQuery("FROM `user`").WillReturnRows(users)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `user`").WillReturnError(&gomysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `user`").WillReturnResult(0, 1)
+	mock.ExpectExec("INSERT INTO `user_log`").WillReturnResult(10, 1)
+	mock.ExpectCommit()
+	if err := rename(ctx, db, 1, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	//预期之外的SQL、参数不对、没执行的预期
+	mock.ExpectQuery("FROM `user`").WithArgs(2)
+	mock.ExpectExec("DELETE FROM `user`")
+	if _, err := db.FetchRows("SELECT * FROM `user` WHERE `id` = ?", 3); err == nil || !strings.Contains(err.Error(), "arg 0") {
+		t.Errorf("unexpected %v", err)
+	}
+	err := mock.ExpectationsWereMet()
+	if err == nil || !strings.Contains(err.Error(), "unexpected query") || !strings.Contains(err.Error(), "not executed: exec \"DELETE FROM `user`\"") {
+		t.Errorf("unexpected %v", err)
+	}
+}
+
+func TestMockColumnTypes(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	f := runtime.FuncForPC(pc)
+	fmt.Printf("\n\n\n------%s--------\n", f.Name())
+	db, mock := New()
+	defer db.Close()
+	mock.MatchInOrder(false)
+	mock.ExpectQuery("FROM `b`").WillReturnRows(NewRows("n").AddRow(nil))
+	mock.ExpectQuery("FROM `a`").WillReturnRows(NewRows("price", "uid").ColumnTypes("DECIMAL", "UNSIGNED BIGINT").AddRow("1.10", []byte("7")))
+	row, err := db.FetchRow("SELECT * FROM `a`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["price"].RawData() != mysql.Decimal("1.10") || row["uid"].RawData() != uint64(7) {
+		t.Errorf("unexpected %#v", row)
+	}
+	row, err = db.FetchRow("SELECT * FROM `b`")
+	if err != nil || !row["n"].IsNull() {
+		t.Errorf("unexpected %#v %v", row, err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
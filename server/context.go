@@ -0,0 +1,205 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     server
+ * @date        2018-01-25 19:19
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/liuyongshuai/goutils/elem"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+//一次请求的上下文
+type ToFuContext struct {
+	Request *http.Request
+	Writer  *ResponseWriter
+	params  map[string]string      //路径参数
+	pattern string                 //匹配上的路由
+	query   url.Values             //解析后的查询参数
+	values  map[string]interface{} //中间件之间传递的数据
+}
+
+//实例化一个上下文
+func newContext(w http.ResponseWriter, req *http.Request) *ToFuContext {
+	return &ToFuContext{
+		Request: req,
+		Writer:  &ResponseWriter{ResponseWriter: w},
+	}
+}
+
+//提取路径参数，如"/user/:id"里的id，不存在时为空字符串
+func (c *ToFuContext) Param(k string) elem.ItemElem {
+	return elem.MakeItemElem(c.params[k])
+}
+
+//提取所有的路径参数
+func (c *ToFuContext) Params() map[string]elem.ItemElem {
+	ret := make(map[string]elem.ItemElem)
+	for k, v := range c.params {
+		ret[k] = elem.MakeItemElem(v)
+	}
+	return ret
+}
+
+//匹配上的路由，如"/user/:id"，未匹配上时为空
+func (c *ToFuContext) Pattern() string {
+	return c.pattern
+}
+
+//提取URL里的查询参数，不存在时为空字符串
+func (c *ToFuContext) Query(k string) elem.ItemElem {
+	if c.query == nil {
+		c.query = c.Request.URL.Query()
+	}
+	return elem.MakeItemElem(c.query.Get(k))
+}
+
+//提取URL里的查询参数，不存在时返回默认值
+func (c *ToFuContext) DefaultQuery(k string, def interface{}) elem.ItemElem {
+	if c.query == nil {
+		c.query = c.Request.URL.Query()
+	}
+	if vs, ok := c.query[k]; ok && len(vs) > 0 {
+		return elem.MakeItemElem(vs[0])
+	}
+	return elem.MakeItemElem(def)
+}
+
+//提取POST、PUT的表单字段（包括multipart），不存在时为空字符串
+func (c *ToFuContext) PostForm(k string) elem.ItemElem {
+	return elem.MakeItemElem(c.Request.PostFormValue(k))
+}
+
+//提取参数，依次从路径参数、表单、URL查询参数里找
+func (c *ToFuContext) Input(k string) elem.ItemElem {
+	if v, ok := c.params[k]; ok {
+		return elem.MakeItemElem(v)
+	}
+	return elem.MakeItemElem(c.Request.FormValue(k))
+}
+
+//提取请求的头信息
+func (c *ToFuContext) GetHeader(k string) string {
+	return c.Request.Header.Get(k)
+}
+
+//设置响应的头信息
+func (c *ToFuContext) SetHeader(k, v string) {
+	c.Writer.Header().Set(k, v)
+}
+
+//将请求的body当成JSON解析到v里
+func (c *ToFuContext) BindJSON(v interface{}) error {
+	if c.Request.Body == nil {
+		return fmt.Errorf("empty body")
+	}
+	return json.NewDecoder(c.Request.Body).Decode(v)
+}
+
+//设置中间件之间传递的数据
+func (c *ToFuContext) Set(k string, v interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[k] = v
+}
+
+//提取中间件之间传递的数据
+func (c *ToFuContext) Get(k string) (elem.ItemElem, bool) {
+	v, ok := c.values[k]
+	return elem.MakeItemElem(v), ok
+}
+
+//返回JSON
+func (c *ToFuContext) JSON(code int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(c.Writer, err.Error())
+		return err
+	}
+	c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.Writer.WriteHeader(code)
+	_, err = c.Writer.Write(data)
+	return err
+}
+
+//返回文本
+func (c *ToFuContext) Text(code int, format string, args ...interface{}) error {
+	c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Writer.WriteHeader(code)
+	_, err := fmt.Fprintf(c.Writer, format, args...)
+	return err
+}
+
+//返回错误信息，格式为{"code":404,"message":"Not Found"}
+func (c *ToFuContext) Error(code int, msg string) error {
+	return c.JSON(code, map[string]interface{}{
+		"code":    code,
+		"message": msg,
+	})
+}
+
+//只返回状态码
+func (c *ToFuContext) Status(code int) {
+	c.Writer.WriteHeader(code)
+}
+
+//重定向
+func (c *ToFuContext) Redirect(code int, location string) {
+	http.Redirect(c.Writer, c.Request, location, code)
+}
+
+//包装http.ResponseWriter，记录状态码及写入的字节数
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+//写入状态码，只有第一次有效
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+//写入body
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+//实现http.Flusher接口
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//响应的状态码，还未写入时为0
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+//已写入的字节数
+func (w *ResponseWriter) Size() int {
+	return w.size
+}
+
+//是否已经写入了状态码
+func (w *ResponseWriter) Written() bool {
+	return w.status != 0
+}
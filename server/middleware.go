@@ -0,0 +1,276 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     server
+ * @date        2018-01-25 19:19
+ */
+package server
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//请求ID的header名称，也是ToFuContext.Get用的key
+const RequestIDHeader = "X-Request-Id"
+
+//捕获处理方法里的panic，打印堆栈并返回500
+func Recovery(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ToFuContext) {
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					logf(logger, "panic: %v %s %s\n%s", err, c.Request.Method, c.Request.URL.Path, debug.Stack())
+					if !c.Writer.Written() {
+						c.Error(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					}
+				}
+			}()
+			next(c)
+		}
+	}
+}
+
+//访问日志：客户端地址、请求ID、方法、路径、状态码、字节数、耗时
+func AccessLog(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ToFuContext) {
+			start := time.Now()
+			next(c)
+			status := c.Writer.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			rid, _ := c.Get(RequestIDHeader)
+			logf(logger, "%s %s %s %s %d %d %v", c.Request.RemoteAddr, rid.ToString(),
+				c.Request.Method, c.Request.URL.RequestURI(), status, c.Writer.Size(), time.Since(start))
+		}
+	}
+}
+
+//请求ID：沿用请求里的X-Request-Id，没有时生成一个，并放到响应头里
+func RequestID() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ToFuContext) {
+			rid := c.GetHeader(RequestIDHeader)
+			if len(rid) == 0 || len(rid) > 128 {
+				rid = newRequestID()
+			}
+			c.Set(RequestIDHeader, rid)
+			c.SetHeader(RequestIDHeader, rid)
+			next(c)
+		}
+	}
+}
+
+//跨域的设置
+type CORSConf struct {
+	AllowOrigins     []string      //允许的来源，"*"表示全部
+	AllowMethods     []string      //允许的方法
+	AllowHeaders     []string      //允许的请求头
+	ExposeHeaders    []string      //允许浏览器读取的响应头
+	AllowCredentials bool          //是否允许带cookie
+	MaxAge           time.Duration //预检结果的缓存时间
+}
+
+//默认的跨域设置：允许所有来源及常用方法
+func MakeCORSConf() CORSConf {
+	return CORSConf{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", RequestIDHeader},
+		MaxAge:       12 * time.Hour,
+	}
+}
+
+//跨域，预检请求直接返回204，需作为全局中间件使用
+func CORS(conf CORSConf) Middleware {
+	allowAll := false
+	origins := make(map[string]bool)
+	for _, o := range conf.AllowOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		origins[strings.ToLower(o)] = true
+	}
+	methods := strings.Join(conf.AllowMethods, ", ")
+	headers := strings.Join(conf.AllowHeaders, ", ")
+	expose := strings.Join(conf.ExposeHeaders, ", ")
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ToFuContext) {
+			origin := c.GetHeader("Origin")
+			if len(origin) == 0 {
+				next(c)
+				return
+			}
+			h := c.Writer.Header()
+			h.Add("Vary", "Origin")
+			if !allowAll && !origins[strings.ToLower(origin)] {
+				if c.Request.Method == http.MethodOptions {
+					c.Status(http.StatusForbidden)
+					return
+				}
+				next(c)
+				return
+			}
+			//带cookie时不能用"*"
+			if allowAll && !conf.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if conf.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(expose) > 0 {
+				h.Set("Access-Control-Expose-Headers", expose)
+			}
+			//预检请求
+			if c.Request.Method == http.MethodOptions && len(c.GetHeader("Access-Control-Request-Method")) > 0 {
+				h.Set("Access-Control-Allow-Methods", methods)
+				if len(headers) > 0 {
+					h.Set("Access-Control-Allow-Headers", headers)
+				} else if rh := c.GetHeader("Access-Control-Request-Headers"); len(rh) > 0 {
+					h.Set("Access-Control-Allow-Headers", rh)
+				}
+				if conf.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(conf.MaxAge/time.Second)))
+				}
+				c.Status(http.StatusNoContent)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+//gzip压缩响应，客户端不支持gzip时不压缩
+func Gzip(level int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ToFuContext) {
+			if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") || c.Request.Method == http.MethodHead {
+				next(c)
+				return
+			}
+			gz, err := gzip.NewWriterLevel(c.Writer.ResponseWriter, level)
+			if err != nil {
+				next(c)
+				return
+			}
+			orig := c.Writer.ResponseWriter
+			gw := &gzipWriter{ResponseWriter: orig, gz: gz}
+			c.Writer.ResponseWriter = gw
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+			defer func() {
+				//panic时结束已经开始的gzip流，并换回原来的Writer，外层的Recovery再写时就不会被压缩
+				if err := recover(); err != nil {
+					c.Writer.ResponseWriter = orig
+					gw.close()
+					panic(err)
+				}
+				gw.close()
+			}()
+			next(c)
+		}
+	}
+}
+
+//gzip压缩的ResponseWriter，写入header时才决定是否压缩
+//压缩时若没有设置Content-Type，等第一次写入时根据未压缩的内容判断
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	code        int
+	wroteHeader bool
+	headerSent  bool
+	compress    bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	h := w.ResponseWriter.Header()
+	//没有body的或已经压缩过的不再压缩
+	w.compress = code != http.StatusNoContent && code != http.StatusNotModified &&
+		code >= http.StatusOK && len(h.Get("Content-Encoding")) == 0
+	if w.compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		if len(h.Get("Content-Type")) <= 0 {
+			return
+		}
+	}
+	w.sendHeader(nil)
+}
+
+//真正写入header，没有Content-Type时用未压缩的内容判断
+func (w *gzipWriter) sendHeader(b []byte) {
+	if w.headerSent {
+		return
+	}
+	w.headerSent = true
+	if h := w.ResponseWriter.Header(); w.compress && len(h.Get("Content-Type")) <= 0 {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.sendHeader(b)
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) Flush() {
+	if w.wroteHeader {
+		w.sendHeader(nil)
+	}
+	if w.compress {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipWriter) close() {
+	if w.compress {
+		w.sendHeader(nil)
+		w.gz.Close()
+	}
+}
+
+//生成一个随机的请求ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+//打日志，logger为nil时用默认的
+func logf(logger *log.Logger, format string, args ...interface{}) {
+	if logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	logger.Printf(format, args...)
+}
@@ -0,0 +1,261 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     server
+ * @date        2018-01-25 19:19
+ */
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//处理请求的方法
+type HandlerFunc func(c *ToFuContext)
+
+//中间件，包装一个处理方法返回新的处理方法
+type Middleware func(next HandlerFunc) HandlerFunc
+
+//路由，支持按请求方法匹配及路径参数：
+//"/user/:id"匹配"/user/123"，参数id为"123"
+//"/static/*path"匹配"/static/js/a.js"，参数path为"js/a.js"，只能放在最后
+//同时匹配多个路由时，静态的段越多越优先
+type ToFuRouter struct {
+	routes      map[string][]*route //method => 路由列表
+	middlewares []Middleware        //全局中间件，所有请求都会经过，包括404
+	notFound    HandlerFunc
+	notAllowed  HandlerFunc
+}
+
+//路由分组，共用前缀及中间件
+type ToFuGroup struct {
+	router      *ToFuRouter
+	prefix      string
+	middlewares []Middleware
+}
+
+//单条路由
+type route struct {
+	pattern  string
+	segments []string
+	statics  int //静态段的个数，用于排优先级
+	handler  HandlerFunc
+}
+
+//实例化一个路由
+func NewRouter() *ToFuRouter {
+	return &ToFuRouter{
+		routes: make(map[string][]*route),
+		notFound: func(c *ToFuContext) {
+			c.Error(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		},
+		notAllowed: func(c *ToFuContext) {
+			c.Error(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		},
+	}
+}
+
+//添加全局中间件，按添加的顺序从外到里执行
+func (r *ToFuRouter) Use(mws ...Middleware) *ToFuRouter {
+	r.middlewares = append(r.middlewares, mws...)
+	return r
+}
+
+//设置404时的处理方法
+func (r *ToFuRouter) NotFound(h HandlerFunc) *ToFuRouter {
+	r.notFound = h
+	return r
+}
+
+//设置405时的处理方法
+func (r *ToFuRouter) MethodNotAllowed(h HandlerFunc) *ToFuRouter {
+	r.notAllowed = h
+	return r
+}
+
+//添加路由，mws为仅对此路由生效的中间件
+func (r *ToFuRouter) Handle(method, pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	method = strings.ToUpper(method)
+	segs := splitPath(pattern)
+	rt := &route{pattern: pattern, segments: segs, handler: chain(h, mws)}
+	for i, s := range segs {
+		if strings.HasPrefix(s, "*") && i != len(segs)-1 {
+			panic("server: catch-all parameter must be the last segment: " + pattern)
+		}
+		if !strings.HasPrefix(s, ":") && !strings.HasPrefix(s, "*") {
+			rt.statics++
+		}
+	}
+	r.routes[method] = append(r.routes[method], rt)
+	//静态段多的排在前面，一样多的按添加顺序
+	sort.SliceStable(r.routes[method], func(i, j int) bool {
+		return r.routes[method][i].statics > r.routes[method][j].statics
+	})
+	return r
+}
+
+//GET请求
+func (r *ToFuRouter) GET(pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	return r.Handle(http.MethodGet, pattern, h, mws...)
+}
+
+//POST请求
+func (r *ToFuRouter) POST(pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	return r.Handle(http.MethodPost, pattern, h, mws...)
+}
+
+//PUT请求
+func (r *ToFuRouter) PUT(pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	return r.Handle(http.MethodPut, pattern, h, mws...)
+}
+
+//DELETE请求
+func (r *ToFuRouter) DELETE(pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	return r.Handle(http.MethodDelete, pattern, h, mws...)
+}
+
+//PATCH请求
+func (r *ToFuRouter) PATCH(pattern string, h HandlerFunc, mws ...Middleware) *ToFuRouter {
+	return r.Handle(http.MethodPatch, pattern, h, mws...)
+}
+
+//路由分组
+func (r *ToFuRouter) Group(prefix string, mws ...Middleware) *ToFuGroup {
+	return &ToFuGroup{router: r, prefix: strings.TrimSuffix(prefix, "/"), middlewares: mws}
+}
+
+//分组里添加路由
+func (g *ToFuGroup) Handle(method, pattern string, h HandlerFunc, mws ...Middleware) *ToFuGroup {
+	all := append(append([]Middleware{}, g.middlewares...), mws...)
+	g.router.Handle(method, g.prefix+pattern, h, all...)
+	return g
+}
+
+//分组里的GET请求
+func (g *ToFuGroup) GET(pattern string, h HandlerFunc, mws ...Middleware) *ToFuGroup {
+	return g.Handle(http.MethodGet, pattern, h, mws...)
+}
+
+//分组里的POST请求
+func (g *ToFuGroup) POST(pattern string, h HandlerFunc, mws ...Middleware) *ToFuGroup {
+	return g.Handle(http.MethodPost, pattern, h, mws...)
+}
+
+//分组里的PUT请求
+func (g *ToFuGroup) PUT(pattern string, h HandlerFunc, mws ...Middleware) *ToFuGroup {
+	return g.Handle(http.MethodPut, pattern, h, mws...)
+}
+
+//分组里的DELETE请求
+func (g *ToFuGroup) DELETE(pattern string, h HandlerFunc, mws ...Middleware) *ToFuGroup {
+	return g.Handle(http.MethodDelete, pattern, h, mws...)
+}
+
+//子分组
+func (g *ToFuGroup) Group(prefix string, mws ...Middleware) *ToFuGroup {
+	all := append(append([]Middleware{}, g.middlewares...), mws...)
+	return &ToFuGroup{router: g.router, prefix: g.prefix + strings.TrimSuffix(prefix, "/"), middlewares: all}
+}
+
+//实现http.Handler接口
+func (r *ToFuRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c := newContext(w, req)
+	chain(r.dispatch, r.middlewares)(c)
+}
+
+//找到匹配的路由并执行
+func (r *ToFuRouter) dispatch(c *ToFuContext) {
+	segs := splitPath(c.Request.URL.Path)
+	method := c.Request.Method
+	if rt, params := r.match(method, segs); rt != nil {
+		c.params = params
+		c.pattern = rt.pattern
+		rt.handler(c)
+		return
+	}
+	//HEAD请求没有单独注册的用GET的
+	if method == http.MethodHead {
+		if rt, params := r.match(http.MethodGet, segs); rt != nil {
+			c.params = params
+			c.pattern = rt.pattern
+			rt.handler(c)
+			return
+		}
+	}
+	//路径能匹配上其他的方法时返回405
+	var allow []string
+	for m := range r.routes {
+		if m == method {
+			continue
+		}
+		if rt, _ := r.match(m, segs); rt != nil {
+			allow = append(allow, m)
+		}
+	}
+	if len(allow) > 0 {
+		sort.Strings(allow)
+		c.Writer.Header().Set("Allow", strings.Join(allow, ", "))
+		r.notAllowed(c)
+		return
+	}
+	r.notFound(c)
+}
+
+//匹配路由
+func (r *ToFuRouter) match(method string, segs []string) (*route, map[string]string) {
+	for _, rt := range r.routes[method] {
+		if params, ok := rt.match(segs); ok {
+			return rt, params
+		}
+	}
+	return nil, nil
+}
+
+//单条路由是否匹配
+func (rt *route) match(segs []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "*") {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[s[1:]] = strings.Join(segs[i:], "/")
+			return params, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		if strings.HasPrefix(s, ":") {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[s[1:]] = segs[i]
+			continue
+		}
+		if s != segs[i] {
+			return nil, false
+		}
+	}
+	if len(segs) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+//按"/"切分路径
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if len(p) == 0 {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+//用中间件包装处理方法，第一个中间件在最外层
+func chain(h HandlerFunc, mws []Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToFuRouter(t *testing.T) {
+	fmt.Println(t.Name())
+	r := NewRouter()
+	r.GET("/user/:id", func(c *ToFuContext) {
+		id, err := c.Param("id").ToInt64()
+		if err != nil {
+			c.Error(http.StatusBadRequest, "invalid id")
+			return
+		}
+		c.JSON(http.StatusOK, map[string]interface{}{"id": id, "tab": c.DefaultQuery("tab", "info").ToString()})
+	})
+	r.GET("/user/me", func(c *ToFuContext) {
+		c.Text(http.StatusOK, "me")
+	})
+	r.GET("/static/*path", func(c *ToFuContext) {
+		c.Text(http.StatusOK, "%s", c.Param("path").ToString())
+	})
+	api := r.Group("/api/v1")
+	api.POST("/items", func(c *ToFuContext) {
+		c.Text(http.StatusCreated, "%s", c.PostForm("name").ToString())
+	})
+
+	cases := []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"GET", "/user/123?tab=posts", "", 200, `{"id":123,"tab":"posts"}`},
+		{"GET", "/user/123", "", 200, `{"id":123,"tab":"info"}`},
+		{"GET", "/user/me", "", 200, "me"},
+		{"GET", "/user/abc", "", 400, `{"code":400,"message":"invalid id"}`},
+		{"GET", "/static/js/app.js", "", 200, "js/app.js"},
+		{"POST", "/api/v1/items", "name=wendao", 201, "wendao"},
+		{"GET", "/api/v1/items", "", 405, `{"code":405,"message":"Method Not Allowed"}`},
+		{"HEAD", "/user/me", "", 200, ""},
+		{"GET", "/nothing", "", 404, `{"code":404,"message":"Not Found"}`},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if len(tc.body) > 0 {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code || (tc.method != "HEAD" && w.Body.String() != tc.want) {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, w.Code, w.Body.String(), tc.code, tc.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	fmt.Println(t.Name())
+	var logBuf bytes.Buffer
+	logger := log.New(&logBuf, "", 0)
+	r := NewRouter().Use(Recovery(logger), RequestID(), AccessLog(logger), CORS(MakeCORSConf()), Gzip(gzip.DefaultCompression))
+	r.GET("/panic", func(c *ToFuContext) {
+		panic("boom")
+	})
+	r.GET("/halfpanic", func(c *ToFuContext) {
+		c.Writer.Write([]byte("<html><body>half"))
+		panic("boom")
+	})
+	r.GET("/big", func(c *ToFuContext) {
+		c.Text(http.StatusOK, "%s", strings.Repeat("wendao", 100))
+	})
+
+	//panic返回500并打日志
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != 500 || !strings.Contains(logBuf.String(), "panic: boom") {
+		t.Errorf("recovery: %d %s", w.Code, logBuf.String())
+	}
+	if len(w.Header().Get(RequestIDHeader)) != 32 {
+		t.Errorf("request id not generated: %v", w.Header())
+	}
+
+	//沿用请求里的请求ID，gzip压缩
+	req := httptest.NewRequest("GET", "/big", nil)
+	req.Header.Set(RequestIDHeader, "rid-1")
+	req.Header.Set("Accept-Encoding", "gzip")
+	logBuf.Reset()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get(RequestIDHeader) != "rid-1" || !strings.Contains(logBuf.String(), "rid-1 GET /big 200 600") {
+		t.Errorf("request id / access log: %v %s", w.Header(), logBuf.String())
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("not gzipped: %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(gr)
+	if string(body) != strings.Repeat("wendao", 100) {
+		t.Errorf("gzip body: %q", body)
+	}
+
+	//写了一半panic时gzip流是完整的，没写时返回的500不压缩
+	for _, p := range []string{"/halfpanic", "/panic"} {
+		req = httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if p == "/panic" {
+			if w.Code != 500 || w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), "Internal Server Error") {
+				t.Errorf("gzip panic: %d %v %q", w.Code, w.Header(), w.Body.String())
+			}
+			continue
+		}
+		if w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("content type not detected: %v", w.Header())
+		}
+		gr, err = gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, err = ioutil.ReadAll(gr); err != nil || string(body) != "<html><body>half" {
+			t.Errorf("gzip half body: %q %v", body, err)
+		}
+	}
+
+	//跨域预检
+	req = httptest.NewRequest("OPTIONS", "/big", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("cors preflight: %d %v", w.Code, w.Header())
+	}
+}
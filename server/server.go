@@ -0,0 +1,111 @@
+/*
+ * @author      Liu Yongshuai<liuyongshuai@hotmail.com>
+ * @package     server
+ * @date        2018-01-25 19:19
+ */
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//http服务，收到SIGINT、SIGTERM时优雅退出
+type ToFuServer struct {
+	server          *http.Server
+	shutdownTimeout time.Duration //优雅退出时最多等待多久
+}
+
+//实例化一个服务，默认读超时30秒、写超时30秒、优雅退出最多等10秒
+func NewServer(addr string, handler http.Handler) *ToFuServer {
+	return &ToFuServer{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+		},
+		shutdownTimeout: 10 * time.Second,
+	}
+}
+
+//设置读超时
+func (s *ToFuServer) SetReadTimeout(t time.Duration) *ToFuServer {
+	s.server.ReadTimeout = t
+	return s
+}
+
+//设置写超时
+func (s *ToFuServer) SetWriteTimeout(t time.Duration) *ToFuServer {
+	s.server.WriteTimeout = t
+	return s
+}
+
+//设置长连接的空闲超时
+func (s *ToFuServer) SetIdleTimeout(t time.Duration) *ToFuServer {
+	s.server.IdleTimeout = t
+	return s
+}
+
+//设置优雅退出时最多等待多久
+func (s *ToFuServer) SetShutdownTimeout(t time.Duration) *ToFuServer {
+	s.shutdownTimeout = t
+	return s
+}
+
+//提取原始的http.Server，用于其他的设置
+func (s *ToFuServer) GetServer() *http.Server {
+	return s.server
+}
+
+//启动服务，收到SIGINT、SIGTERM时等待进行中的请求处理完再返回
+func (s *ToFuServer) Run() error {
+	l, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	return s.RunListener(l)
+}
+
+//在指定的listener上启动服务，退出方式同Run
+func (s *ToFuServer) RunListener(l net.Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Serve(ctx, l)
+}
+
+//在指定的listener上启动服务，ctx结束时优雅退出
+func (s *ToFuServer) Serve(ctx context.Context, l net.Listener) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.server.Serve(l)
+	}()
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	return s.Shutdown()
+}
+
+//优雅退出：不再接收新的连接，等待进行中的请求处理完，超时后强制关闭
+func (s *ToFuServer) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.server.Close()
+	}
+	return err
+}
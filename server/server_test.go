@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestToFuServer_Shutdown(t *testing.T) {
+	fmt.Println(t.Name())
+	r := NewRouter()
+	r.GET("/slow", func(c *ToFuContext) {
+		time.Sleep(200 * time.Millisecond)
+		c.Text(http.StatusOK, "done")
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := NewServer(l.Addr().String(), r)
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, l)
+	}()
+
+	//请求进行中时退出，请求仍然能正常完成
+	got := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		got <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if body := <-got; body != "done" {
+		t.Errorf("in-flight request: %s", body)
+	}
+	if err = <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	if _, err = http.Get("http://" + l.Addr().String() + "/slow"); err == nil {
+		t.Errorf("server still accepting connections")
+	}
+}